@@ -0,0 +1,457 @@
+package handler
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/logic"
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// The exit codes of Run.
+const (
+	ExitOK    = 0
+	ExitError = 1 // the command failed, e.g. ErrParse or ErrFuncNotFound
+	ExitUsage = 2 // bad subcommand or flags
+)
+
+const cliUsage = `Usage:
+  yeah_woo_go slice [flags] [input.json]   print the statements relevant to the variables, or affecting -target
+  yeah_woo_go callers [flags]              print the callers of a function
+  yeah_woo_go callees [flags]              print the functions called by a function
+  yeah_woo_go track [flags]                print the functions reading or writing a package var or field
+  yeah_woo_go ast [flags]                  print the NodeInfo tree of a file or function
+  yeah_woo_go serve [addr]                 answer requests over HTTP, default ` + DefaultServeAddr + `
+  yeah_woo_go lsp                          run a language server over stdio
+  yeah_woo_go [flags] input.json           run the method of the input file
+
+Run "yeah_woo_go <subcommand> -h" for the flags of a subcommand.
+`
+
+// StringsFlag is a flag which can be repeated, each value may also hold several values separated by ",".
+type StringsFlag []string
+
+func (f *StringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *StringsFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*f = append(*f, item)
+		}
+	}
+	return nil
+}
+
+// Run runs the command line args, without the program name, and returns the exit code.
+func Run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return ExitUsage
+	}
+	subcommandMap := map[string]func([]string) int{
+		"slice":   RunSlice,
+		"callers": RunCallers,
+		"callees": RunCallees,
+		"track":   RunTrack,
+		"ast":     RunAst,
+		"serve":   RunServe,
+		"lsp":     RunLsp,
+	}
+	if subcommand := subcommandMap[args[0]]; subcommand != nil {
+		return subcommand(args[1:])
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, cliUsage)
+		return ExitOK
+	}
+	return RunInputFile(args)
+}
+
+// RunInputFile runs the method of the input file, as the command line did before the subcommands.
+func RunInputFile(args []string) int {
+	flagSet := NewFlagSet("yeah_woo_go", "[flags] input.json")
+	writeOptions := AddWriteFlags(flagSet)
+	if code, ok := ParseFlags(flagSet, args); !ok {
+		return code
+	}
+	if flagSet.NArg() != 1 {
+		return UsageError(flagSet, "exactly one input file is needed")
+	}
+	if writeOptions.InPlace && writeOptions.OutputFile != "" {
+		return UsageError(flagSet, "-o and -in-place can not be used together")
+	}
+	return ExitCode(Handle(flagSet.Arg(0), writeOptions))
+}
+
+// RunSlice runs the task given by the flags, or the tasks of an input file like RunInputFile.
+func RunSlice(args []string) int {
+	flagSet := NewFlagSet("slice", "[flags] [input.json]")
+	funcTask := AddFuncTaskFlags(flagSet)
+	writeOptions := AddWriteFlags(flagSet)
+	format := flagSet.String("format", model.OutputFormatGo, `output format, "go" or "json"`)
+	positionMapFile := flagSet.String("position-map", "", "where to write the map from output lines to source positions")
+	flagSet.BoolVar(&funcTask.ShowReturn, "show-return", false, "keep the return statements")
+	flagSet.BoolVar(&funcTask.ShowBreak, "show-break", false, "keep the break statements")
+	flagSet.BoolVar(&funcTask.ShowContinue, "show-continue", false, "keep the continue statements")
+	flagSet.BoolVar(&funcTask.ShowAll, "show-all", false, "keep every statement")
+	flagSet.BoolVar(&funcTask.ControlDeps, "control-deps", false, "keep the jumps deciding whether a kept statement runs")
+	flagSet.BoolVar(&funcTask.ShowElided, "show-elided", false, `mark removed statements with "// ... N lines elided"`)
+	flagSet.BoolVar(&funcTask.EnableCall, "enable-call", false, "follow the calls of relevant functions")
+	flagSet.BoolVar(&funcTask.FindCallers, "find-callers", false, "look for the callers of the function")
+	flagSet.IntVar(&funcTask.CallerDepth, "depth", 0, "levels of callers to look for with -find-callers, 1 by default")
+	flagSet.StringVar(&funcTask.SliceTarget, "target", "", `keep only what affects "line:N", "return", "return:I" or "expr:E"`)
+	if code, ok := ParseFlags(flagSet, args); !ok {
+		return code
+	}
+
+	if flagSet.NArg() > 0 {
+		if funcTask.Source != "" || funcTask.FuncName != "" {
+			return UsageError(flagSet, "use either an input file or -file and -func")
+		}
+		if flagSet.NArg() > 1 {
+			return UsageError(flagSet, "only one input file is allowed")
+		}
+		if code, ok := CheckModeFlags(flagSet, true); !ok {
+			return code
+		}
+		if writeOptions.InPlace && writeOptions.OutputFile != "" {
+			return UsageError(flagSet, "-o and -in-place can not be used together")
+		}
+		return ExitCode(Handle(flagSet.Arg(0), writeOptions))
+	}
+	if code, ok := CheckFuncTaskFlags(flagSet, funcTask); !ok {
+		return code
+	}
+	if code, ok := CheckModeFlags(flagSet, false); !ok {
+		return code
+	}
+	// without variables, only -target or -show-all keep statements
+	if len(funcTask.VarNames) == 0 && funcTask.SliceTarget == "" && !funcTask.ShowAll {
+		return UsageError(flagSet, "-var is needed, unless -target or -show-all is given")
+	}
+	if *format != model.OutputFormatGo && *format != model.OutputFormatJson {
+		return UsageError(flagSet, fmt.Sprintf("unknown format %q", *format))
+	}
+
+	taskCtx := NewCliTaskCtx(*funcTask)
+	taskCtx.Input.OutputFormat = *format
+	taskCtx.Input.PositionMapFile = *positionMapFile
+	if err := AnalyzeRelevantFuncs(taskCtx); err != nil {
+		return ExitCode(err)
+	}
+	OutputRelevantFuncs(taskCtx, os.Stdout)
+	if taskCtx.Input.PositionMapFile != "" {
+		return ExitCode(WritePositionMap(taskCtx))
+	}
+	return ExitOK
+}
+
+// RunCallers prints the tree of the callers of the function, found with FindCallers.
+func RunCallers(args []string) int {
+	flagSet := NewFlagSet("callers", "[flags]")
+	funcTask := AddFuncTaskFlags(flagSet)
+	flagSet.IntVar(&funcTask.CallerDepth, "depth", 1, "levels of callers to look for")
+	flagSet.Var((*StringsFlag)(&funcTask.CallerRoots), "root", "directory to look for callers in, the module root by default, may be repeated")
+	format := flagSet.String("format", "tree", `output format, "tree", "go" or "json"`)
+	if code, ok := ParseFlags(flagSet, args); !ok {
+		return code
+	}
+	if code, ok := CheckFuncTaskFlags(flagSet, funcTask); !ok {
+		return code
+	}
+	funcTask.FindCallers = true
+	return RunCallTree(flagSet, funcTask, *format, func(result *model.FuncTaskResult) (map[model.FuncTaskKey]*model.FuncTaskResult, map[model.FuncTaskKey][]string) {
+		return result.CallerMap, result.CallerEdgeTags
+	})
+}
+
+// RunCallees prints the tree of the functions called by the function, found with EnableCall.
+// Without -var, every call of the function is followed.
+func RunCallees(args []string) int {
+	flagSet := NewFlagSet("callees", "[flags]")
+	funcTask := AddFuncTaskFlags(flagSet)
+	format := flagSet.String("format", "tree", `output format, "tree", "go" or "json"`)
+	if code, ok := ParseFlags(flagSet, args); !ok {
+		return code
+	}
+	if code, ok := CheckFuncTaskFlags(flagSet, funcTask); !ok {
+		return code
+	}
+	funcTask.EnableCall = true
+	if len(funcTask.VarNames) == 0 {
+		taskCtx := NewCliTaskCtx(*funcTask)
+		_, declInfo, err := logic.FindFuncNodeInfo(taskCtx, funcTask.Source, model.FuncKey{RecvTypes: funcTask.RecvTypes, Name: funcTask.FuncName})
+		if err != nil {
+			return ExitCode(err)
+		}
+		funcTask.VarNames = GetCalledFuncNames(declInfo.NodeInfo.Node.(*ast.FuncDecl))
+		funcTask.OnlyRelevantFunc = true
+	}
+	return RunCallTree(flagSet, funcTask, *format, func(result *model.FuncTaskResult) (map[model.FuncTaskKey]*model.FuncTaskResult, map[model.FuncTaskKey][]string) {
+		return result.CalleeMap, result.CalleeEdgeTags
+	})
+}
+
+// RunCallTree runs funcTask and prints the tree given by getEdges, or the results in format "go" or "json".
+func RunCallTree(flagSet *flag.FlagSet, funcTask *model.FuncTask, format string, getEdges func(*model.FuncTaskResult) (map[model.FuncTaskKey]*model.FuncTaskResult, map[model.FuncTaskKey][]string)) int {
+	if format != "tree" && format != model.OutputFormatGo && format != model.OutputFormatJson {
+		return UsageError(flagSet, fmt.Sprintf("unknown format %q", format))
+	}
+	taskCtx := NewCliTaskCtx(*funcTask)
+	if err := AnalyzeRelevantFuncs(taskCtx); err != nil {
+		return ExitCode(err)
+	}
+	if format != "tree" {
+		taskCtx.Input.OutputFormat = format
+		OutputRelevantFuncs(taskCtx, os.Stdout)
+		return ExitOK
+	}
+	result := taskCtx.FuncTaskMap[util.GetFuncTaskKey(*funcTask)]
+	PrintCallTree(os.Stdout, result, nil, 0, make(map[model.FuncTaskKey]bool), getEdges)
+	return ExitOK
+}
+
+// PrintCallTree prints the key of result and, indented below, the tree of the results given by getEdges.
+// A result already printed on the path is not expanded again.
+func PrintCallTree(w io.Writer, result *model.FuncTaskResult, edgeTags []string, depth int, onPath map[model.FuncTaskKey]bool, getEdges func(*model.FuncTaskResult) (map[model.FuncTaskKey]*model.FuncTaskResult, map[model.FuncTaskKey][]string)) {
+	key := util.GetFuncTaskKey(result.FuncTask)
+	line := strings.Repeat("    ", depth) + util.FuncTaskKeyToString(key)
+	if len(edgeTags) > 0 {
+		line += " [" + strings.Join(edgeTags, ",") + "]"
+	}
+	if onPath[key] {
+		fmt.Fprintln(w, line+" ...")
+		return
+	}
+	fmt.Fprintln(w, line)
+	onPath[key] = true
+	resultMap, edgeTagsMap := getEdges(result)
+	for _, nextResult := range GetSortedResults(resultMap) {
+		nextKey := util.GetFuncTaskKey(nextResult.FuncTask)
+		PrintCallTree(w, nextResult, edgeTagsMap[nextKey], depth+1, onPath, getEdges)
+	}
+	delete(onPath, key)
+}
+
+// RunTrack prints the functions reading or writing the package var or the field -name declared in the package of -file,
+// each sliced to the names it is used by, see Input.Globals, then the summary of the reads and writes.
+// With -summary, only the summary is printed, as a list of GlobalSites with -format json.
+func RunTrack(args []string) int {
+	flagSet := NewFlagSet("track", "[flags]")
+	globalTask := model.GlobalTask{}
+	flagSet.StringVar(&globalTask.Source, "file", "", "a go file of the package declaring the var or the type")
+	flagSet.StringVar(&globalTask.Name, "name", "", `package var or field, e.g. "config" or "Order.Status"`)
+	flagSet.StringVar(&globalTask.Scope, "scope", model.GlobalScopePackage, `where to look for the functions, "package" or "module"`)
+	flagSet.BoolVar(&globalTask.Template.ExactMatch, "exact", false, "match the variable names exactly")
+	flagSet.BoolVar(&globalTask.Template.Dataflow, "dataflow", false, "add the variables assigned from the relevant ones")
+	flagSet.BoolVar(&globalTask.Template.TypeCheck, "type-check", false, "resolve names with go/types")
+	flagSet.BoolVar(&globalTask.Template.EnableCall, "enable-call", false, "follow the calls of relevant functions")
+	format := flagSet.String("format", model.OutputFormatGo, `output format, "go" or "json"`)
+	summary := flagSet.Bool("summary", false, "print only the table of the reads and writes")
+	if code, ok := ParseFlags(flagSet, args); !ok {
+		return code
+	}
+	if globalTask.Source == "" || globalTask.Name == "" {
+		return UsageError(flagSet, "-file and -name are needed")
+	}
+	if flagSet.NArg() > 0 {
+		return UsageError(flagSet, fmt.Sprintf("unexpected argument %q", flagSet.Arg(0)))
+	}
+	if globalTask.Scope != model.GlobalScopePackage && globalTask.Scope != model.GlobalScopeModule {
+		return UsageError(flagSet, fmt.Sprintf("unknown scope %q", globalTask.Scope))
+	}
+	if *format != model.OutputFormatGo && *format != model.OutputFormatJson {
+		return UsageError(flagSet, fmt.Sprintf("unknown format %q", *format))
+	}
+
+	taskCtx := &model.TaskCtx{
+		Input: &model.Input{
+			Method:       "GetRelevantFuncs",
+			OutputFormat: *format,
+			Globals:      []model.GlobalTask{globalTask},
+		},
+		FileSet: token.NewFileSet(),
+	}
+	if *summary {
+		if err := AddGlobalFuncTasks(taskCtx); err != nil {
+			return ExitCode(err)
+		}
+		if *format == model.OutputFormatJson {
+			formattedJSON, err := FormatJSONObject(taskCtx.GlobalSites)
+			if err != nil {
+				return ExitCode(err)
+			}
+			fmt.Fprintln(os.Stdout, formattedJSON)
+			return ExitOK
+		}
+		PrintGlobalSites(os.Stdout, taskCtx.GlobalSites)
+		return ExitOK
+	}
+	if err := AnalyzeRelevantFuncs(taskCtx); err != nil {
+		return ExitCode(err)
+	}
+	OutputRelevantFuncs(taskCtx, os.Stdout)
+	return ExitOK
+}
+
+// RunAst prints the NodeInfo tree of the function -func of -file, or of the whole file.
+func RunAst(args []string) int {
+	flagSet := NewFlagSet("ast", "[flags]")
+	input := &model.Input{}
+	flagSet.StringVar(&input.FuncTask.Source, "file", "", "go file to print")
+	flagSet.StringVar(&input.FuncTask.FuncName, "func", "", "function to print, the whole file by default")
+	flagSet.StringVar(&input.FuncTask.RecvTypes, "recv", "", `receiver types of the method, e.g. "*Service"`)
+	if code, ok := ParseFlags(flagSet, args); !ok {
+		return code
+	}
+	if input.FuncTask.Source == "" || flagSet.NArg() > 0 {
+		return UsageError(flagSet, "-file is needed")
+	}
+	if input.FuncTask.FuncName == "" && input.FuncTask.RecvTypes != "" {
+		return UsageError(flagSet, "-recv needs -func")
+	}
+	if input.FuncTask.FuncName == "" {
+		return ExitCode(GetFileNodeInfo("", nil, input, &model.WriteOptions{}))
+	}
+	return ExitCode(GetFuncNodeInfo("", nil, input, &model.WriteOptions{}))
+}
+
+func RunServe(args []string) int {
+	if len(args) > 1 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return ExitUsage
+	}
+	addr := DefaultServeAddr
+	if len(args) > 0 {
+		addr = args[0]
+	}
+	return ExitCode(Serve(addr))
+}
+
+func RunLsp(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return ExitUsage
+	}
+	return ServeLsp(os.Stdin, os.Stdout)
+}
+
+// NewFlagSet returns a FlagSet which returns its errors, with the usage of the subcommand.
+func NewFlagSet(name string, argsUsage string) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.Usage = func() {
+		if name == "yeah_woo_go" {
+			fmt.Fprintf(flagSet.Output(), "Usage: yeah_woo_go %s\n", argsUsage)
+		} else {
+			fmt.Fprintf(flagSet.Output(), "Usage: yeah_woo_go %s %s\n", name, argsUsage)
+		}
+		flagSet.PrintDefaults()
+	}
+	return flagSet
+}
+
+// ParseFlags parses args, ok is false with the exit code when the command should stop, e.g. after -h.
+func ParseFlags(flagSet *flag.FlagSet, args []string) (int, bool) {
+	err := flagSet.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK, false
+	}
+	if err != nil {
+		return ExitUsage, false
+	}
+	return ExitOK, true
+}
+
+// UsageError prints msg and the usage of flagSet, and returns ExitUsage.
+func UsageError(flagSet *flag.FlagSet, msg string) int {
+	fmt.Fprintf(flagSet.Output(), "%s\n", msg)
+	flagSet.Usage()
+	return ExitUsage
+}
+
+// ExitCode prints err and returns its exit code.
+// For the tasks of an input file, it is ExitError when any task could not run.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	fmt.Fprintf(os.Stderr, "yeah_woo_go: %v\n", err)
+	return ExitError
+}
+
+// AddFuncTaskFlags adds the flags of a one-off task to flagSet.
+func AddFuncTaskFlags(flagSet *flag.FlagSet) *model.FuncTask {
+	funcTask := &model.FuncTask{}
+	flagSet.StringVar(&funcTask.Source, "file", "", "go file of the function")
+	flagSet.StringVar(&funcTask.FuncName, "func", "", "function name")
+	flagSet.StringVar(&funcTask.RecvTypes, "recv", "", `receiver types of the method, e.g. "*Service"`)
+	flagSet.Var((*StringsFlag)(&funcTask.VarNames), "var", "variable of interest, may be repeated or separated by \",\"")
+	flagSet.BoolVar(&funcTask.ExactMatch, "exact", false, "match the variable names exactly")
+	flagSet.BoolVar(&funcTask.SubsequenceMatch, "subsequence", false, "match the variable names as subsequences")
+	flagSet.BoolVar(&funcTask.Dataflow, "dataflow", false, "add the variables assigned from the relevant ones")
+	flagSet.BoolVar(&funcTask.TypeCheck, "type-check", false, "resolve names with go/types")
+	return funcTask
+}
+
+// AddWriteFlags adds the flags of the updated input file to flagSet.
+func AddWriteFlags(flagSet *flag.FlagSet) *model.WriteOptions {
+	writeOptions := &model.WriteOptions{}
+	flagSet.StringVar(&writeOptions.OutputFile, "o", "", "where to write the updated input, <input>.out.json by default")
+	flagSet.BoolVar(&writeOptions.InPlace, "in-place", false, "overwrite the input file with the updated input")
+	flagSet.BoolVar(&writeOptions.DryRun, "dry-run", false, "print the diff of the updated input instead of the code, write nothing")
+	return writeOptions
+}
+
+// writeFlagNames are the flags of AddWriteFlags.
+var writeFlagNames = []string{"o", "in-place", "dry-run"}
+
+// CheckModeFlags checks that the flags given apply to the mode of slice: only the flags of AddWriteFlags
+// with an input file, which holds the tasks and their options, and all the others without.
+func CheckModeFlags(flagSet *flag.FlagSet, isInputFile bool) (int, bool) {
+	var badFlag string
+	flagSet.Visit(func(f *flag.Flag) {
+		if badFlag == "" && slices.Contains(writeFlagNames, f.Name) != isInputFile {
+			badFlag = f.Name
+		}
+	})
+	if badFlag == "" {
+		return ExitOK, true
+	}
+	if isInputFile {
+		return UsageError(flagSet, fmt.Sprintf("-%s can not be used with an input file, set it in the input instead", badFlag)), false
+	}
+	return UsageError(flagSet, fmt.Sprintf("-%s only applies to an input file", badFlag)), false
+}
+
+// CheckFuncTaskFlags checks the flags of AddFuncTaskFlags.
+func CheckFuncTaskFlags(flagSet *flag.FlagSet, funcTask *model.FuncTask) (int, bool) {
+	if funcTask.Source == "" || funcTask.FuncName == "" {
+		return UsageError(flagSet, "-file and -func are needed"), false
+	}
+	if flagSet.NArg() > 0 {
+		return UsageError(flagSet, fmt.Sprintf("unexpected argument %q", flagSet.Arg(0))), false
+	}
+	return ExitOK, true
+}
+
+// NewCliTaskCtx returns a TaskCtx with funcTask as the only task.
+func NewCliTaskCtx(funcTask model.FuncTask) *model.TaskCtx {
+	return &model.TaskCtx{
+		Input: &model.Input{
+			Method: "GetRelevantFuncs",
+			Funcs:  []model.FuncTask{funcTask},
+		},
+		FileSet: token.NewFileSet(),
+	}
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"log"
+	"text/tabwriter"
+
+	"github.com/juicymango/yeah_woo_go/logic"
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// AddGlobalFuncTasks adds to Input.Funcs a task for each function reading or writing the globals of Input.Globals,
+// merged into the input task of the same function when there is one, so that running the updated input again
+// gives the same tasks. The functions are read in a TaskCtx of their own sharing the files of taskCtx,
+// so that the results of taskCtx start with the input funcs. It returns an error wrapping ErrGlobalNotFound
+// for each global not declared. The reads and writes found are in taskCtx.GlobalSites, see GetGlobalSites.
+func AddGlobalFuncTasks(taskCtx *model.TaskCtx) error {
+	if len(taskCtx.Input.Globals) == 0 {
+		return nil
+	}
+	if taskCtx.FileSet == nil {
+		taskCtx.FileSet = token.NewFileSet()
+	}
+	if taskCtx.FileInfoMap == nil {
+		taskCtx.FileInfoMap = make(map[string]*model.FileInfo)
+	}
+	if taskCtx.PackageInfoMap == nil {
+		taskCtx.PackageInfoMap = make(map[string]*model.PackageInfo)
+	}
+	if taskCtx.TypesPackageMap == nil {
+		taskCtx.TypesPackageMap = make(map[string]*model.TypesPackage)
+	}
+	if taskCtx.GoFilesMap == nil {
+		taskCtx.GoFilesMap = make(map[string][]string)
+	}
+	if taskCtx.FileErrorMap == nil {
+		taskCtx.FileErrorMap = make(map[string]error)
+	}
+	if taskCtx.FileSummaryMap == nil {
+		taskCtx.FileSummaryMap = make(map[string]*model.FileSummary)
+	}
+	globalCtx := &model.TaskCtx{
+		Input:           &model.Input{CacheDir: taskCtx.Input.CacheDir},
+		FileSet:         taskCtx.FileSet,
+		FileInfoMap:     taskCtx.FileInfoMap,
+		PackageInfoMap:  taskCtx.PackageInfoMap,
+		TypesPackageMap: taskCtx.TypesPackageMap,
+		StdImporter:     taskCtx.StdImporter,
+		GoFilesMap:      taskCtx.GoFilesMap,
+		FileCache:       taskCtx.FileCache,
+		FileErrorMap:    taskCtx.FileErrorMap,
+		FileSummaryMap:  taskCtx.FileSummaryMap,
+	}
+	var errs []error
+	taskCtx.GlobalSites = nil
+	for _, globalTask := range taskCtx.Input.Globals {
+		funcTasks, globalSites, err := logic.GetGlobalFuncTasks(globalCtx, globalTask)
+		if err != nil {
+			log.Printf("AddGlobalFuncTasks Err, global:%s, err:%+v", util.JsonString(globalTask), err)
+			errs = append(errs, err)
+			continue
+		}
+		for _, funcTask := range funcTasks {
+			MergeInputFuncTask(taskCtx.Input, funcTask)
+		}
+		taskCtx.GlobalSites = append(taskCtx.GlobalSites, GetGlobalSites(globalSites)...)
+	}
+	if taskCtx.StdImporter == nil {
+		taskCtx.StdImporter = globalCtx.StdImporter
+	}
+	return errors.Join(errs...)
+}
+
+// MergeInputFuncTask adds funcTask to input.Funcs, or its VarNames and GlobalAccess to the task of the same function.
+func MergeInputFuncTask(input *model.Input, funcTask model.FuncTask) {
+	funcTaskKey := util.GetFuncTaskKey(funcTask)
+	for idx := range input.Funcs {
+		inputFuncTask := &input.Funcs[idx]
+		if util.GetFuncTaskKey(*inputFuncTask) != funcTaskKey {
+			continue
+		}
+		inputFuncTask.VarNames = util.MergeAndDeduplicate(inputFuncTask.VarNames, funcTask.VarNames)
+		inputFuncTask.GlobalAccess = util.MergeAndDeduplicate(inputFuncTask.GlobalAccess, funcTask.GlobalAccess)
+		return
+	}
+	input.Funcs = append(input.Funcs, funcTask)
+}
+
+// GetGlobalSites returns the writes of globalSites, then the reads, each in the order of globalSites.
+func GetGlobalSites(globalSites []model.GlobalSite) []model.GlobalSite {
+	sortedSites := make([]model.GlobalSite, 0, len(globalSites))
+	for _, access := range []string{logic.GlobalAccessWrite, logic.GlobalAccessRead} {
+		for _, site := range globalSites {
+			if site.Access == access {
+				sortedSites = append(sortedSites, site)
+			}
+		}
+	}
+	return sortedSites
+}
+
+// PrintGlobalSites writes the summary of globalSites as a comment, a table of the writes and the reads of each global.
+func PrintGlobalSites(w io.Writer, globalSites []model.GlobalSite) {
+	fmt.Fprintln(w, "/*")
+	tabWriter := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for idx, site := range globalSites {
+		if idx == 0 || site.Global != globalSites[idx-1].Global {
+			fmt.Fprintf(tabWriter, "%s\n", site.Global)
+		}
+		fmt.Fprintf(tabWriter, "    %s\t%s:%d:%d\t%s\t%s\n", site.Access, site.Source, site.Line, site.Column, site.Key, site.Expr)
+	}
+	tabWriter.Flush()
+	fmt.Fprintln(w, "*/")
+}
@@ -2,23 +2,30 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go/ast"
 	"go/parser"
-	"go/printer"
 	"go/token"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 
 	"github.com/juicymango/yeah_woo_go/logic"
 	"github.com/juicymango/yeah_woo_go/model"
 	"github.com/juicymango/yeah_woo_go/util"
 )
 
-func Handle(filePath string) {
+// Handle runs the method of the input file filePath.
+func Handle(filePath string, writeOptions *model.WriteOptions) error {
 	// Read the JSON file from the provided path.
 	fileContent, err := os.ReadFile(filePath)
 	if err != nil {
-		log.Fatalf("Error reading file: %v", err)
+		return fmt.Errorf("read input file: %w", err)
 	}
 
 	// Declare a variable to hold the unmarshaled content.
@@ -27,42 +34,41 @@ func Handle(filePath string) {
 	// Unmarshal the JSON data into the Input structure.
 	err = json.Unmarshal(fileContent, &input)
 	if err != nil {
-		log.Fatalf("Error unmarshaling JSON: %v", err)
-		return
+		return fmt.Errorf("unmarshal input file: %w", err)
 	}
-	methodFuncMap := map[string]func(string, *model.Input){
+	methodFuncMap := map[string]func(string, []byte, *model.Input, *model.WriteOptions) error{
 		"GetRelevantFuncs": GetRelevantFuncs,
 		"GetFuncNodeInfo":  GetFuncNodeInfo,
 		"GetFileNodeInfo":  GetFileNodeInfo,
 	}
 	method := methodFuncMap[input.Method]
 	if method == nil {
-		log.Fatalf("unknown method, methods:%+v, input:%+v", methodFuncMap, input)
+		return fmt.Errorf("unknown method %q, methods: GetRelevantFuncs, GetFuncNodeInfo, GetFileNodeInfo", input.Method)
 	}
-	method(filePath, &input)
+	return method(filePath, fileContent, &input, writeOptions)
 }
 
-func GetFuncNodeInfo(filePath string, input *model.Input) {
-	// Create a new token file set which is needed for parsing
-	fset := token.NewFileSet()
-
-	// Parse the file containing the Go program
-	fileNode, err := parser.ParseFile(fset, input.FuncTask.Source, nil, parser.ParseComments)
+// GetFuncNodeInfo prints the NodeInfo of the function FuncName of RecvTypes, found like the functions of GetRelevantFuncs,
+// e.g. a method by "Svc" or "(s *Svc)", or in another file of the package of Source.
+func GetFuncNodeInfo(filePath string, fileContent []byte, input *model.Input, writeOptions *model.WriteOptions) error {
+	taskCtx := &model.TaskCtx{
+		Input:   input,
+		FileSet: token.NewFileSet(),
+	}
+	funcKey := model.FuncKey{RecvTypes: input.FuncTask.RecvTypes, Name: input.FuncTask.FuncName}
+	_, declInfo, err := logic.FindFuncNodeInfo(taskCtx, input.FuncTask.Source, funcKey)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	funcDecl := util.GetFunc(fileNode, input.FuncTask.FuncName)
-	nodeInfo := util.GetNodeInfo(funcDecl)
-	funcJson, jsonErr := json.Marshal(nodeInfo)
-	if jsonErr != nil {
-		log.Printf("GetFuncNodeInfo MarshalErr %+v", jsonErr)
-		return
+	funcJson, err := json.Marshal(declInfo.NodeInfo)
+	if err != nil {
+		return err
 	}
 	fmt.Println(string(funcJson))
+	return nil
 }
 
-func GetFileNodeInfo(filePath string, input *model.Input) {
+func GetFileNodeInfo(filePath string, fileContent []byte, input *model.Input, writeOptions *model.WriteOptions) error {
 	// Create a new token file set which is needed for parsing
 	fset := token.NewFileSet()
 
@@ -70,106 +76,273 @@ func GetFileNodeInfo(filePath string, input *model.Input) {
 	fileNode, err := parser.ParseFile(fset, input.FuncTask.Source, nil, parser.ParseComments)
 	if err != nil {
 		log.Printf("GetFileNodeInfo ParseFileErr, input.FuncTask %+v", util.JsonString(input.FuncTask))
-		panic(err)
+		return fmt.Errorf("%w: %w", model.ErrParse, err)
 	}
 
 	nodeInfo := util.GetNodeInfo(fileNode)
-	funcJson, jsonErr := json.Marshal(nodeInfo)
-	if jsonErr != nil {
-		log.Printf("GetFuncNodeInfo MarshalErr %+v", err)
-		return
+	funcJson, err := json.Marshal(nodeInfo)
+	if err != nil {
+		return err
 	}
 	fmt.Println(string(funcJson))
+	return nil
 }
 
-func GetRelevantFuncs(filePath string, input *model.Input) {
+// GetRelevantFuncs prints the simplified go code, and writes the updated input as writeOptions tells.
+// A dry run prints the diff of the updated input instead of the code, and writes nothing.
+func GetRelevantFuncs(filePath string, fileContent []byte, input *model.Input, writeOptions *model.WriteOptions) error {
 	taskCtx := &model.TaskCtx{
 		Input:   input,
 		FileSet: token.NewFileSet(),
 	}
+	var w io.Writer = os.Stdout
+	if writeOptions.DryRun {
+		w = io.Discard
+	}
+	// the tasks which could run are still written
+	taskErr := RunRelevantFuncs(taskCtx, w)
+
+	if taskCtx.Input.PositionMapFile != "" && !writeOptions.DryRun {
+		err := WritePositionMap(taskCtx)
+		if err != nil {
+			return errors.Join(taskErr, err)
+		}
+	}
+
+	return errors.Join(taskErr, WriteInput(filePath, fileContent, taskCtx.Input, writeOptions))
+}
+
+// RunRelevantFuncs runs the tasks of taskCtx.Input, writes the simplified go code to w,
+// and replaces taskCtx.Input.Funcs with the tasks and subtasks found.
+// It returns the error of AnalyzeRelevantFuncs, after writing the output of the other tasks.
+func RunRelevantFuncs(taskCtx *model.TaskCtx, w io.Writer) error {
+	err := AnalyzeRelevantFuncs(taskCtx)
+	OutputRelevantFuncs(taskCtx, w)
+	return err
+}
+
+// AnalyzeRelevantFuncs runs the tasks of taskCtx.Input and their subtasks, the results are in taskCtx.FuncTaskResults.
+// It returns a TaskError for each input task which could not run, wrapping ErrParse, ErrFuncNotFound or ErrFuncLimit,
+// and the error of taskCtx.Context when it is done. The other errors, e.g. ErrImportUnresolved, are in the Errors of the results.
+// The functions using Input.Globals are added to the input funcs first, see AddGlobalFuncTasks.
+func AnalyzeRelevantFuncs(taskCtx *model.TaskCtx) error {
+	globalErr := AddGlobalFuncTasks(taskCtx)
+	// the workers repeat the functions they share, which only pays off on several CPUs
+	if taskCtx.Input.Parallel > 1 && len(taskCtx.Input.Funcs) > 1 && taskCtx.MaxFuncs == 0 && runtime.GOMAXPROCS(0) > 1 {
+		return errors.Join(globalErr, AnalyzeRelevantFuncsParallel(taskCtx))
+	}
+	return errors.Join(globalErr, AnalyzeInputFuncs(taskCtx))
+}
+
+// AnalyzeInputFuncs analyzes the input funcs one after the other, see AnalyzeRelevantFuncs.
+func AnalyzeInputFuncs(taskCtx *model.TaskCtx) error {
+	AddInputFuncTaskResults(taskCtx)
 	for idx := 0; idx < len(taskCtx.Input.Funcs); idx++ {
-		taskCtx.Input.FuncTask = taskCtx.Input.Funcs[idx]
-		result := logic.GetFuncTaskResult(taskCtx)
-		result.IsFromInput = true
+		if taskCtx.Context != nil && taskCtx.Context.Err() != nil {
+			return errors.Join(taskCtx.Context.Err(), logic.GetTaskErrors(taskCtx))
+		}
+		AnalyzeInputFunc(taskCtx, idx)
 	}
+	return logic.GetTaskErrors(taskCtx)
+}
+
+// AddInputFuncTaskResults adds the results of the input funcs, before any of their callees and callers.
+// The input funcs get the Source and the RecvTypes of the declarations, see GetFuncTaskResult.
+func AddInputFuncTaskResults(taskCtx *model.TaskCtx) {
 	for idx := 0; idx < len(taskCtx.Input.Funcs); idx++ {
 		taskCtx.Input.FuncTask = taskCtx.Input.Funcs[idx]
 		result := logic.GetFuncTaskResult(taskCtx)
-		if result.FuncNodeInfo == nil {
-			log.Printf("GetRelevantFuncs FuncNodeInfoNil %+v", util.JsonString(&result.FuncTask))
-			continue
-		}
+		result.IsFromInput = true
+		taskCtx.Input.Funcs[idx].Source = result.FuncTask.Source
+		taskCtx.Input.Funcs[idx].RecvTypes = result.FuncTask.RecvTypes
+	}
+}
 
-		/*
-			funcCallNames := make([]string, 0, len(taskCtx.Input.FuncTask.FuncCalls))
-			for _, funcCall := range taskCtx.Input.FuncTask.FuncCalls {
-				_, pkg, funcName, err := util.ParseFuncCall(funcCall)
-				if err != nil {
-					continue
-				}
-				if pkg == "" {
-					funcCallNames = append(funcCallNames, funcName)
-				} else {
-					funcCallNames = append(funcCallNames, pkg+"."+funcName)
-				}
-			}
-			taskCtx.Input.FuncTask.VarNames = util.MergeAndDeduplicate(taskCtx.Input.FuncTask.VarNames, funcCallNames)
-		*/
+// AnalyzeInputFunc filters the relevant nodes of the input func idx, then of its callees and callers found,
+// until their VarNames stop growing.
+func AnalyzeInputFunc(taskCtx *model.TaskCtx, idx int) {
+	taskCtx.Input.FuncTask = taskCtx.Input.Funcs[idx]
+	result := logic.GetFuncTaskResult(taskCtx)
+	if result.FuncNodeInfo == nil {
+		log.Printf("GetRelevantFuncs FuncNodeInfoNil %+v", util.JsonString(&result.FuncTask))
+		return
+	}
 
-		if !logic.CheckNeedRunAndMergeVarNames(taskCtx, result) {
-			continue
+	/*
+		funcCallNames := make([]string, 0, len(taskCtx.Input.FuncTask.FuncCalls))
+		for _, funcCall := range taskCtx.Input.FuncTask.FuncCalls {
+			_, pkg, funcName, err := util.ParseFuncCall(funcCall)
+			if err != nil {
+				continue
+			}
+			if pkg == "" {
+				funcCallNames = append(funcCallNames, funcName)
+			} else {
+				funcCallNames = append(funcCallNames, pkg+"."+funcName)
+			}
 		}
-		result.FilterRelevantNodeInfo = logic.FilterRelevantNodeInfo(taskCtx, result.FuncNodeInfo)
+		taskCtx.Input.FuncTask.VarNames = util.MergeAndDeduplicate(taskCtx.Input.FuncTask.VarNames, funcCallNames)
+	*/
+
+	if logic.CheckNeedRunAndMergeVarNames(taskCtx, result) {
+		logic.EnqueueFuncTaskResult(taskCtx, result)
 	}
+	logic.RunWorklist(taskCtx)
+}
 
-	taskCtx.Input.Funcs = taskCtx.Input.Funcs[:0]
-	for _, result := range taskCtx.FuncTaskResults {
-		if !result.IsFromInput && (result.FilterRelevantNodeInfo == nil || !result.FilterRelevantNodeInfo.RelevantTaskResult.IsRelevant) {
-			continue
+// OutputRelevantFuncs writes the simplified go code of the relevant results to w,
+// then the summary of the GlobalSites, and replaces taskCtx.Input.Funcs with their tasks.
+func OutputRelevantFuncs(taskCtx *model.TaskCtx, writer io.Writer) {
+	w := &LineCountWriter{W: writer}
+	if taskCtx.Input.OutputFormat == model.OutputFormatJson {
+		formattedJSON, err := FormatJSONObject(GetJsonOutput(taskCtx))
+		if err != nil {
+			log.Printf("OutputRelevantFuncs FormatJSONObjectErr, err:%+v", err)
+			return
 		}
-		result.FuncTask.Key = util.FuncTaskKeyToString(util.GetFuncTaskKey(result.FuncTask))
-		logic.GenCalleeTree(result)
-		logic.GenCallerTree(result)
-		taskCtx.Input.Funcs = append(taskCtx.Input.Funcs, result.FuncTask)
-
+		fmt.Fprintln(w, formattedJSON)
+		return
+	}
+	if taskCtx.Input.OutputFormat != "" && taskCtx.Input.OutputFormat != model.OutputFormatGo {
+		log.Printf("OutputRelevantFuncs UnknownOutputFormat, outputFormat:%s", taskCtx.Input.OutputFormat)
+	}
+	if taskCtx.NotConverged {
+		// as converged in the JsonOutput
+		fmt.Fprintf(w, "// WARNING: not converged, max_iterations stopped the analysis after %d checks of functions, the functions still queued may miss variables\n\n", taskCtx.Iterations)
+	}
+	withPositions := taskCtx.Input.PositionMapFile != "" || taskCtx.WithPositions
+	for _, result := range GetOutputResults(taskCtx) {
 		// Extract only FuncTaskOutput fields from FuncTask
 		output := model.FuncTaskOutput{
-			Key:        result.FuncTask.Key,
-			Comments:   result.FuncTask.Comments,
-			CalleeTree: result.FuncTask.CalleeTree,
-			CallerTree: result.FuncTask.CallerTree,
+			Key:          result.FuncTask.Key,
+			Comments:     result.FuncTask.Comments,
+			CalleeTree:   result.FuncTask.CalleeTree,
+			CallerTree:   result.FuncTask.CallerTree,
+			GlobalAccess: result.FuncTask.GlobalAccess,
 		}
 
 		formattedJSON, err := FormatJSONObject(output)
 		if err == nil {
-			fmt.Printf("/*\n%s\n*/\n", formattedJSON)
+			fmt.Fprintf(w, "/*\n%s\n*/\n", formattedJSON)
 		}
 
 		// fmt.Printf("//\"key\": \"%s\",\n", result.FuncTask.Key)
-		fmt.Printf("//file://%s\n", result.FuncTask.Source)
+		fmt.Fprintf(w, "//file://%s\n", result.FuncTask.Source)
 		if result.FilterRelevantNodeInfo == nil {
 			continue
 		}
-		util.NodeInfoUpdateNode(result.FilterRelevantNodeInfo)
-		err = printer.Fprint(os.Stdout, taskCtx.FileSet, result.FilterRelevantNodeInfo.Node)
+		funcText, positionMap, err := PrintRelevantFunc(taskCtx, result, withPositions)
 		if err != nil {
 			log.Printf("GetRelevantFuncs FprintErr %+v", err)
 		}
-		fmt.Println()
-		fmt.Println()
+		for _, positionMapping := range positionMap {
+			positionMapping.OutputLine += w.Lines
+			taskCtx.PositionMap = append(taskCtx.PositionMap, positionMapping)
+		}
+		fmt.Fprint(w, funcText)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w)
 	}
+	if len(taskCtx.GlobalSites) > 0 {
+		PrintGlobalSites(w, taskCtx.GlobalSites)
+	}
+}
 
-	formattedJSON, err := FormatJSONObject(taskCtx.Input)
-	if err != nil {
-		log.Fatal(err)
+// GetOutputResults returns the results of the input tasks and the relevant results of the subtasks,
+// with their keys and call trees, and replaces taskCtx.Input.Funcs with their tasks.
+func GetOutputResults(taskCtx *model.TaskCtx) []*model.FuncTaskResult {
+	var results []*model.FuncTaskResult
+	taskCtx.Input.Funcs = taskCtx.Input.Funcs[:0]
+	for _, result := range taskCtx.FuncTaskResults {
+		if !result.IsFromInput && (result.FilterRelevantNodeInfo == nil || !result.FilterRelevantNodeInfo.RelevantTaskResult.IsRelevant) {
+			continue
+		}
+		result.FuncTask.Key = util.FuncTaskKeyToString(util.GetFuncTaskKey(result.FuncTask))
+		logic.GenCalleeTree(result)
+		logic.GenCallerTree(result)
+		taskCtx.Input.Funcs = append(taskCtx.Input.Funcs, result.FuncTask)
+		results = append(results, result)
 	}
+	return results
+}
 
-	// Write the formatted JSON to file
-	err = WriteToFile(filePath, formattedJSON)
-	if err != nil {
-		log.Fatal(err)
+// GetJsonOutput returns the JsonOutput of the results of GetOutputResults, with their code.
+func GetJsonOutput(taskCtx *model.TaskCtx) *model.JsonOutput {
+	jsonOutput := &model.JsonOutput{
+		Funcs:       make([]*model.FuncResultOutput, 0),
+		Iterations:  taskCtx.Iterations,
+		Converged:   !taskCtx.NotConverged,
+		GlobalSites: taskCtx.GlobalSites,
+	}
+	for _, result := range GetOutputResults(taskCtx) {
+		funcResultOutput := GetFuncResultOutput(taskCtx, result)
+		jsonOutput.Funcs = append(jsonOutput.Funcs, funcResultOutput)
+		if result.FilterRelevantNodeInfo == nil {
+			continue
+		}
+		funcText, positionMap, err := PrintRelevantFunc(taskCtx, result, true)
+		if err != nil {
+			log.Printf("GetJsonOutput FprintErr %+v", err)
+		}
+		funcResultOutput.Code = funcText
+		funcResultOutput.PositionMap = positionMap
+	}
+	return jsonOutput
+}
+
+// GetFuncResultOutput returns the JsonOutput of result, without the code.
+func GetFuncResultOutput(taskCtx *model.TaskCtx, result *model.FuncTaskResult) *model.FuncResultOutput {
+	funcResultOutput := &model.FuncResultOutput{
+		Key:              result.FuncTask.Key,
+		Source:           result.FuncTask.Source,
+		RecvTypes:        result.FuncTask.RecvTypes,
+		FuncName:         result.FuncTask.FuncName,
+		Comments:         result.FuncTask.Comments,
+		VarNames:         result.FuncTask.VarNames,
+		DataflowVarNames: result.FuncTask.DataflowVarNames,
+		GlobalAccess:     result.FuncTask.GlobalAccess,
+		IsFromInput:      result.IsFromInput,
+		KeptRanges:       make([]model.SourceRange, 0),
+		RemovedRanges:    make([]model.SourceRange, 0),
+		Matches:          make([]*model.Match, 0),
+		Callees:          GetCallEdges(result.CalleeMap, result.CalleeEdgeTags, result.CalleeTypeArgs),
+		Callers:          GetCallEdges(result.CallerMap, result.CallerEdgeTags, result.CallerTypeArgs),
+		PositionMap:      make([]model.PositionMapping, 0),
+	}
+	for _, err := range result.Errors {
+		funcResultOutput.Errors = append(funcResultOutput.Errors, err.Error())
 	}
+	// FilterRelevantNodeInfo visits the fields in map order
+	for _, match := range result.Matches {
+		if !slices.ContainsFunc(funcResultOutput.Matches, func(m *model.Match) bool { return *m == *match }) {
+			funcResultOutput.Matches = append(funcResultOutput.Matches, match)
+		}
+	}
+	slices.SortStableFunc(funcResultOutput.Matches, func(a, b *model.Match) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+	if result.FuncNodeInfo == nil {
+		return funcResultOutput
+	}
+	funcDecl, ok := result.FuncNodeInfo.Node.(*ast.FuncDecl)
+	if ok && result.FilterRelevantNodeInfo != nil {
+		funcResultOutput.KeptRanges, funcResultOutput.RemovedRanges = logic.GetSliceRanges(taskCtx.FileSet, funcDecl, result.FilterRelevantNodeInfo)
+	}
+	return funcResultOutput
+}
 
+func GetCallEdges(resultMap map[model.FuncTaskKey]*model.FuncTaskResult, edgeTags map[model.FuncTaskKey][]string, typeArgs map[model.FuncTaskKey][]string) []model.CallEdge {
+	callEdges := make([]model.CallEdge, 0, len(resultMap))
+	for key := range resultMap {
+		callEdges = append(callEdges, model.CallEdge{Key: util.FuncTaskKeyToString(key), EdgeTags: edgeTags[key], TypeArgs: typeArgs[key]})
+	}
+	slices.SortFunc(callEdges, func(a, b model.CallEdge) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return callEdges
 }
 
 // FormatJSONObject takes an interface{} object, marshals it into JSON, and formats it.
@@ -181,20 +354,35 @@ func FormatJSONObject(obj interface{}) (string, error) {
 	return string(formattedJSON), nil
 }
 
-// WriteToFile writes a string to a file.
+// WriteToFile writes a string to a file atomically: the data goes to a temporary file
+// in the same directory, which then replaces the file, so the file is never left half written.
 func WriteToFile(filename string, data string) error {
-	// Create a file or overwrite if it already exists
-	file, err := os.Create(filename)
+	file, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	tmpName := file.Name()
+	// fails harmlessly after the rename
+	defer os.Remove(tmpName)
 
-	// Write the data to the file
 	_, err = file.WriteString(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// CreateTemp makes the file private, keep the mode of the file replaced
+	mode := os.FileMode(0644)
+	if fileInfo, err := os.Stat(filename); err == nil {
+		mode = fileInfo.Mode().Perm()
+	}
+	if err = os.Chmod(tmpName, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
 }
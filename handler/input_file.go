@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// WriteInput writes the updated input as WriteOptions tells, keeping the keys of the input file
+// that Input does not know about, e.g. notes of the user.
+func WriteInput(filePath string, fileContent []byte, input *model.Input, writeOptions *model.WriteOptions) error {
+	formattedJSON, err := FormatInputJSON(fileContent, input)
+	if err != nil {
+		return err
+	}
+	outputFile := GetInputOutputFile(filePath, writeOptions)
+	if writeOptions.DryRun {
+		oldContent := string(fileContent)
+		if outputFile != filePath {
+			// the output file may not exist yet
+			content, err := os.ReadFile(outputFile)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			oldContent = string(content)
+		}
+		diff := util.UnifiedDiff(outputFile, outputFile, oldContent, formattedJSON)
+		if diff == "" {
+			diff = fmt.Sprintf("no change to %s\n", outputFile)
+		}
+		fmt.Print(diff)
+		return nil
+	}
+	return WriteToFile(outputFile, formattedJSON)
+}
+
+// GetInputOutputFile returns the file to write the updated input of filePath to.
+func GetInputOutputFile(filePath string, writeOptions *model.WriteOptions) string {
+	if writeOptions.InPlace {
+		return filePath
+	}
+	if writeOptions.OutputFile != "" {
+		return writeOptions.OutputFile
+	}
+	ext := filepath.Ext(filePath)
+	return strings.TrimSuffix(filePath, ext) + ".out" + ext
+}
+
+// FormatInputJSON formats input like FormatJSONObject, adding the unknown keys of the input file
+// to the top level object, to func_task, and to each task of funcs from its task in the input file.
+func FormatInputJSON(fileContent []byte, input *model.Input) (string, error) {
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	originalKeys, originalObject, err := GetJSONObjectKeys(fileContent)
+	if err != nil {
+		// e.g. not an object, nothing to keep
+		return FormatJSONObject(input)
+	}
+
+	var originalFuncs []json.RawMessage
+	if funcsJSON, ok := originalObject["funcs"]; ok {
+		_ = json.Unmarshal(funcsJSON, &originalFuncs)
+	}
+	funcTaskFields := GetJSONFieldNames(reflect.TypeOf(model.FuncTask{}))
+	originalIndices := GetOriginalFuncIndices(originalFuncs, input.Funcs)
+	funcsJSON := make([]json.RawMessage, 0, len(input.Funcs))
+	for idx, funcTask := range input.Funcs {
+		funcTaskJSON, err := json.Marshal(funcTask)
+		if err != nil {
+			return "", err
+		}
+		if originalIdx := originalIndices[idx]; originalIdx >= 0 {
+			funcTaskJSON = AddUnknownTaskKeys(funcTaskJSON, originalFuncs[originalIdx], funcTaskFields)
+		}
+		funcsJSON = append(funcsJSON, funcTaskJSON)
+	}
+
+	// replaces the funcs and the func_task of inputJSON with those keeping the unknown keys
+	var inputObject map[string]json.RawMessage
+	if err := json.Unmarshal(inputJSON, &inputObject); err != nil {
+		return "", err
+	}
+	if originalFuncTaskJSON, ok := originalObject["func_task"]; ok {
+		inputObject["func_task"] = AddUnknownTaskKeys(inputObject["func_task"], originalFuncTaskJSON, funcTaskFields)
+	}
+	inputObject["funcs"], err = json.Marshal(funcsJSON)
+	if err != nil {
+		return "", err
+	}
+	inputKeys, _, err := GetJSONObjectKeys(inputJSON)
+	if err != nil {
+		return "", err
+	}
+	inputJSON = AddUnknownJSONKeys(JoinJSONObject(inputKeys, inputObject), originalKeys, originalObject, GetJSONFieldNames(reflect.TypeOf(model.Input{})))
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, inputJSON, "", "    "); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// GetOriginalFuncIndices returns for each of funcs the index of the task of originalFuncs it comes from, or -1.
+// It is the task with the same FuncTaskKey, else, as a task gets the key of the declaration, e.g. b.go:*Svc|Do
+// for a.go:Svc|Do, the first task left with the same function name in the same directory.
+func GetOriginalFuncIndices(originalFuncs []json.RawMessage, funcs []model.FuncTask) []int {
+	originalTasks := make([]*model.FuncTask, len(originalFuncs))
+	for idx, originalFuncJSON := range originalFuncs {
+		var originalFuncTask model.FuncTask
+		if json.Unmarshal(originalFuncJSON, &originalFuncTask) == nil {
+			originalTasks[idx] = &originalFuncTask
+		}
+	}
+	getDir := func(source string) string {
+		absSource, err := filepath.Abs(source)
+		if err != nil {
+			absSource = source
+		}
+		return filepath.Dir(absSource)
+	}
+	indices := make([]int, len(funcs))
+	used := make(map[int]bool)
+	for idx, funcTask := range funcs {
+		indices[idx] = slices.IndexFunc(originalTasks, func(originalTask *model.FuncTask) bool {
+			return originalTask != nil && util.GetFuncTaskKey(*originalTask) == util.GetFuncTaskKey(funcTask)
+		})
+		used[indices[idx]] = true
+	}
+	for idx, funcTask := range funcs {
+		if indices[idx] >= 0 {
+			continue
+		}
+		for originalIdx, originalTask := range originalTasks {
+			if originalTask == nil || used[originalIdx] || originalTask.FuncName != funcTask.FuncName ||
+				getDir(originalTask.Source) != getDir(funcTask.Source) {
+				continue
+			}
+			indices[idx] = originalIdx
+			used[originalIdx] = true
+			break
+		}
+	}
+	return indices
+}
+
+// AddUnknownTaskKeys adds to funcTaskJSON the keys of the original task which are not FuncTask fields.
+func AddUnknownTaskKeys(funcTaskJSON []byte, originalFuncTaskJSON json.RawMessage, funcTaskFields map[string]bool) []byte {
+	funcKeys, funcObject, err := GetJSONObjectKeys(originalFuncTaskJSON)
+	if err != nil {
+		return funcTaskJSON
+	}
+	return AddUnknownJSONKeys(funcTaskJSON, funcKeys, funcObject, funcTaskFields)
+}
+
+// AddUnknownJSONKeys appends to objectJSON the keys of the original object which are not fields, in their order.
+func AddUnknownJSONKeys(objectJSON []byte, originalKeys []string, originalObject map[string]json.RawMessage, fields map[string]bool) []byte {
+	keys, object, err := GetJSONObjectKeys(objectJSON)
+	if err != nil {
+		return objectJSON
+	}
+	added := false
+	for _, key := range originalKeys {
+		if fields[key] {
+			continue
+		}
+		if _, ok := object[key]; !ok {
+			keys = append(keys, key)
+		}
+		object[key] = originalObject[key]
+		added = true
+	}
+	if !added {
+		return objectJSON
+	}
+	return JoinJSONObject(keys, object)
+}
+
+// GetJSONObjectKeys returns the keys of a JSON object in their order, and the values by key.
+func GetJSONObjectKeys(data []byte) ([]string, map[string]json.RawMessage, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, nil, err
+	}
+	if object == nil {
+		return nil, nil, fmt.Errorf("GetJSONObjectKeys NotObject")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// the opening brace
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, err
+	}
+	var keys []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := token.(string)
+		keys = append(keys, key)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, object, nil
+}
+
+// JoinJSONObject returns the compact JSON object of the keys in order.
+func JoinJSONObject(keys []string, object map[string]json.RawMessage) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for idx, key := range keys {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		keyJSON, _ := json.Marshal(key)
+		buf.Write(keyJSON)
+		buf.WriteByte(':')
+		buf.Write(object[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// GetJSONFieldNames returns the JSON names of the fields of the struct type t.
+func GetJSONFieldNames(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
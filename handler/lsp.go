@@ -0,0 +1,602 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"log"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/juicymango/yeah_woo_go/logic"
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+const (
+	LspCommandSlice = "yeah_woo_go.slice"
+
+	// the positionEncodings of the LSP
+	LspEncodingUtf8  = "utf-8"
+	LspEncodingUtf16 = "utf-16"
+
+	lspErrParse          = -32700
+	lspErrMethodNotFound = -32601
+	lspErrInvalidParams  = -32602
+	lspErrRequestFailed  = -32803
+
+	lspHighlightText  = 1
+	lspSymbolMethod   = 6
+	lspSymbolFunction = 12
+)
+
+// LspServer is a language server over stdio. The slice of the variable under the cursor is given
+// by documentHighlight and by the yeah_woo_go.slice command, and the callee and caller trees by call hierarchy.
+// Files are read from disk, unsaved changes are not seen.
+type LspServer struct {
+	reader    *bufio.Reader
+	writer    io.Writer
+	fileCache *model.FileCache
+	taskOpts  model.FuncTask
+	shutdown  bool
+	// the unit of the characters of the positions, LspEncodingUtf16 unless the client accepts LspEncodingUtf8
+	positionEncoding string
+}
+
+func NewLspServer(r io.Reader, w io.Writer) *LspServer {
+	return &LspServer{
+		reader:           bufio.NewReader(r),
+		writer:           w,
+		fileCache:        logic.NewFileCache(),
+		positionEncoding: LspEncodingUtf16,
+	}
+}
+
+// ServeLsp runs the language server until the exit notification, and returns the exit code.
+func ServeLsp(r io.Reader, w io.Writer) int {
+	s := NewLspServer(r, w)
+	for {
+		body, err := s.ReadMessage()
+		if err != nil {
+			log.Printf("ServeLsp ReadMessageErr, err:%+v", err)
+			return 1
+		}
+		var req model.LspRequest
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			s.WriteResponse(nil, nil, &model.LspError{Code: lspErrParse, Message: err.Error()})
+			continue
+		}
+		if req.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		result, lspErr := s.HandleRequest(&req)
+		// notifications have no response
+		if req.Id == nil {
+			continue
+		}
+		s.WriteResponse(req.Id, result, lspErr)
+	}
+}
+
+// ReadMessage reads the body of a message with a Content-Length header.
+func (s *LspServer) ReadMessage() ([]byte, error) {
+	header, err := textproto.NewReader(s.reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(s.reader, body)
+	return body, err
+}
+
+func (s *LspServer) WriteResponse(id *json.RawMessage, result any, lspErr *model.LspError) {
+	resp := model.LspResponse{JsonRpc: "2.0", Id: id, Error: lspErr}
+	if lspErr == nil {
+		resultJson, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &model.LspError{Code: lspErrRequestFailed, Message: err.Error()}
+		} else {
+			resp.Result = resultJson
+		}
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("WriteResponse MarshalErr, err:%+v", err)
+		return
+	}
+	_, err = fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	if err != nil {
+		log.Printf("WriteResponse WriteErr, err:%+v", err)
+	}
+}
+
+func (s *LspServer) HandleRequest(req *model.LspRequest) (any, *model.LspError) {
+	log.Printf("HandleRequest method:%s", req.Method)
+	switch req.Method {
+	case "initialize":
+		var params model.LspInitializeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &model.LspError{Code: lspErrInvalidParams, Message: err.Error()}
+		}
+		if params.InitializationOptions != nil {
+			s.taskOpts = *params.InitializationOptions
+			util.SetSubTask(&s.taskOpts)
+		}
+		// the byte offsets of go/token, without converting the positions
+		if slices.Contains(params.Capabilities.General.PositionEncodings, LspEncodingUtf8) {
+			s.positionEncoding = LspEncodingUtf8
+		}
+		return map[string]any{
+			"capabilities": map[string]any{
+				"positionEncoding":          s.positionEncoding,
+				"documentHighlightProvider": true,
+				"callHierarchyProvider":     true,
+				"executeCommandProvider": map[string]any{
+					"commands": []string{LspCommandSlice},
+				},
+			},
+			"serverInfo": map[string]any{"name": "yeah_woo_go"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/documentHighlight":
+		var params model.LspTextDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &model.LspError{Code: lspErrInvalidParams, Message: err.Error()}
+		}
+		slice, err := s.Slice(&params)
+		if err != nil {
+			return nil, &model.LspError{Code: lspErrRequestFailed, Message: err.Error()}
+		}
+		highlights := make([]model.LspDocumentHighlight, 0)
+		if slice != nil {
+			for _, lspRange := range slice.Kept {
+				highlights = append(highlights, model.LspDocumentHighlight{Range: lspRange, Kind: lspHighlightText})
+			}
+		}
+		return highlights, nil
+	case "workspace/executeCommand":
+		var params model.LspExecuteCommandParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &model.LspError{Code: lspErrInvalidParams, Message: err.Error()}
+		}
+		if params.Command != LspCommandSlice || len(params.Arguments) != 1 {
+			return nil, &model.LspError{Code: lspErrInvalidParams, Message: "unknown command or arguments: " + params.Command}
+		}
+		var positionParams model.LspTextDocumentPositionParams
+		if err := json.Unmarshal(params.Arguments[0], &positionParams); err != nil {
+			return nil, &model.LspError{Code: lspErrInvalidParams, Message: err.Error()}
+		}
+		slice, err := s.Slice(&positionParams)
+		if err != nil {
+			return nil, &model.LspError{Code: lspErrRequestFailed, Message: err.Error()}
+		}
+		return slice, nil
+	case "textDocument/prepareCallHierarchy":
+		var params model.LspTextDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &model.LspError{Code: lspErrInvalidParams, Message: err.Error()}
+		}
+		return s.PrepareCallHierarchy(&params), nil
+	case "callHierarchy/incomingCalls":
+		var params model.LspCallHierarchyCallsParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Item.Data == nil {
+			return nil, &model.LspError{Code: lspErrInvalidParams, Message: "invalid call hierarchy item"}
+		}
+		return s.IncomingCalls(*params.Item.Data), nil
+	case "callHierarchy/outgoingCalls":
+		var params model.LspCallHierarchyCallsParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Item.Data == nil {
+			return nil, &model.LspError{Code: lspErrInvalidParams, Message: "invalid call hierarchy item"}
+		}
+		return s.OutgoingCalls(*params.Item.Data), nil
+	}
+	if req.Id == nil {
+		// initialized, didOpen, didChange...
+		return nil, nil
+	}
+	return nil, &model.LspError{Code: lspErrMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+// NewLspPositions returns the LspPositions of a request.
+func (s *LspServer) NewLspPositions(taskCtx *model.TaskCtx) *LspPositions {
+	return &LspPositions{
+		FileSet:  taskCtx.FileSet,
+		Utf16:    s.positionEncoding == LspEncodingUtf16,
+		contents: make(map[string][]byte),
+	}
+}
+
+// NewTaskCtx returns a TaskCtx sharing the parsed files with the previous requests.
+func (s *LspServer) NewTaskCtx() *model.TaskCtx {
+	util.ResetModuleCache()
+	return &model.TaskCtx{
+		Input:     &model.Input{Method: "GetRelevantFuncs"},
+		FileSet:   logic.StartFileCacheRun(s.fileCache),
+		FileCache: s.fileCache,
+	}
+}
+
+// NewFuncTask returns a task with the options of initialize for a function.
+func (s *LspServer) NewFuncTask(funcTaskKey model.FuncTaskKey) model.FuncTask {
+	funcTask := s.taskOpts
+	funcTask.Source = funcTaskKey.Source
+	funcTask.RecvTypes = funcTaskKey.RecvTypes
+	funcTask.FuncName = funcTaskKey.FuncName
+	return funcTask
+}
+
+// RunFuncTask analyzes funcTask and returns its result.
+func (s *LspServer) RunFuncTask(taskCtx *model.TaskCtx, funcTask model.FuncTask) *model.FuncTaskResult {
+	taskCtx.Input.Funcs = []model.FuncTask{funcTask}
+	if err := AnalyzeRelevantFuncs(taskCtx); err != nil {
+		log.Printf("RunFuncTask Err, err:%+v", err)
+	}
+	return taskCtx.FuncTaskMap[util.GetFuncTaskKey(funcTask)]
+}
+
+// Slice runs the task of the function at the cursor with the variable under the cursor as VarNames.
+// It returns nil when the cursor is not on a variable inside a function.
+func (s *LspServer) Slice(params *model.LspTextDocumentPositionParams) (*model.LspSlice, error) {
+	taskCtx := s.NewTaskCtx()
+	positions := s.NewLspPositions(taskCtx)
+	funcTaskKey, funcDecl, pos := GetFuncAtPosition(taskCtx, positions, UriToPath(params.TextDocument.Uri), params.Position)
+	if funcDecl == nil {
+		return nil, nil
+	}
+	varName := GetVarNameAtPos(funcDecl, pos)
+	if varName == "" {
+		return nil, nil
+	}
+	funcTask := s.NewFuncTask(funcTaskKey)
+	funcTask.VarNames = []string{varName}
+	result := s.RunFuncTask(taskCtx, funcTask)
+	if result == nil || result.FilterRelevantNodeInfo == nil {
+		return nil, fmt.Errorf("no result for %s", util.FuncTaskKeyToString(funcTaskKey))
+	}
+	kept, removed := GetLspSliceRanges(positions, funcDecl, result.FilterRelevantNodeInfo)
+	return &model.LspSlice{
+		Uri:     params.TextDocument.Uri,
+		FuncKey: util.FuncTaskKeyToString(funcTaskKey),
+		VarName: varName,
+		Kept:    kept,
+		Removed: removed,
+		Task:    &funcTask,
+	}, nil
+}
+
+func (s *LspServer) PrepareCallHierarchy(params *model.LspTextDocumentPositionParams) []model.LspCallHierarchyItem {
+	taskCtx := s.NewTaskCtx()
+	positions := s.NewLspPositions(taskCtx)
+	funcTaskKey, funcDecl, _ := GetFuncAtPosition(taskCtx, positions, UriToPath(params.TextDocument.Uri), params.Position)
+	if funcDecl == nil {
+		return nil
+	}
+	return []model.LspCallHierarchyItem{*GetCallHierarchyItem(positions, funcTaskKey, funcDecl)}
+}
+
+// IncomingCalls finds the callers of the function with FindCallers.
+func (s *LspServer) IncomingCalls(funcTaskKey model.FuncTaskKey) []model.LspCallHierarchyIncomingCall {
+	taskCtx := s.NewTaskCtx()
+	funcTask := s.NewFuncTask(funcTaskKey)
+	funcTask.FindCallers = true
+	funcTask.CallerDepth = 1
+	result := s.RunFuncTask(taskCtx, funcTask)
+	if result == nil {
+		return nil
+	}
+	positions := s.NewLspPositions(taskCtx)
+	calls := make([]model.LspCallHierarchyIncomingCall, 0, len(result.CallerMap))
+	for _, callerResult := range GetSortedResults(result.CallerMap) {
+		callerDecl, ok := callerResult.FuncNodeInfo.Node.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		callerKey := util.GetFuncTaskKey(callerResult.FuncTask)
+		calls = append(calls, model.LspCallHierarchyIncomingCall{
+			From:       *GetCallHierarchyItem(positions, callerKey, callerDecl),
+			FromRanges: GetCallRanges(positions, callerDecl, funcTaskKey.FuncName),
+		})
+	}
+	return calls
+}
+
+// OutgoingCalls resolves the calls of the function with EnableCall, one level deep.
+func (s *LspServer) OutgoingCalls(funcTaskKey model.FuncTaskKey) []model.LspCallHierarchyOutgoingCall {
+	taskCtx := s.NewTaskCtx()
+	funcTask := s.NewFuncTask(funcTaskKey)
+	funcTask.EnableCall = true
+	funcTask.OnlyRelevantFunc = true
+	fileInfo := logic.GetFileInfoBySource(taskCtx, funcTaskKey.Source)
+	if fileInfo == nil {
+		return nil
+	}
+	funcNodeInfo := fileInfo.FuncMap[model.FuncKey{RecvTypes: funcTaskKey.RecvTypes, Name: funcTaskKey.FuncName}]
+	if funcNodeInfo == nil {
+		return nil
+	}
+	funcDecl := funcNodeInfo.Node.(*ast.FuncDecl)
+	funcTask.VarNames = GetCalledFuncNames(funcDecl)
+	result := s.RunFuncTask(taskCtx, funcTask)
+	if result == nil {
+		return nil
+	}
+	positions := s.NewLspPositions(taskCtx)
+	calls := make([]model.LspCallHierarchyOutgoingCall, 0, len(result.CalleeMap))
+	for _, calleeResult := range GetSortedResults(result.CalleeMap) {
+		calleeDecl, ok := calleeResult.FuncNodeInfo.Node.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		calleeKey := util.GetFuncTaskKey(calleeResult.FuncTask)
+		calls = append(calls, model.LspCallHierarchyOutgoingCall{
+			To:         *GetCallHierarchyItem(positions, calleeKey, calleeDecl),
+			FromRanges: GetCallRanges(positions, funcDecl, calleeKey.FuncName),
+		})
+	}
+	return calls
+}
+
+func GetSortedResults(resultMap map[model.FuncTaskKey]*model.FuncTaskResult) []*model.FuncTaskResult {
+	results := make([]*model.FuncTaskResult, 0, len(resultMap))
+	for _, result := range resultMap {
+		if result.FuncNodeInfo != nil {
+			results = append(results, result)
+		}
+	}
+	slices.SortFunc(results, func(a, b *model.FuncTaskResult) int {
+		return strings.Compare(util.FuncTaskKeyToString(util.GetFuncTaskKey(a.FuncTask)), util.FuncTaskKeyToString(util.GetFuncTaskKey(b.FuncTask)))
+	})
+	return results
+}
+
+// GetFuncAtPosition returns the function declared around position in source.
+func GetFuncAtPosition(taskCtx *model.TaskCtx, positions *LspPositions, source string, position model.LspPosition) (model.FuncTaskKey, *ast.FuncDecl, token.Pos) {
+	fileInfo := logic.GetFileInfoBySource(taskCtx, source)
+	if fileInfo == nil {
+		return model.FuncTaskKey{}, nil, token.NoPos
+	}
+	pos := positions.GetPos(fileInfo.NodeInfo.Node.Pos(), position)
+	if !pos.IsValid() {
+		return model.FuncTaskKey{}, nil, token.NoPos
+	}
+	for funcKey, funcNodeInfo := range fileInfo.FuncMap {
+		funcDecl := funcNodeInfo.Node.(*ast.FuncDecl)
+		if funcDecl.Pos() <= pos && pos <= funcDecl.End() {
+			funcTaskKey := model.FuncTaskKey{Source: fileInfo.Source, RecvTypes: funcKey.RecvTypes, FuncName: funcKey.Name}
+			return funcTaskKey, funcDecl, pos
+		}
+	}
+	return model.FuncTaskKey{}, nil, token.NoPos
+}
+
+// GetVarNameAtPos returns the name under the cursor in the form of VarNames, e.g. "a.B" when the cursor is on B in a.B.C.
+// It returns "" unless the name starts with a receiver, a parameter or a local of funcDecl, see logic.GetLocalNames,
+// so not for a package name, a type or a function.
+func GetVarNameAtPos(funcDecl *ast.FuncDecl, pos token.Pos) string {
+	localNames := logic.GetLocalNames(funcDecl)
+	varName := ""
+	ast.Inspect(funcDecl, func(n ast.Node) bool {
+		if n == nil || pos < n.Pos() || pos > n.End() {
+			return false
+		}
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			if x.Sel.Pos() <= pos && pos <= x.Sel.End() {
+				if nameParts := logic.GetSelectorExprNameParts(x); len(nameParts) > 0 {
+					if root := logic.GetLeftmostIdent(x); root != nil && logic.IsLocalName(localNames, root) {
+						varName = strings.Join(nameParts, ".")
+					}
+					return false
+				}
+			}
+		case *ast.Ident:
+			if logic.IsLocalName(localNames, x) {
+				varName = x.Name
+			}
+		}
+		return true
+	})
+	return varName
+}
+
+// GetCalledFuncNames returns the names of the functions called in funcDecl.
+func GetCalledFuncNames(funcDecl *ast.FuncDecl) []string {
+	var funcNames []string
+	ast.Inspect(funcDecl, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		switch fun := ast.Unparen(util.StripTypeArgs(call.Fun)).(type) {
+		case *ast.Ident:
+			funcNames = append(funcNames, fun.Name)
+		case *ast.SelectorExpr:
+			funcNames = append(funcNames, fun.Sel.Name)
+		}
+		return true
+	})
+	return util.MergeAndDeduplicate(nil, funcNames)
+}
+
+// GetCallRanges returns the ranges of the calls of funcName in funcDecl.
+func GetCallRanges(positions *LspPositions, funcDecl *ast.FuncDecl, funcName string) []model.LspRange {
+	ranges := make([]model.LspRange, 0)
+	ast.Inspect(funcDecl, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		switch fun := ast.Unparen(util.StripTypeArgs(call.Fun)).(type) {
+		case *ast.Ident:
+			if fun.Name == funcName {
+				ranges = append(ranges, positions.GetRange(call.Pos(), call.End()))
+			}
+		case *ast.SelectorExpr:
+			if fun.Sel.Name == funcName {
+				ranges = append(ranges, positions.GetRange(call.Pos(), call.End()))
+			}
+		}
+		return true
+	})
+	return ranges
+}
+
+// GetLspSliceRanges returns the ranges of the kept and of the removed statements of funcDecl.
+func GetLspSliceRanges(positions *LspPositions, funcDecl *ast.FuncDecl, filterRelevantNodeInfo *model.NodeInfo) ([]model.LspRange, []model.LspRange) {
+	kept, removed := logic.GetSliceRanges(positions.FileSet, funcDecl, filterRelevantNodeInfo)
+	file := positions.FileSet.File(funcDecl.Pos())
+	return positions.GetRanges(file, kept), positions.GetRanges(file, removed)
+}
+
+func GetCallHierarchyItem(positions *LspPositions, funcTaskKey model.FuncTaskKey, funcDecl *ast.FuncDecl) *model.LspCallHierarchyItem {
+	item := &model.LspCallHierarchyItem{
+		Name:           funcTaskKey.FuncName,
+		Kind:           lspSymbolFunction,
+		Uri:            PathToUri(funcTaskKey.Source),
+		Range:          positions.GetRange(funcDecl.Pos(), funcDecl.End()),
+		SelectionRange: positions.GetRange(funcDecl.Name.Pos(), funcDecl.Name.End()),
+		Data:           &funcTaskKey,
+	}
+	if funcTaskKey.RecvTypes != "" {
+		item.Kind = lspSymbolMethod
+		item.Detail = "(" + funcTaskKey.RecvTypes + ")"
+	}
+	return item
+}
+
+// LspPositions converts between the positions of go/token, whose columns count bytes, and those of the LSP,
+// whose characters count UTF-16 code units unless the client accepts LspEncodingUtf8 in initialize.
+type LspPositions struct {
+	FileSet  *token.FileSet
+	Utf16    bool
+	contents map[string][]byte // file name -> content, read from disk once per request to count the UTF-16 units
+}
+
+// GetPos converts a position of the file starting at filePos, NoPos when the line is not in the file.
+// A character past the end of its line is at the end of the line.
+func (p *LspPositions) GetPos(filePos token.Pos, position model.LspPosition) token.Pos {
+	file := p.FileSet.File(filePos)
+	if file == nil || position.Line < 0 || position.Line >= file.LineCount() {
+		return token.NoPos
+	}
+	lineOffset := file.Offset(file.LineStart(position.Line + 1))
+	lineEnd := file.Size()
+	if position.Line+1 < file.LineCount() {
+		lineEnd = file.Offset(file.LineStart(position.Line+2)) - 1
+	}
+	offset := lineOffset + position.Character
+	if p.Utf16 {
+		offset = lineOffset + GetUtf16ByteOffset(p.GetLine(file, lineOffset, lineEnd), position.Character)
+	}
+	return file.Pos(min(offset, lineEnd))
+}
+
+// GetPosition converts pos.
+func (p *LspPositions) GetPosition(pos token.Pos) model.LspPosition {
+	file := p.FileSet.File(pos)
+	if file == nil {
+		return model.LspPosition{}
+	}
+	return p.GetLinePosition(file, file.Line(pos), file.Offset(pos)-file.Offset(file.LineStart(file.Line(pos)))+1)
+}
+
+// GetLinePosition converts a line and a column of file, both counted from 1.
+func (p *LspPositions) GetLinePosition(file *token.File, line int, column int) model.LspPosition {
+	character := column - 1
+	if p.Utf16 && line >= 1 && line <= file.LineCount() {
+		lineOffset := file.Offset(file.LineStart(line))
+		lineBytes := p.GetLine(file, lineOffset, min(lineOffset+character, file.Size()))
+		character = GetUtf16Len(lineBytes)
+	}
+	return model.LspPosition{Line: line - 1, Character: character}
+}
+
+func (p *LspPositions) GetRange(start token.Pos, end token.Pos) model.LspRange {
+	return model.LspRange{Start: p.GetPosition(start), End: p.GetPosition(end)}
+}
+
+// GetRanges converts the SourceRanges of file.
+func (p *LspPositions) GetRanges(file *token.File, sourceRanges []model.SourceRange) []model.LspRange {
+	lspRanges := make([]model.LspRange, 0, len(sourceRanges))
+	for _, sourceRange := range sourceRanges {
+		lspRanges = append(lspRanges, model.LspRange{
+			Start: p.GetLinePosition(file, sourceRange.StartLine, sourceRange.StartColumn),
+			End:   p.GetLinePosition(file, sourceRange.EndLine, sourceRange.EndColumn),
+		})
+	}
+	return lspRanges
+}
+
+// GetLine returns the bytes of file from start to end, nil when the file can not be read.
+func (p *LspPositions) GetLine(file *token.File, start int, end int) []byte {
+	content, ok := p.contents[file.Name()]
+	if !ok {
+		var err error
+		content, err = os.ReadFile(file.Name())
+		if err != nil {
+			log.Printf("LspPositions ReadFileErr, file:%s, err:%+v", file.Name(), err)
+		}
+		p.contents[file.Name()] = content
+	}
+	if end > len(content) || start > end {
+		return nil
+	}
+	return content[start:end]
+}
+
+// GetUtf16Len returns the number of UTF-16 code units of text.
+func GetUtf16Len(text []byte) int {
+	return len(utf16.Encode([]rune(string(text))))
+}
+
+// GetUtf16ByteOffset returns the byte offset in line of the UTF-16 code unit character,
+// the length of line when it is after the end of the line.
+func GetUtf16ByteOffset(line []byte, character int) int {
+	units := 0
+	for offset, r := range string(line) {
+		if units >= character {
+			return offset
+		}
+		// 2 for the runes out of the basic multilingual plane, e.g. emojis
+		units++
+		if r > 0xFFFF {
+			units++
+		}
+	}
+	return len(line)
+}
+
+func UriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func PathToUri(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		absPath = path
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath)}).String()
+}
@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"errors"
+	"go/importer"
+	"go/token"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/juicymango/yeah_woo_go/logic"
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// AnalyzeRelevantFuncsParallel analyzes each input func in a TaskCtx of its own, on Input.Parallel workers
+// sharing the parsed files, then merges their results by key into taskCtx. The functions reached by several
+// input funcs, e.g. a common callee, run again only when the merge adds to their VarNames or changes
+// the relevance of their callees, so that they end with the VarNames they have without Parallel.
+// When the workers run a function with different options, the input funcs are analyzed again one after the other.
+func AnalyzeRelevantFuncsParallel(taskCtx *model.TaskCtx) error {
+	if taskCtx.FileSet == nil {
+		taskCtx.FileSet = token.NewFileSet()
+	}
+	fileCache := taskCtx.FileCache
+	if fileCache == nil || fileCache.FileSet != taskCtx.FileSet {
+		fileCache = &model.FileCache{FileSet: taskCtx.FileSet}
+	}
+	if taskCtx.StdImporter == nil {
+		taskCtx.StdImporter = &logic.LockedImporter{Importer: importer.ForCompiler(taskCtx.FileSet, "source", nil)}
+	}
+	funcs := taskCtx.Input.Funcs
+
+	workers := make([]*model.TaskCtx, len(funcs))
+	util.RunWorkers(taskCtx.Input.Parallel, len(funcs), func(idx int) {
+		startTime := time.Now()
+		workerCtx := NewWorkerTaskCtx(taskCtx, fileCache)
+		// the results of all the input funcs first, as without Parallel, e.g. for the paths of their files
+		AddInputFuncTaskResults(workerCtx)
+		AnalyzeInputFunc(workerCtx, idx)
+		workers[idx] = workerCtx
+		log.Printf("AnalyzeRelevantFuncsParallel WorkerDone, idx:%d, iterations:%d, duration:%v", idx, workerCtx.Iterations, time.Since(startTime))
+	})
+
+	if funcTaskKey, ok := GetConflictingFuncTaskKey(workers); ok {
+		// the first task reaching a function decides its options without Parallel, which the merge can not tell
+		log.Printf("AnalyzeRelevantFuncsParallel ConflictingOptions, key:%s", util.JsonString(funcTaskKey))
+		taskCtx.FileCache = fileCache
+		return AnalyzeInputFuncs(taskCtx)
+	}
+
+	// with the keys of their declarations, see AddInputFuncTaskResults
+	taskCtx.Input.Funcs = workers[0].Input.Funcs
+	startTime := time.Now()
+	MergeWorkerTaskCtxs(taskCtx, workers)
+	taskCtx.Input.FuncTask = taskCtx.Input.Funcs[len(funcs)-1]
+	if taskCtx.Context == nil || taskCtx.Context.Err() == nil {
+		logic.RunWorklist(taskCtx)
+	}
+	log.Printf("AnalyzeRelevantFuncsParallel MergeDone, iterations:%d, duration:%v", taskCtx.Iterations, time.Since(startTime))
+	taskErr := logic.GetTaskErrors(taskCtx)
+	if taskCtx.Context != nil && taskCtx.Context.Err() != nil {
+		return errors.Join(taskCtx.Context.Err(), taskErr)
+	}
+	return taskErr
+}
+
+// NewWorkerTaskCtx returns a TaskCtx with the Input, the FileSet, the StdImporter and the Context of taskCtx.
+func NewWorkerTaskCtx(taskCtx *model.TaskCtx, fileCache *model.FileCache) *model.TaskCtx {
+	input := *taskCtx.Input
+	input.Funcs = slices.Clone(input.Funcs)
+	input.Parallel = 0
+	return &model.TaskCtx{
+		Input:       &input,
+		FileSet:     taskCtx.FileSet,
+		FileCache:   fileCache,
+		StdImporter: taskCtx.StdImporter,
+		Context:     taskCtx.Context,
+	}
+}
+
+// GetConflictingFuncTaskKey returns the key of a function run by several workers with different options,
+// e.g. one with EnableCall and one without, and false when there is none.
+func GetConflictingFuncTaskKey(workers []*model.TaskCtx) (model.FuncTaskKey, bool) {
+	keyOptions := make(map[model.FuncTaskKey]string)
+	for _, workerCtx := range workers {
+		for _, result := range workerCtx.FuncTaskResults {
+			if !result.Started {
+				continue
+			}
+			funcTaskKey := util.GetFuncTaskKey(result.FuncTask)
+			options := GetFuncTaskOptions(result.FuncTask)
+			if prevOptions, ok := keyOptions[funcTaskKey]; ok && prevOptions != options {
+				return funcTaskKey, true
+			}
+			keyOptions[funcTaskKey] = options
+		}
+	}
+	return model.FuncTaskKey{}, false
+}
+
+// GetFuncTaskOptions returns how funcTask analyzes its function, as a string to compare:
+// the FuncTask without its function, its VarNames and its outputs.
+func GetFuncTaskOptions(funcTask model.FuncTask) string {
+	funcTask.Key = ""
+	funcTask.Source = ""
+	funcTask.RecvTypes = ""
+	funcTask.FuncName = ""
+	funcTask.Comments = nil
+	funcTask.VarNames = nil
+	funcTask.DataflowVarNames = nil
+	funcTask.CalleeTree = nil
+	funcTask.CallerTree = nil
+	return util.JsonString(funcTask)
+}
+
+// MergeWorkerTaskCtxs merges the results of the workers, the worker of each input func in order, into taskCtx by key,
+// in the order AnalyzeRelevantFuncs finds them: the input funcs, then the results found by each input func.
+// The result of a key is the one of the first worker which ran it, as the first input func reaching it runs it
+// without Parallel, and the copies of the other workers are merged into it. The results to run again are queued
+// in taskCtx: those whose VarNames grow, those queued by a worker stopped by MaxIterations, the callers
+// of those whose copies disagree on their relevance, and the callers which passed no VarNames to a copy having none.
+func MergeWorkerTaskCtxs(taskCtx *model.TaskCtx, workers []*model.TaskCtx) {
+	var funcTaskKeys []model.FuncTaskKey
+	keyResults := make(map[model.FuncTaskKey][]*model.FuncTaskResult)
+	addKey := func(funcTaskKey model.FuncTaskKey) {
+		if _, ok := keyResults[funcTaskKey]; ok {
+			return
+		}
+		keyResults[funcTaskKey] = nil
+		funcTaskKeys = append(funcTaskKeys, funcTaskKey)
+	}
+	for _, funcTask := range taskCtx.Input.Funcs {
+		addKey(util.GetFuncTaskKey(funcTask))
+	}
+	for _, workerCtx := range workers {
+		for _, result := range workerCtx.FuncTaskResults {
+			funcTaskKey := util.GetFuncTaskKey(result.FuncTask)
+			addKey(funcTaskKey)
+			keyResults[funcTaskKey] = append(keyResults[funcTaskKey], result)
+		}
+	}
+
+	if taskCtx.FuncTaskMap == nil {
+		taskCtx.FuncTaskMap = make(map[model.FuncTaskKey]*model.FuncTaskResult)
+	}
+	var toRun, relevanceChanged []*model.FuncTaskResult
+	unlinkedCallers := make(map[model.FuncTaskKey][]*model.FuncTaskResult)
+	for _, funcTaskKey := range funcTaskKeys {
+		results := keyResults[funcTaskKey]
+		if len(results) == 0 {
+			continue
+		}
+		result := results[0]
+		for _, other := range results {
+			if other.Started {
+				result = other
+				break
+			}
+		}
+		varNameCount := len(result.FuncTask.VarNames)
+		forcedRelevant := result.ForcedRelevant
+		isRelevantDiffers := false
+		isLater := false
+		for _, other := range results {
+			if other == result {
+				isLater = true
+				continue
+			}
+			MergeFuncTaskResult(result, other)
+			isRelevantDiffers = isRelevantDiffers || other.IsRelevant != result.IsRelevant
+			// without Parallel, the callers of a later worker would have found result with its VarNames
+			if isLater {
+				for callerKey := range other.UnlinkedCallers {
+					unlinkedCallers[callerKey] = append(unlinkedCallers[callerKey], result)
+				}
+			}
+		}
+		if result.Started && len(result.FuncTask.VarNames) > varNameCount {
+			log.Printf("MergeWorkerTaskCtxs VarNamesGrow, key:%s, varNames:%v", util.JsonString(funcTaskKey), result.FuncTask.VarNames)
+			toRun = append(toRun, result)
+		}
+		if isRelevantDiffers || result.ForcedRelevant != forcedRelevant {
+			relevanceChanged = append(relevanceChanged, result)
+		}
+		taskCtx.FuncTaskMap[funcTaskKey] = result
+		taskCtx.FuncTaskResults = append(taskCtx.FuncTaskResults, result)
+	}
+	for _, funcTask := range taskCtx.Input.Funcs {
+		if result := taskCtx.FuncTaskMap[util.GetFuncTaskKey(funcTask)]; result != nil {
+			result.IsFromInput = true
+		}
+	}
+
+	// the callers and callees found by the workers, now the results of taskCtx
+	for _, result := range taskCtx.FuncTaskResults {
+		for funcTaskKey := range result.CalleeMap {
+			result.CalleeMap[funcTaskKey] = taskCtx.FuncTaskMap[funcTaskKey]
+		}
+		for funcTaskKey := range result.CallerMap {
+			result.CallerMap[funcTaskKey] = taskCtx.FuncTaskMap[funcTaskKey]
+		}
+		result.Queued = false
+	}
+	for _, result := range taskCtx.FuncTaskResults {
+		callerKey := util.GetFuncTaskKey(result.FuncTask)
+		for _, calleeResult := range unlinkedCallers[callerKey] {
+			if len(calleeResult.FuncTask.VarNames) > 0 && result.FuncNodeInfo != nil {
+				toRun = append(toRun, result)
+				break
+			}
+		}
+	}
+	for _, workerCtx := range workers {
+		for _, result := range workerCtx.Worklist {
+			toRun = append(toRun, taskCtx.FuncTaskMap[util.GetFuncTaskKey(result.FuncTask)])
+		}
+	}
+	for _, result := range toRun {
+		logic.EnqueueFuncTaskResult(taskCtx, result)
+	}
+	for _, result := range relevanceChanged {
+		if result.ForcedRelevant && !result.IsRelevant {
+			logic.UpdateFuncRelevance(taskCtx, result)
+			continue
+		}
+		logic.EnqueueCallers(taskCtx, result)
+	}
+
+	// the files and packages, for the output
+	for _, workerCtx := range workers {
+		taskCtx.FileInfoMap = MergeMissingKeys(taskCtx.FileInfoMap, workerCtx.FileInfoMap)
+		taskCtx.PackageInfoMap = MergeMissingKeys(taskCtx.PackageInfoMap, workerCtx.PackageInfoMap)
+		taskCtx.TypesPackageMap = MergeMissingKeys(taskCtx.TypesPackageMap, workerCtx.TypesPackageMap)
+		taskCtx.GoFilesMap = MergeMissingKeys(taskCtx.GoFilesMap, workerCtx.GoFilesMap)
+		taskCtx.FileErrorMap = MergeMissingKeys(taskCtx.FileErrorMap, workerCtx.FileErrorMap)
+		taskCtx.FileSummaryMap = MergeMissingKeys(taskCtx.FileSummaryMap, workerCtx.FileSummaryMap)
+		taskCtx.Iterations += workerCtx.Iterations
+		taskCtx.NotConverged = taskCtx.NotConverged || workerCtx.NotConverged
+	}
+	log.Printf("MergeWorkerTaskCtxs Done, workers:%d, results:%d, queued:%d", len(workers), len(taskCtx.FuncTaskResults), len(taskCtx.Worklist))
+}
+
+// MergeFuncTaskResult adds to result what other, the result of the same key in another worker, found:
+// its VarNames, its callers and callees, and its errors.
+func MergeFuncTaskResult(result *model.FuncTaskResult, other *model.FuncTaskResult) {
+	if len(other.FuncTask.VarNames) > 0 {
+		result.FuncTask.VarNames = util.MergeAndDeduplicate(other.FuncTask.VarNames, result.FuncTask.VarNames)
+	}
+	result.IsFromInput = result.IsFromInput || other.IsFromInput
+	result.ForcedRelevant = result.ForcedRelevant || other.ForcedRelevant
+	result.CalleeMap = MergeMissingKeys(result.CalleeMap, other.CalleeMap)
+	result.CallerMap = MergeMissingKeys(result.CallerMap, other.CallerMap)
+	result.CalleeEdgeTags = MergeSliceValues(result.CalleeEdgeTags, other.CalleeEdgeTags)
+	result.CallerEdgeTags = MergeSliceValues(result.CallerEdgeTags, other.CallerEdgeTags)
+	result.CalleeTypeArgs = MergeSliceValues(result.CalleeTypeArgs, other.CalleeTypeArgs)
+	result.CallerTypeArgs = MergeSliceValues(result.CallerTypeArgs, other.CallerTypeArgs)
+	for _, err := range other.Errors {
+		if !slices.ContainsFunc(result.Errors, func(resultErr error) bool { return resultErr.Error() == err.Error() }) {
+			result.Errors = append(result.Errors, err)
+		}
+	}
+}
+
+// MergeSliceValues adds the values of from to those of the same key in to, and returns to, made if nil.
+func MergeSliceValues[K comparable](to map[K][]string, from map[K][]string) map[K][]string {
+	if len(from) == 0 {
+		return to
+	}
+	if to == nil {
+		to = make(map[K][]string, len(from))
+	}
+	for key, values := range from {
+		to[key] = util.MergeAndDeduplicate(to[key], values)
+	}
+	return to
+}
+
+// MergeMissingKeys adds the keys of from missing in to, and returns to, made if nil.
+func MergeMissingKeys[K comparable, V any](to map[K]V, from map[K]V) map[K]V {
+	if to == nil {
+		to = make(map[K]V, len(from))
+	}
+	for key, value := range from {
+		if _, ok := to[key]; !ok {
+			to[key] = value
+		}
+	}
+	return to
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/juicymango/yeah_woo_go/logic"
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// PrintRelevantFunc prints the simplified function of result. With ShowElided, every removed region
+// is replaced by a "// ... N lines elided" comment. With withPositions, it also returns the original
+// position of the printed lines, with OutputLine counted from 1 in the returned text.
+func PrintRelevantFunc(taskCtx *model.TaskCtx, result *model.FuncTaskResult, withPositions bool) (string, []model.PositionMapping, error) {
+	var buf bytes.Buffer
+	funcDecl, ok := result.FuncNodeInfo.Node.(*ast.FuncDecl)
+	if !ok || (!withPositions && !result.FuncTask.ShowElided) {
+		err := printer.Fprint(&buf, taskCtx.FileSet, util.GetUpdatedNode(result.FilterRelevantNodeInfo, nil))
+		return buf.String(), nil, err
+	}
+
+	// the kept and the removed statements, from the original nodes
+	sliceStmts := logic.GetSliceStmts(funcDecl, result.FilterRelevantNodeInfo)
+	var comments []*ast.CommentGroup
+	if funcDecl.Doc != nil {
+		comments = append(comments, funcDecl.Doc)
+	}
+	var elidedRuns [][]*model.SliceStmt
+	if result.FuncTask.ShowElided {
+		elidedRuns = logic.GetElidedRuns(sliceStmts)
+		for _, run := range elidedRuns {
+			// at the first removed statement, so the printer puts it where the run was
+			comment := &ast.Comment{Slash: run[0].Stmt.Pos(), Text: GetElidedComment(taskCtx.FileSet, run)}
+			comments = append(comments, &ast.CommentGroup{List: []*ast.Comment{comment}})
+		}
+	}
+	origNodes := make(map[ast.Node]ast.Node)
+	printedNode := util.GetUpdatedNode(result.FilterRelevantNodeInfo, origNodes)
+	err := printer.Fprint(&buf, taskCtx.FileSet, &printer.CommentedNode{Node: printedNode, Comments: comments})
+	if err != nil || !withPositions {
+		return buf.String(), nil, err
+	}
+	positionMap, err := GetPositionMap(taskCtx, result.FuncTask.Source, buf.String(), printedNode, origNodes, sliceStmts, elidedRuns)
+	return buf.String(), positionMap, err
+}
+
+// GetPositionMap returns the PositionMappings of text, the printed printedNode, from text parsed again:
+// its statements are those of printedNode in the same order, and its comments other than the doc of the
+// function are the elided comments of elidedRuns. The original positions are those of origNodes.
+func GetPositionMap(taskCtx *model.TaskCtx, source string, text string, printedNode ast.Node, origNodes map[ast.Node]ast.Node, sliceStmts []*model.SliceStmt, elidedRuns [][]*model.SliceStmt) ([]model.PositionMapping, error) {
+	outputFileSet := token.NewFileSet()
+	// the package clause is the line before text
+	outputFile, err := parser.ParseFile(outputFileSet, source, "package p\n"+text, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("parse the printed function: %w", err)
+	}
+	outputFuncDecl, ok := outputFile.Decls[0].(*ast.FuncDecl)
+	if !ok || len(outputFile.Decls) != 1 {
+		return nil, fmt.Errorf("parse the printed function: not a single function")
+	}
+	getMapping := func(pos token.Pos, outputPos token.Pos, elidedLines int) model.PositionMapping {
+		position := taskCtx.FileSet.Position(pos)
+		return model.PositionMapping{
+			OutputLine:  outputFileSet.Position(outputPos).Line - 1,
+			Source:      source,
+			Line:        position.Line,
+			Column:      position.Column,
+			ElidedLines: elidedLines,
+		}
+	}
+	positionMap := []model.PositionMapping{getMapping(origNodes[printedNode].Pos(), outputFuncDecl.Pos(), 0)}
+
+	keptStmts := make(map[ast.Node]bool)
+	for _, sliceStmt := range sliceStmts {
+		if sliceStmt.Kept {
+			keptStmts[sliceStmt.Stmt] = true
+		}
+	}
+	printedStmts := GetStmtsInOrder(printedNode)
+	outputStmts := GetStmtsInOrder(outputFuncDecl)
+	if len(printedStmts) != len(outputStmts) {
+		return nil, fmt.Errorf("parse the printed function: %d statements instead of %d", len(outputStmts), len(printedStmts))
+	}
+	for idx, printedStmt := range printedStmts {
+		if origStmt := origNodes[printedStmt]; keptStmts[origStmt] {
+			positionMap = append(positionMap, getMapping(origStmt.Pos(), outputStmts[idx].Pos(), 0))
+		}
+	}
+
+	var elidedComments []*ast.CommentGroup
+	for _, commentGroup := range outputFile.Comments {
+		if commentGroup != outputFuncDecl.Doc {
+			elidedComments = append(elidedComments, commentGroup)
+		}
+	}
+	if len(elidedComments) != len(elidedRuns) {
+		return nil, fmt.Errorf("parse the printed function: %d elided comments instead of %d", len(elidedComments), len(elidedRuns))
+	}
+	for idx, run := range elidedRuns {
+		positionMap = append(positionMap, getMapping(run[0].Stmt.Pos(), elidedComments[idx].Pos(), GetElidedLines(taskCtx.FileSet, run)))
+	}
+	slices.SortStableFunc(positionMap, func(a, b model.PositionMapping) int {
+		return a.OutputLine - b.OutputLine
+	})
+	return positionMap, nil
+}
+
+// GetStmtsInOrder returns the statements in node, in the order they are printed.
+func GetStmtsInOrder(node ast.Node) []ast.Stmt {
+	var stmts []ast.Stmt
+	ast.Inspect(node, func(n ast.Node) bool {
+		if stmt, ok := n.(ast.Stmt); ok {
+			stmts = append(stmts, stmt)
+		}
+		return true
+	})
+	return stmts
+}
+
+// LineCountWriter counts the lines written, to give the OutputLine of PositionMappings.
+type LineCountWriter struct {
+	W     io.Writer
+	Lines int
+}
+
+func (w *LineCountWriter) Write(p []byte) (int, error) {
+	n, err := w.W.Write(p)
+	w.Lines += bytes.Count(p[:n], []byte("\n"))
+	return n, err
+}
+
+// WritePositionMap writes the PositionMappings of the output to Input.PositionMapFile.
+func WritePositionMap(taskCtx *model.TaskCtx) error {
+	formattedJSON, err := FormatJSONObject(taskCtx.PositionMap)
+	if err != nil {
+		return err
+	}
+	return WriteToFile(taskCtx.Input.PositionMapFile, formattedJSON)
+}
+
+// GetElidedLines returns the number of lines of a run of removed statements, from GetElidedRuns.
+func GetElidedLines(fset *token.FileSet, run []*model.SliceStmt) int {
+	return fset.Position(run[len(run)-1].Stmt.End()).Line - fset.Position(run[0].Stmt.Pos()).Line + 1
+}
+
+// GetElidedComment returns e.g. "// ... 3 lines elided, a.go:10-12" for a run of removed statements.
+func GetElidedComment(fset *token.FileSet, run []*model.SliceStmt) string {
+	start := fset.Position(run[0].Stmt.Pos())
+	end := fset.Position(run[len(run)-1].Stmt.End())
+	elidedLines := GetElidedLines(fset, run)
+	lineRange := strconv.Itoa(start.Line)
+	if end.Line != start.Line {
+		lineRange = fmt.Sprintf("%d-%d", start.Line, end.Line)
+	}
+	lineWord := "lines"
+	if elidedLines == 1 {
+		lineWord = "line"
+	}
+	return fmt.Sprintf("// ... %d %s elided, %s:%s", elidedLines, lineWord, filepath.Base(start.Filename), lineRange)
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/juicymango/yeah_woo_go/logic"
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+const DefaultServeAddr = "127.0.0.1:7777"
+
+// Server answers GetRelevantFuncs requests, keeping the parsed files between requests.
+// Requests run one at a time, they share the FileCache.
+type Server struct {
+	mu        sync.Mutex
+	fileCache *model.FileCache
+}
+
+func NewServer() *Server {
+	return &Server{
+		fileCache: logic.NewFileCache(),
+	}
+}
+
+// Serve listens on addr, a TCP address like "127.0.0.1:7777" or a unix socket like "unix:/tmp/yeah_woo_go.sock".
+// Relative sources in the requests are relative to the working directory of the server.
+func Serve(addr string) error {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network = "unix"
+		addr = strings.TrimPrefix(addr, "unix:")
+		// a socket left by a previous server, but never another file
+		if fileInfo, err := os.Lstat(addr); err == nil {
+			if fileInfo.Mode().Type() != os.ModeSocket {
+				return fmt.Errorf("%s exists and is not a socket", addr)
+			}
+			if err := os.Remove(addr); err != nil {
+				return fmt.Errorf("remove socket %s: %w", addr, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("stat socket %s: %w", addr, err)
+		}
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("Serve Listening, network:%s, addr:%s", network, addr)
+
+	server := NewServer()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/GetRelevantFuncs", server.HandleGetRelevantFuncs)
+	return http.Serve(listener, mux)
+}
+
+// HandleGetRelevantFuncs takes the Input as the request body, and responds with a ServeResponse
+// holding the simplified go code, its PositionMap and the updated Input, which the command line writes back to the input file.
+func (s *Server) HandleGetRelevantFuncs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		WriteServeResponse(w, http.StatusMethodNotAllowed, &model.ServeResponse{Error: "method not allowed, use POST"})
+		return
+	}
+	var input model.Input
+	err := json.NewDecoder(r.Body).Decode(&input)
+	if err != nil {
+		WriteServeResponse(w, http.StatusBadRequest, &model.ServeResponse{Error: "decode input: " + err.Error()})
+		return
+	}
+
+	// the PositionMap is returned instead, a request does not write files of the server
+	input.PositionMapFile = ""
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	util.ResetModuleCache()
+	taskCtx := &model.TaskCtx{
+		Input:         &input,
+		FileSet:       logic.StartFileCacheRun(s.fileCache),
+		FileCache:     s.fileCache,
+		WithPositions: true,
+	}
+	var output bytes.Buffer
+	taskErr := RunRelevantFuncs(taskCtx, &output)
+	log.Printf("HandleGetRelevantFuncs Done, funcs:%d, cachedFiles:%d", len(input.Funcs), len(s.fileCache.Entries))
+	resp := &model.ServeResponse{
+		Output:      output.String(),
+		Input:       taskCtx.Input,
+		PositionMap: taskCtx.PositionMap,
+	}
+	if taskErr != nil {
+		resp.Error = taskErr.Error()
+	}
+	WriteServeResponse(w, http.StatusOK, resp)
+}
+
+func WriteServeResponse(w http.ResponseWriter, statusCode int, resp *model.ServeResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Printf("WriteServeResponse EncodeErr, err:%+v", err)
+	}
+}
@@ -0,0 +1,366 @@
+package logic
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// The kinds of FuncTask.SliceTarget, "kind:value".
+const (
+	SliceTargetLine   = "line"   // "line:42", the statements starting at line 42
+	SliceTargetReturn = "return" // "return" for every result, "return:1" for the second one
+	SliceTargetExpr   = "expr"   // "expr:req.ID", the statements with the expression req.ID
+)
+
+// GetSliceNodes returns the statements of the backward slice of the current task, nil without SliceTarget.
+// When the target is not found, the error is recorded on the task and no statement is kept.
+func GetSliceNodes(taskCtx *model.TaskCtx, funcNodeInfo *model.NodeInfo) map[ast.Node]bool {
+	if taskCtx.Input.FuncTask.SliceTarget == "" {
+		return nil
+	}
+	funcDecl, ok := funcNodeInfo.Node.(*ast.FuncDecl)
+	if !ok || funcDecl.Body == nil {
+		return make(map[ast.Node]bool)
+	}
+	sliceNodes, err := GetBackwardSliceNodes(taskCtx, funcDecl, taskCtx.Input.FuncTask.SliceTarget)
+	if err != nil {
+		log.Printf("GetSliceNodes Fail, err:%+v, task:%s", err, util.JsonString(&taskCtx.Input.FuncTask))
+		AddTaskError(taskCtx, err)
+		return make(map[ast.Node]bool)
+	}
+	return sliceNodes
+}
+
+// GetBackwardSliceNodes returns the statements of funcDecl which can affect target: the statements writing
+// a variable the target reads, before it or in the same loop, then the ones writing the variables those read,
+// and so on, with the if, for, switch and select statements and the case clauses enclosing any of them,
+// and the jumps deciding whether any of them runs, see GetDecidingJumpStmts.
+func GetBackwardSliceNodes(taskCtx *model.TaskCtx, funcDecl *ast.FuncDecl, target string) (map[ast.Node]bool, error) {
+	stmtDepsList := GetStmtDepsList(funcDecl)
+	criteria, err := GetSliceCriteria(taskCtx, funcDecl, stmtDepsList, target)
+	if err != nil {
+		return nil, err
+	}
+	stmtDepsMap := make(map[ast.Stmt]*model.StmtDeps, len(stmtDepsList))
+	for _, stmtDeps := range stmtDepsList {
+		stmtDepsMap[stmtDeps.Stmt] = stmtDeps
+	}
+
+	type sliceNeed struct {
+		stmtDeps *model.StmtDeps
+		varName  string
+	}
+	sliceNodes := make(map[ast.Node]bool)
+	var needs []sliceNeed
+	var addStmt func(stmtDeps *model.StmtDeps, uses []string)
+	addStmt = func(stmtDeps *model.StmtDeps, uses []string) {
+		sliceNodes[stmtDeps.Stmt] = true
+		for _, varName := range uses {
+			needs = append(needs, sliceNeed{stmtDeps: stmtDeps, varName: varName})
+		}
+		// control dependence
+		for _, parent := range stmtDeps.Parents {
+			if !sliceNodes[parent] {
+				addStmt(stmtDepsMap[parent], stmtDepsMap[parent].Uses)
+			}
+		}
+	}
+	for stmtDeps, uses := range criteria {
+		addStmt(stmtDeps, uses)
+	}
+
+	doneNeeds := make(map[sliceNeed]bool)
+	for {
+		// data dependence
+		for len(needs) > 0 {
+			need := needs[len(needs)-1]
+			needs = needs[:len(needs)-1]
+			if doneNeeds[need] {
+				continue
+			}
+			doneNeeds[need] = true
+			for _, defDeps := range stmtDepsList {
+				if sliceNodes[defDeps.Stmt] || !MayReachStmt(defDeps, need.stmtDeps) {
+					continue
+				}
+				if slices.ContainsFunc(defDeps.Defs, func(def string) bool { return IsSameVarOrField(def, need.varName) }) {
+					addStmt(defDeps, defDeps.Uses)
+				}
+			}
+		}
+		// control dependence on the jumps, e.g. the continue skipping the target, with the if guarding it
+		jumpStmts := GetDecidingJumpStmts(stmtDepsList, sliceNodes)
+		if len(jumpStmts) == 0 {
+			break
+		}
+		for _, jumpStmt := range jumpStmts {
+			addStmt(stmtDepsMap[jumpStmt], stmtDepsMap[jumpStmt].Uses)
+		}
+	}
+	log.Printf("GetBackwardSliceNodes Done, target:%s, stmts:%d, sliceStmts:%d", target, len(stmtDepsList), len(sliceNodes))
+	return sliceNodes, nil
+}
+
+// GetSliceCriteria returns the statements of target, with the variables of each the target reads.
+func GetSliceCriteria(taskCtx *model.TaskCtx, funcDecl *ast.FuncDecl, stmtDepsList []*model.StmtDeps, target string) (map[*model.StmtDeps][]string, error) {
+	kind, value, _ := strings.Cut(target, ":")
+	criteria := make(map[*model.StmtDeps][]string)
+	switch kind {
+	case SliceTargetLine:
+		line, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: bad line", model.ErrSliceTarget, target)
+		}
+		for _, stmtDeps := range stmtDepsList {
+			if taskCtx.FileSet.Position(stmtDeps.Stmt.Pos()).Line == line {
+				criteria[stmtDeps] = stmtDeps.Uses
+			}
+		}
+	case SliceTargetReturn:
+		resultIdx := -1
+		if value != "" {
+			idx, err := strconv.Atoi(value)
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("%w: %s: bad result index", model.ErrSliceTarget, target)
+			}
+			resultIdx = idx
+		}
+		for _, stmtDeps := range stmtDepsList {
+			if returnStmt, ok := stmtDeps.Stmt.(*ast.ReturnStmt); ok {
+				criteria[stmtDeps] = GetReturnUses(funcDecl, returnStmt, resultIdx)
+			}
+		}
+	case SliceTargetExpr:
+		targetExpr, err := parser.ParseExpr(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", model.ErrSliceTarget, target, err)
+		}
+		targetString := types.ExprString(targetExpr)
+		for _, stmtDeps := range stmtDepsList {
+			InspectStmtOwnNodes(stmtDeps.Stmt, func(n ast.Node) bool {
+				if expr, ok := n.(ast.Expr); ok && types.ExprString(expr) == targetString {
+					criteria[stmtDeps] = GetUsedVarNames(targetExpr)
+					return false
+				}
+				return true
+			})
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s: the kind is not line, return or expr", model.ErrSliceTarget, target)
+	}
+	if len(criteria) == 0 {
+		return nil, fmt.Errorf("%w: %s", model.ErrSliceTarget, target)
+	}
+	return criteria, nil
+}
+
+// GetReturnUses returns the variables returnStmt reads for the result resultIdx, or for every result if it is -1.
+// A bare return reads the named results.
+func GetReturnUses(funcDecl *ast.FuncDecl, returnStmt *ast.ReturnStmt, resultIdx int) []string {
+	var resultExprs []ast.Expr
+	if len(returnStmt.Results) == 0 && funcDecl.Type.Results != nil {
+		for _, field := range funcDecl.Type.Results.List {
+			for _, name := range field.Names {
+				resultExprs = append(resultExprs, name)
+			}
+		}
+	} else {
+		resultExprs = returnStmt.Results
+	}
+	// a single expression, e.g. a call, gives every result
+	if resultIdx >= 0 && len(resultExprs) > 1 {
+		if resultIdx >= len(resultExprs) {
+			return nil
+		}
+		resultExprs = resultExprs[resultIdx : resultIdx+1]
+	}
+	var uses []string
+	for _, expr := range resultExprs {
+		uses = append(uses, GetUsedVarNames(expr)...)
+	}
+	return uses
+}
+
+// GetStmtDepsList returns the StmtDeps of the statements of funcDecl in source order, leaving out blocks
+// and the statements of function literals.
+func GetStmtDepsList(funcDecl *ast.FuncDecl) []*model.StmtDeps {
+	var stmtDepsList []*model.StmtDeps
+	var walk func(stmt ast.Stmt, parents []ast.Stmt, loops []ast.Stmt)
+	walk = func(stmt ast.Stmt, parents []ast.Stmt, loops []ast.Stmt) {
+		if _, ok := stmt.(*ast.BlockStmt); !ok {
+			defs, uses := GetStmtDefsUses(stmt)
+			stmtDepsList = append(stmtDepsList, &model.StmtDeps{
+				Stmt:    stmt,
+				Defs:    defs,
+				Uses:    uses,
+				Parents: parents,
+				Loops:   loops,
+			})
+			parents = append(slices.Clip(parents), stmt)
+			switch stmt.(type) {
+			case *ast.ForStmt, *ast.RangeStmt:
+				loops = append(slices.Clip(loops), stmt)
+			}
+		}
+		for _, child := range GetChildStmts(stmt) {
+			walk(child, parents, loops)
+		}
+	}
+	if funcDecl.Body != nil {
+		for _, stmt := range funcDecl.Body.List {
+			walk(stmt, nil, nil)
+		}
+	}
+	return stmtDepsList
+}
+
+// GetStmtDefsUses returns the variables stmt writes and reads, without the ones of its nested statements.
+// A variable passed as &v, or the receiver of a method called as a statement, may be written.
+func GetStmtDefsUses(stmt ast.Stmt) ([]string, []string) {
+	var defs []string
+	var uses []string
+	addExprs := func(exprs ...ast.Expr) {
+		for _, expr := range exprs {
+			if expr != nil {
+				uses = append(uses, GetUsedVarNames(expr)...)
+			}
+		}
+	}
+	switch x := stmt.(type) {
+	case *ast.AssignStmt:
+		for _, lhs := range x.Lhs {
+			defs = append(defs, GetAssignedVarNames(lhs)...)
+			// e.g. x += 1 or m[k] = v
+			if _, ok := lhs.(*ast.Ident); !ok || (x.Tok != token.DEFINE && x.Tok != token.ASSIGN) {
+				addExprs(lhs)
+			}
+		}
+		addExprs(x.Rhs...)
+	case *ast.IncDecStmt:
+		defs = append(defs, GetAssignedVarNames(x.X)...)
+		addExprs(x.X)
+	case *ast.DeclStmt:
+		if genDecl, ok := x.Decl.(*ast.GenDecl); ok {
+			for _, spec := range genDecl.Specs {
+				if valueSpec, ok := spec.(*ast.ValueSpec); ok {
+					for _, name := range valueSpec.Names {
+						defs = append(defs, GetAssignedVarNames(name)...)
+					}
+					addExprs(valueSpec.Values...)
+				}
+			}
+		}
+	case *ast.RangeStmt:
+		if x.Tok == token.DEFINE || x.Tok == token.ASSIGN {
+			for _, expr := range []ast.Expr{x.Key, x.Value} {
+				if expr != nil {
+					defs = append(defs, GetAssignedVarNames(expr)...)
+				}
+			}
+		}
+		addExprs(x.X)
+	case *ast.ExprStmt:
+		addExprs(x.X)
+		defs = append(defs, GetCallReceiverDefs(x.X)...)
+	case *ast.GoStmt:
+		addExprs(x.Call)
+		defs = append(defs, GetCallReceiverDefs(x.Call)...)
+	case *ast.DeferStmt:
+		addExprs(x.Call)
+		defs = append(defs, GetCallReceiverDefs(x.Call)...)
+	case *ast.SendStmt:
+		addExprs(x.Chan, x.Value)
+	case *ast.ReturnStmt:
+		addExprs(x.Results...)
+	case *ast.IfStmt:
+		addExprs(x.Cond)
+	case *ast.ForStmt:
+		addExprs(x.Cond)
+	case *ast.SwitchStmt:
+		addExprs(x.Tag)
+	case *ast.CaseClause:
+		addExprs(x.List...)
+	}
+	InspectStmtOwnNodes(stmt, func(n ast.Node) bool {
+		if unaryExpr, ok := n.(*ast.UnaryExpr); ok && unaryExpr.Op == token.AND {
+			defs = append(defs, GetAssignedVarNames(unaryExpr.X)...)
+		}
+		return true
+	})
+	return defs, uses
+}
+
+// GetCallReceiverDefs returns the receiver of the method call expr, e.g. "s.items" for s.items.Add(x).
+func GetCallReceiverDefs(expr ast.Expr) []string {
+	callExpr, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return nil
+	}
+	selectorExpr, ok := callExpr.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	return GetAssignedVarNames(selectorExpr.X)
+}
+
+// InspectStmtOwnNodes is ast.Inspect on stmt, leaving out its nested statements and function literals.
+func InspectStmtOwnNodes(stmt ast.Stmt, f func(n ast.Node) bool) {
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		if n == nil || n == stmt {
+			return true
+		}
+		switch n.(type) {
+		case ast.Stmt, *ast.FuncLit:
+			return false
+		}
+		return f(n)
+	})
+}
+
+// MayReachStmt reports whether the values written by def may be read by use: def is before use,
+// or encloses it like a range statement, or is in its header like the init of an if, or both are in the same loop.
+func MayReachStmt(def *model.StmtDeps, use *model.StmtDeps) bool {
+	if def.Stmt.End() <= use.Stmt.Pos() || slices.Contains(use.Parents, def.Stmt) {
+		return true
+	}
+	if slices.Contains(GetHeaderStmts(use.Stmt), def.Stmt) {
+		return true
+	}
+	for _, loop := range def.Loops {
+		if slices.Contains(use.Loops, loop) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetHeaderStmts returns the statements run before the condition or tag of stmt, and the post statement of a for.
+func GetHeaderStmts(stmt ast.Stmt) []ast.Stmt {
+	var headerStmts []ast.Stmt
+	switch x := stmt.(type) {
+	case *ast.IfStmt:
+		headerStmts = append(headerStmts, x.Init)
+	case *ast.ForStmt:
+		headerStmts = append(headerStmts, x.Init, x.Post)
+	case *ast.SwitchStmt:
+		headerStmts = append(headerStmts, x.Init)
+	case *ast.TypeSwitchStmt:
+		headerStmts = append(headerStmts, x.Init, x.Assign)
+	}
+	return slices.DeleteFunc(headerStmts, func(headerStmt ast.Stmt) bool { return headerStmt == nil })
+}
+
+// IsSameVarOrField reports whether writing varName1 may change varName2, e.g. a and a.B.
+func IsSameVarOrField(varName1 string, varName2 string) bool {
+	return varName1 == varName2 || strings.HasPrefix(varName2, varName1+".") || strings.HasPrefix(varName1, varName2+".")
+}
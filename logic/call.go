@@ -1,11 +1,12 @@
 package logic
 
 import (
-	"fmt"
+	"go/ast"
 	"log"
+	"maps"
 	"path/filepath"
 	"slices"
-	"maps"
+	"strings"
 
 	"github.com/juicymango/yeah_woo_go/model"
 	"github.com/juicymango/yeah_woo_go/util"
@@ -26,10 +27,22 @@ func FilterRelevantCallExpr(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) {
 		return
 	}
 
+	if taskCtx.Input.FuncTask.TypeCheck && FilterRelevantCallExprByTypes(taskCtx, nodeInfo) {
+		return
+	}
+
+	// an instantiation like Map[int](xs) calls the generic function
+	if fun.Type == "*ast.IndexExpr" || fun.Type == "*ast.IndexListExpr" {
+		fun = fun.NodeFields["X"]
+	}
+
 	if fun.Type == "*ast.Ident" {
+		if IsLocalFuncVar(taskCtx, fun) {
+			log.Printf("FilterRelevantCallExpr LocalVar, name:%s", fun.StringFields["Name"])
+			return
+		}
 		FilterRelevantCallExprLocalFunc(taskCtx, nodeInfo, fun)
 		return
-		// TODO: if it is a variable
 	}
 
 	if fun.Type == "*ast.SelectorExpr" {
@@ -41,6 +54,24 @@ func FilterRelevantCallExpr(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) {
 	}
 }
 
+// IsLocalFuncVar reports whether the called name fun is a local of the current function,
+// e.g. a closure f := func() {...}, which shadows a package-level function f.
+func IsLocalFuncVar(taskCtx *model.TaskCtx, fun *model.NodeInfo) bool {
+	ident, ok := fun.Node.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	result := GetFuncTaskResult(taskCtx)
+	if result.FuncNodeInfo == nil {
+		return false
+	}
+	funcDecl, ok := result.FuncNodeInfo.Node.(*ast.FuncDecl)
+	if !ok {
+		return false
+	}
+	return IsLocalName(GetLocalNames(funcDecl), ident)
+}
+
 func FilterRelevantCallExprLocalFunc(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo, fun *model.NodeInfo) {
 	dir := filepath.Dir(taskCtx.Input.FuncTask.Source)
 	funcName := fun.StringFields["Name"]
@@ -53,30 +84,37 @@ func FilterRelevantCallExprFunc(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo
 	if !isFunNameRelevant && taskCtx.Input.FuncTask.OnlyRelevantFunc {
 		return
 	}
-	targetString := fmt.Sprintf("func %s(", funcName)
-	if receiver != "" {
-		targetString = fmt.Sprintf("%s) %s(", receiver, funcName)
-	}
-	targetFilePaths, err := util.Grep(dir, targetString)
-	if err != nil {
-		log.Printf("FilterRelevantCallExpr GrepErr, err:%+v", err)
-		return
-	}
-	if len(targetFilePaths) == 0 {
+	withTests := strings.HasSuffix(taskCtx.Input.FuncTask.Source, "_test.go")
+	funcKey, declInfo := LookupFuncDecl(taskCtx, dir, receiver, funcName, withTests)
+	if declInfo == nil {
 		return
 	}
-	log.Printf("FilterRelevantCallExpr GrepResult, dir:%s, targetString:%s, targetFilePaths:%+v", dir, targetString, targetFilePaths)
+	log.Printf("FilterRelevantCallExpr IndexResult, dir:%s, receiver:%s, funcName:%s, funcKey:%+v, source:%s", dir, receiver, funcName, funcKey, declInfo.Source)
+	FilterRelevantCallExprFuncFiles(taskCtx, nodeInfo, []string{declInfo.Source}, funcKey.RecvTypes, funcName, isFunNameRelevant, nil)
+}
 
+// FilterRelevantCallExprFuncFiles creates the subtasks of the called function declared in targetFilePaths.
+// edgeTags are recorded on the call edges, e.g. "dynamic" for interface dispatch, with the CallSiteTags of the call,
+// and the type arguments of the call of a generic function, see GetCallTypeArgs.
+func FilterRelevantCallExprFuncFiles(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo, targetFilePaths []string, receiver string, funcName string, isFunNameRelevant bool, edgeTags []string) {
 	currentFuncTask := taskCtx.Input.FuncTask
 	currentResult := GetFuncTaskResult(taskCtx)
+	if nodeInfo != nil && len(taskCtx.CallSiteTags[nodeInfo.Node]) > 0 {
+		edgeTags = util.MergeAndDeduplicate(edgeTags, taskCtx.CallSiteTags[nodeInfo.Node])
+	}
+	typeArgs := GetCallTypeArgs(taskCtx, nodeInfo)
 	for _, filePath := range targetFilePaths {
 		taskCtx.Input.FuncTask = currentFuncTask
 		util.SetSubTask(&taskCtx.Input.FuncTask)
 		taskCtx.Input.FuncTask.FuncName = funcName
 		taskCtx.Input.FuncTask.RecvTypes = receiver
-		taskCtx.Input.FuncTask.Source = filePath
+		taskCtx.Input.FuncTask.Source = GetCanonicalSource(taskCtx, filePath)
 		result := GetFuncTaskResult(taskCtx)
 		if result.FuncNodeInfo == nil {
+			// the callee is in a file which can not be parsed
+			if err := GetFileError(taskCtx, filePath); err != nil {
+				AddResultError(currentResult, err)
+			}
 			continue
 		}
 		taskCtx.Input.FuncTask = result.FuncTask
@@ -92,73 +130,112 @@ func FilterRelevantCallExprFunc(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo
 			taskCtx.Input.FuncTask.VarNames = util.MergeAndDeduplicate(taskCtx.Input.FuncTask.VarNames, relevantFieldNames)
 		}
 
-		log.Printf("FilterRelevantCallExpr GrepResult, dir:%s, targetString:%s, targetFilePaths:%+v", dir, targetString, targetFilePaths)
+		log.Printf("FilterRelevantCallExpr SubTask, filePath:%s, receiver:%s, funcName:%s, varNames:%+v", filePath, receiver, funcName, taskCtx.Input.FuncTask.VarNames)
 		if len(taskCtx.Input.FuncTask.VarNames) == 0 && !isFunNameRelevant {
+			if result.UnlinkedCallers == nil {
+				result.UnlinkedCallers = make(map[model.FuncTaskKey]bool)
+			}
+			result.UnlinkedCallers[util.GetFuncTaskKey(currentResult.FuncTask)] = true
 			continue
 		}
 
-		if currentResult.CalleeMap == nil {
-			currentResult.CalleeMap = make(map[model.FuncTaskKey]*model.FuncTaskResult)
-		}
-		currentResult.CalleeMap[util.GetFuncTaskKey(result.FuncTask)] = result
-		if result.CallerMap == nil {
-			result.CallerMap = make(map[model.FuncTaskKey]*model.FuncTaskResult)
+		AddCallEdge(currentResult, result, edgeTags)
+		if funcDecl, ok := result.FuncNodeInfo.Node.(*ast.FuncDecl); ok {
+			AddCallTypeArgs(currentResult, result, GetTypeArgPairs(funcDecl, typeArgs))
 		}
-		result.CallerMap[util.GetFuncTaskKey(currentResult.FuncTask)] = currentResult
 
+		// runs later, the call is relevant once the result is, see UpdateFuncRelevance
 		if CheckNeedRunAndMergeVarNames(taskCtx, result) {
-			result.FilterRelevantNodeInfo = FilterRelevantNodeInfo(taskCtx, result.FuncNodeInfo)
-			log.Printf("FilterRelevantCallExpr NewTaskResult, task:%s, result:%s", util.JsonString(taskCtx.Input.FuncTask), util.JsonString(result.FilterRelevantNodeInfo.RelevantTaskResult))
+			log.Printf("FilterRelevantCallExpr QueueTaskResult, task:%s", util.JsonString(taskCtx.Input.FuncTask))
+			EnqueueFuncTaskResult(taskCtx, result)
 		}
-		if result.FilterRelevantNodeInfo != nil && result.FilterRelevantNodeInfo.RelevantTaskResult != nil {
-			if isFunNameRelevant {
-				result.FilterRelevantNodeInfo.RelevantTaskResult.IsRelevant = true
-			}
-			if result.FilterRelevantNodeInfo.RelevantTaskResult.IsRelevant && nodeInfo != nil && nodeInfo.RelevantTaskResult != nil {
-				nodeInfo.RelevantTaskResult.IsRelevant = true
-			}
+		if isFunNameRelevant {
+			SetForcedRelevant(taskCtx, result)
+		}
+		if result.IsRelevant && nodeInfo != nil && nodeInfo.RelevantTaskResult != nil {
+			nodeInfo.RelevantTaskResult.IsRelevant = true
+			AddCalleeMatch(taskCtx, currentResult, nodeInfo.Node, util.GetFuncTaskKey(result.FuncTask))
 		}
 	}
 	taskCtx.Input.FuncTask = currentFuncTask
 }
 
-func FilterRelevantFuncCallerKey(taskCtx *model.TaskCtx, filePath string, receiver string, funcName string) {
+// FilterRelevantFuncCallerKey creates the subtask of a caller of the current function.
+// varNames are added to the VarNames of the caller, and a positive callerDepth makes the
+// caller look for its own callers in turn.
+func FilterRelevantFuncCallerKey(taskCtx *model.TaskCtx, filePath string, receiver string, funcName string, varNames []string, callerDepth int, edgeTags []string) {
 	currentFuncTask := taskCtx.Input.FuncTask
 	currentResult := GetFuncTaskResult(taskCtx)
 	util.SetSubTask(&taskCtx.Input.FuncTask)
 	taskCtx.Input.FuncTask.FuncName = funcName
 	taskCtx.Input.FuncTask.RecvTypes = receiver
-	taskCtx.Input.FuncTask.Source = filePath
+	taskCtx.Input.FuncTask.Source = GetCanonicalSource(taskCtx, filePath)
 	// new FuncTask
 	result := GetFuncTaskResult(taskCtx)
 	if result.FuncNodeInfo == nil {
+		taskCtx.Input.FuncTask = currentFuncTask
 		return
 	}
 	taskCtx.Input.FuncTask = result.FuncTask
-	if currentResult.CallerMap == nil {
-		currentResult.CallerMap = make(map[model.FuncTaskKey]*model.FuncTaskResult)
+	if len(varNames) > 0 {
+		taskCtx.Input.FuncTask.VarNames = util.MergeAndDeduplicate(taskCtx.Input.FuncTask.VarNames, varNames)
 	}
-	currentResult.CallerMap[util.GetFuncTaskKey(result.FuncTask)] = result
-	if result.CalleeMap == nil {
-		result.CalleeMap = make(map[model.FuncTaskKey]*model.FuncTaskResult)
+	if callerDepth > 0 {
+		taskCtx.Input.FuncTask.FindCallers = true
+		taskCtx.Input.FuncTask.CallerDepth = max(taskCtx.Input.FuncTask.CallerDepth, callerDepth)
 	}
-	result.CalleeMap[util.GetFuncTaskKey(currentResult.FuncTask)] = currentResult
+	AddCallEdge(result, currentResult, edgeTags)
 
 	if CheckNeedRunAndMergeVarNames(taskCtx, result) {
-		result.FilterRelevantNodeInfo = FilterRelevantNodeInfo(taskCtx, result.FuncNodeInfo)
-		log.Printf("FilterRelevantFuncCallerKey NewTaskResult, task:%s, result:%s", util.JsonString(taskCtx.Input.FuncTask), util.JsonString(result.FilterRelevantNodeInfo.RelevantTaskResult))
-	}
-	if result.FilterRelevantNodeInfo != nil && result.FilterRelevantNodeInfo.RelevantTaskResult != nil {
-		result.FilterRelevantNodeInfo.RelevantTaskResult.IsRelevant = true
+		log.Printf("FilterRelevantFuncCallerKey QueueTaskResult, task:%s", util.JsonString(taskCtx.Input.FuncTask))
+		EnqueueFuncTaskResult(taskCtx, result)
 	}
+	SetForcedRelevant(taskCtx, result)
 	taskCtx.Input.FuncTask = currentFuncTask
 }
 
+// AddCallEdge records that callerResult calls calleeResult, merging the edge tags.
+func AddCallEdge(callerResult *model.FuncTaskResult, calleeResult *model.FuncTaskResult, edgeTags []string) {
+	calleeKey := util.GetFuncTaskKey(calleeResult.FuncTask)
+	callerKey := util.GetFuncTaskKey(callerResult.FuncTask)
+	if callerResult.CalleeMap == nil {
+		callerResult.CalleeMap = make(map[model.FuncTaskKey]*model.FuncTaskResult)
+	}
+	callerResult.CalleeMap[calleeKey] = calleeResult
+	if calleeResult.CallerMap == nil {
+		calleeResult.CallerMap = make(map[model.FuncTaskKey]*model.FuncTaskResult)
+	}
+	calleeResult.CallerMap[callerKey] = callerResult
+	if len(edgeTags) == 0 {
+		return
+	}
+	if callerResult.CalleeEdgeTags == nil {
+		callerResult.CalleeEdgeTags = make(map[model.FuncTaskKey][]string)
+	}
+	callerResult.CalleeEdgeTags[calleeKey] = util.MergeAndDeduplicate(callerResult.CalleeEdgeTags[calleeKey], edgeTags)
+	if calleeResult.CallerEdgeTags == nil {
+		calleeResult.CallerEdgeTags = make(map[model.FuncTaskKey][]string)
+	}
+	calleeResult.CallerEdgeTags[callerKey] = util.MergeAndDeduplicate(calleeResult.CallerEdgeTags[callerKey], edgeTags)
+}
+
 func GetRelevantFuncFieldNames(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo, funcNodeInfo *model.NodeInfo) []string {
 	if nodeInfo == nil || funcNodeInfo == nil {
 		return nil
 	}
 	varNames := make([]string, 0)
+	// the receiver of a method call, e.g. s.repo in s.repo.Save(x)
+	fun := nodeInfo.NodeFields["Fun"]
+	if funcNodeInfo.NodeFields["Recv"] != nil && fun != nil && fun.Type == "*ast.SelectorExpr" {
+		x := fun.NodeFields["X"]
+		if x != nil && x.RelevantTaskResult != nil && x.RelevantTaskResult.IsRelevant {
+			for _, recvField := range funcNodeInfo.NodeFields["Recv"].NodeListFields["List"] {
+				for _, field := range recvField.NodeListFields["Names"] {
+					varNames = append(varNames, field.StringFields["Name"])
+				}
+			}
+		}
+	}
 	fieldIdx := 0
 	for _, typeFields := range funcNodeInfo.NodeFields["Type"].NodeFields["Params"].NodeListFields["List"] {
 		for _, field := range typeFields.NodeListFields["Names"] {
@@ -182,16 +259,40 @@ func GetFuncTaskResult(taskCtx *model.TaskCtx) *model.FuncTaskResult {
 	result := taskCtx.FuncTaskMap[funcTaskKey]
 	if result == nil {
 		result = &model.FuncTaskResult{
-			FuncNodeInfo: GetFuncNodeInfo(taskCtx),
-			FuncTask:     taskCtx.Input.FuncTask,
+			FuncTask: taskCtx.Input.FuncTask,
 		}
+		limitErr := CheckFuncLimit(taskCtx)
+		// before GetFuncNodeInfo, which records its errors on the result
+		taskCtx.FuncTaskMap[funcTaskKey] = result
+		taskCtx.FuncTaskResults = append(taskCtx.FuncTaskResults, result)
+		if limitErr != nil {
+			// never analyzed
+			log.Printf("GetFuncTaskResult Limit, funcTaskKey:%+v, err:%+v", util.JsonString(funcTaskKey), limitErr)
+			result.Errors = append(result.Errors, limitErr)
+			return result
+		}
+		result.FuncNodeInfo = GetFuncNodeInfo(taskCtx)
 		if result.FuncNodeInfo == nil {
 			log.Printf("GetFuncTaskResult FuncNodeInfoNil, funcTaskKey:%+v", util.JsonString(funcTaskKey))
 		}
-		taskCtx.FuncTaskMap[funcTaskKey] = result
-		taskCtx.FuncTaskResults = append(taskCtx.FuncTaskResults, result)
+		// the key of the declaration, e.g. for "Svc" naming a method of *Svc, or a function of another file of the package
+		if declKey := util.GetFuncTaskKey(taskCtx.Input.FuncTask); declKey != funcTaskKey {
+			log.Printf("GetFuncTaskResult DeclKey, funcTaskKey:%+v, declKey:%+v", util.JsonString(funcTaskKey), util.JsonString(declKey))
+			if declResult := taskCtx.FuncTaskMap[declKey]; declResult != nil {
+				taskCtx.FuncTaskResults = taskCtx.FuncTaskResults[:len(taskCtx.FuncTaskResults)-1]
+				result = declResult
+			} else {
+				result.FuncTask.Source = taskCtx.Input.FuncTask.Source
+				result.FuncTask.RecvTypes = taskCtx.Input.FuncTask.RecvTypes
+				taskCtx.FuncTaskMap[declKey] = result
+			}
+			taskCtx.FuncTaskMap[funcTaskKey] = result
+		}
 		log.Printf("GetFuncTaskResult New FuncTask, funcTaskKey:%+v", util.JsonString(funcTaskKey))
 	}
+	// the spelling of the declaration, for the tasks made from the current one
+	taskCtx.Input.FuncTask.Source = result.FuncTask.Source
+	taskCtx.Input.FuncTask.RecvTypes = result.FuncTask.RecvTypes
 	return result
 }
 
@@ -202,6 +303,7 @@ func CheckNeedRunAndMergeVarNames(taskCtx *model.TaskCtx, result *model.FuncTask
 	if !result.Started {
 		result.Started = true
 		result.FuncTask = taskCtx.Input.FuncTask
+		result.Matches = nil
 		log.Printf("CheckNeedRunAndMergeVarNames NotStarted, FuncTask:%+v", util.JsonString(taskCtx.Input.FuncTask))
 		return true
 	}
@@ -210,7 +312,7 @@ func CheckNeedRunAndMergeVarNames(taskCtx *model.TaskCtx, result *model.FuncTask
 		log.Printf("CheckNeedRunAndMergeVarNames NewVarNames, FuncTask:%+v, newVarNames:%v, oldVarNames:%v", util.JsonString(taskCtx.Input.FuncTask), newVarNames, result.FuncTask.VarNames)
 		result.FuncTask.VarNames = newVarNames
 		taskCtx.Input.FuncTask.VarNames = newVarNames
-		result.FilterRelevantNodeInfo = nil
+		// FilterRelevantNodeInfo and Matches stay until the run replaces them, for the output when MaxIterations stops it first
 		result.Started = true
 		return true
 	}
@@ -235,9 +337,10 @@ func FilterRelevantCallExprOtherFunc(taskCtx *model.TaskCtx, nodeInfo *model.Nod
 	if importPath == prefix {
 		return false
 	}
-	dir, err := util.GetAbsoluteImportPath(importPath)
+	dir, err := util.GetImportDir(filepath.Dir(taskCtx.Input.FuncTask.Source), importPath)
 	if err != nil {
-		log.Printf("FilterRelevantCallExprOtherFunc GetAbsoluteImportPath fail, importPath:%+v", importPath)
+		log.Printf("FilterRelevantCallExprOtherFunc GetImportDir fail, importPath:%+v, err:%+v", importPath, err)
+		AddImportError(taskCtx, importPath, err)
 		return false
 	}
 	funcName := fun.NodeFields["Sel"].StringFields["Name"]
@@ -245,7 +348,29 @@ func FilterRelevantCallExprOtherFunc(taskCtx *model.TaskCtx, nodeInfo *model.Nod
 	return true
 }
 
+// FilterRelevantCallExprMethod works out the static type of the receiver expression,
+// e.g. the type of s.repo in s.repo.Save(x), and checks the method like a local function call.
 func FilterRelevantCallExprMethod(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo, fun *model.NodeInfo) {
+	selectorExpr, ok := fun.Node.(*ast.SelectorExpr)
+	if !ok {
+		return
+	}
+	funcName := selectorExpr.Sel.Name
+	exprType := GetExprType(taskCtx, selectorExpr.X, nodeInfo.Node.Pos())
+	if exprType == nil {
+		log.Printf("FilterRelevantCallExprMethod ExprTypeNil, funcName:%s, FuncTask:%+v", funcName, util.JsonString(taskCtx.Input.FuncTask))
+		return
+	}
+	dir, recvTypes := GetMethodRecvTypes(taskCtx, exprType, funcName)
+	if recvTypes == "" && IsInterfaceType(taskCtx, exprType) {
+		FilterRelevantCallExprInterfaceMethod(taskCtx, nodeInfo, exprType, funcName)
+		return
+	}
+	if recvTypes == "" {
+		log.Printf("FilterRelevantCallExprMethod MethodNotFound, funcName:%s, exprType:%s", funcName, GetExprTypeString(taskCtx, exprType))
+		return
+	}
+	FilterRelevantCallExprFunc(taskCtx, nodeInfo, dir, recvTypes, funcName, false)
 }
 
 func FilterRelevantFuncCalls(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) {
@@ -272,9 +397,10 @@ func FilterRelevantFuncCalls(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) {
 			if importPath == "" {
 				continue
 			}
-			dir, err = util.GetAbsoluteImportPath(importPath)
+			dir, err = util.GetImportDir(dir, importPath)
 			if err != nil {
-				log.Printf("FilterRelevantFuncCalls GetAbsoluteImportPath fail, importPath:%+v", importPath)
+				log.Printf("FilterRelevantFuncCalls GetImportDir fail, importPath:%+v, err:%+v", importPath, err)
+				AddImportError(taskCtx, importPath, err)
 				continue
 			}
 		}
@@ -300,7 +426,7 @@ func FilterRelevantFuncCallerKeys(taskCtx *model.TaskCtx, nodeInfo *model.NodeIn
 			log.Printf("FilterRelevantFuncCallerKeys StringToFuncTaskKey fail, key:%s err:%+v", key, err)
 			continue
 		}
-		FilterRelevantFuncCallerKey(taskCtx, funcTaskKey.Source, funcTaskKey.RecvTypes, funcTaskKey.FuncName)
+		FilterRelevantFuncCallerKey(taskCtx, funcTaskKey.Source, funcTaskKey.RecvTypes, funcTaskKey.FuncName, nil, 0, nil)
 	}
 }
 
@@ -323,7 +449,14 @@ func GenCalleeTreeSub(result *model.FuncTaskResult, hasGenMap map[string]bool, c
 		if hasGenMap[calleeKeyStr] {
 			continue
 		}
-		tree[calleeKeyStr] = GenCalleeTreeSub(calleeResult, maps.Clone(hasGenMap), collectComments)
+		subTree := GenCalleeTreeSub(calleeResult, maps.Clone(hasGenMap), collectComments)
+		if edgeTags := result.CalleeEdgeTags[calleeKey]; len(edgeTags) > 0 {
+			subTree["edge_tags"] = edgeTags
+		}
+		if typeArgs := result.CalleeTypeArgs[calleeKey]; len(typeArgs) > 0 {
+			subTree["type_args"] = typeArgs
+		}
+		tree[calleeKeyStr] = subTree
 	}
 	return tree
 }
@@ -347,7 +480,14 @@ func GenCallerTreeSub(result *model.FuncTaskResult, hasGenMap map[string]bool, c
 		if hasGenMap[callerKeyStr] {
 			continue
 		}
-		tree[callerKeyStr] = GenCallerTreeSub(callerResult, maps.Clone(hasGenMap), collectComments)
+		subTree := GenCallerTreeSub(callerResult, maps.Clone(hasGenMap), collectComments)
+		if edgeTags := result.CallerEdgeTags[callerKey]; len(edgeTags) > 0 {
+			subTree["edge_tags"] = edgeTags
+		}
+		if typeArgs := result.CallerTypeArgs[callerKey]; len(typeArgs) > 0 {
+			subTree["type_args"] = typeArgs
+		}
+		tree[callerKeyStr] = subTree
 	}
 	return tree
 }
@@ -0,0 +1,304 @@
+package logic
+
+import (
+	"go/ast"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// callSite is a call of the current function found in the FuncDecl of a caller.
+type callSite struct {
+	call     *ast.CallExpr
+	edgeTags []string
+}
+
+// FilterRelevantFindCallers looks for the callers of the current function under the CallerRoots,
+// the module root by default, and runs each of them as a caller subtask like FuncCallerKeys.
+// The relevant parameters of the current function become the names of the arguments in the caller.
+// Callers look for their own callers while CallerDepth allows it.
+func FilterRelevantFindCallers(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) {
+	if nodeInfo == nil || !taskCtx.Input.FuncTask.FindCallers {
+		return
+	}
+	funcDecl, ok := nodeInfo.Node.(*ast.FuncDecl)
+	if !ok {
+		return
+	}
+	currentFuncTask := taskCtx.Input.FuncTask
+	callerDepth := max(currentFuncTask.CallerDepth, 1)
+	for _, source := range GetCallerCandidateFiles(taskCtx) {
+		fileInfo := GetFileInfoBySource(taskCtx, source)
+		if fileInfo == nil {
+			continue
+		}
+		funcKeys := make([]model.FuncKey, 0, len(fileInfo.FuncMap))
+		for funcKey := range fileInfo.FuncMap {
+			funcKeys = append(funcKeys, funcKey)
+		}
+		slices.SortFunc(funcKeys, func(a, b model.FuncKey) int {
+			return strings.Compare(a.RecvTypes+"."+a.Name, b.RecvTypes+"."+b.Name)
+		})
+		for _, funcKey := range funcKeys {
+			callSites := GetCallSites(taskCtx, funcDecl, source, funcKey)
+			taskCtx.Input.FuncTask = currentFuncTask
+			if len(callSites) == 0 {
+				continue
+			}
+			var varNames, edgeTags []string
+			for _, site := range callSites {
+				varNames = util.MergeAndDeduplicate(varNames, GetCallSiteVarNames(taskCtx, funcDecl, source, site.call))
+				edgeTags = util.MergeAndDeduplicate(edgeTags, site.edgeTags)
+			}
+			// keeps the call itself in the caller
+			varNames = util.MergeAndDeduplicate(varNames, []string{currentFuncTask.FuncName})
+			log.Printf("FilterRelevantFindCallers Caller, source:%s, funcKey:%+v, varNames:%+v, edgeTags:%+v", source, funcKey, varNames, edgeTags)
+			FilterRelevantFuncCallerKey(taskCtx, source, funcKey.RecvTypes, funcKey.Name, varNames, callerDepth-1, edgeTags)
+		}
+	}
+	taskCtx.Input.FuncTask = currentFuncTask
+}
+
+// GetCallerCandidateFiles returns the go files under the caller roots which mention the current function name.
+func GetCallerCandidateFiles(taskCtx *model.TaskCtx) []string {
+	source := taskCtx.Input.FuncTask.Source
+	var candidates []string
+	for _, root := range GetCallerRoots(taskCtx) {
+		for _, file := range GetRootGoFiles(taskCtx, root) {
+			contains, err := util.FileContainsString(file, taskCtx.Input.FuncTask.FuncName)
+			if err != nil || !contains {
+				continue
+			}
+			candidates = append(candidates, GetSourceInStyle(source, file))
+		}
+	}
+	slices.Sort(candidates)
+	return slices.Compact(candidates)
+}
+
+// GetRootGoFiles returns the go files under root, listed once per run.
+func GetRootGoFiles(taskCtx *model.TaskCtx, root string) []string {
+	if taskCtx.GoFilesMap == nil {
+		taskCtx.GoFilesMap = make(map[string][]string)
+	}
+	files, ok := taskCtx.GoFilesMap[root]
+	if !ok {
+		var err error
+		files, err = util.ListGoFiles(root)
+		if err != nil {
+			log.Printf("GetRootGoFiles ListGoFilesErr, root:%s, err:%+v", root, err)
+		}
+		taskCtx.GoFilesMap[root] = files
+	}
+	return files
+}
+
+// GetCallerRoots returns CallerRoots, or the directory of the go.mod of the current source,
+// or the directory of the current source.
+func GetCallerRoots(taskCtx *model.TaskCtx) []string {
+	if len(taskCtx.Input.FuncTask.CallerRoots) > 0 {
+		return taskCtx.Input.FuncTask.CallerRoots
+	}
+	dir := filepath.Dir(taskCtx.Input.FuncTask.Source)
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return []string{dir}
+	}
+	goModPath := util.FindFileUpwards(absDir, "go.mod")
+	if goModPath == "" {
+		return []string{dir}
+	}
+	return []string{filepath.Dir(goModPath)}
+}
+
+// GetSourceInStyle returns file relative to the working directory when source is relative,
+// and as source itself when both are the same file, so that FuncTaskKeys match.
+func GetSourceInStyle(source string, file string) string {
+	absSource, err1 := filepath.Abs(source)
+	absFile, err2 := filepath.Abs(file)
+	if err1 != nil || err2 != nil {
+		return file
+	}
+	if absSource == absFile {
+		return source
+	}
+	if filepath.IsAbs(source) {
+		return absFile
+	}
+	if filepath.Dir(absSource) == filepath.Dir(absFile) {
+		return filepath.Join(filepath.Dir(source), filepath.Base(file))
+	}
+	if relFile, err := filepath.Rel(filepath.Dir(absSource), absFile); err == nil {
+		return filepath.Join(filepath.Dir(source), relFile)
+	}
+	return absFile
+}
+
+// GetCallSites returns the calls of targetDecl in the function funcKey of source.
+// It sets the current task to the caller to resolve the expressions of the caller,
+// the caller restores the task.
+func GetCallSites(taskCtx *model.TaskCtx, targetDecl *ast.FuncDecl, source string, funcKey model.FuncKey) []*callSite {
+	targetFuncTask := taskCtx.Input.FuncTask
+	targetDir, err := filepath.Abs(filepath.Dir(targetFuncTask.Source))
+	if err != nil {
+		return nil
+	}
+	callerNodeInfo := GetFileInfoBySource(taskCtx, source).FuncMap[funcKey]
+	callerDecl, ok := callerNodeInfo.Node.(*ast.FuncDecl)
+	if !ok || callerDecl.Body == nil {
+		return nil
+	}
+	localNames := GetLocalNames(callerDecl)
+	var calls []*ast.CallExpr
+	ast.Inspect(callerDecl.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		switch fun := ast.Unparen(util.StripTypeArgs(call.Fun)).(type) {
+		case *ast.Ident:
+			// a local closure of the same name is not the target
+			if fun.Name == targetFuncTask.FuncName && !IsLocalName(localNames, fun) {
+				calls = append(calls, call)
+			}
+		case *ast.SelectorExpr:
+			if fun.Sel.Name == targetFuncTask.FuncName {
+				calls = append(calls, call)
+			}
+		}
+		return true
+	})
+	if len(calls) == 0 {
+		return nil
+	}
+
+	util.SetSubTask(&taskCtx.Input.FuncTask)
+	taskCtx.Input.FuncTask.Source = source
+	taskCtx.Input.FuncTask.RecvTypes = funcKey.RecvTypes
+	taskCtx.Input.FuncTask.FuncName = funcKey.Name
+	callSiteTags := GetCallSiteTags(callerDecl)
+	var callSites []*callSite
+	for _, call := range calls {
+		isCall, edgeTags := IsCallOf(taskCtx, call, targetDecl, targetDir, targetFuncTask.RecvTypes)
+		if isCall {
+			callSites = append(callSites, &callSite{call: call, edgeTags: util.MergeAndDeduplicate(edgeTags, callSiteTags[call])})
+		}
+	}
+	return callSites
+}
+
+// IsCallOf reports whether call of the current task calls targetDecl of targetDir,
+// directly or through an interface the receiver type of targetDecl implements.
+func IsCallOf(taskCtx *model.TaskCtx, call *ast.CallExpr, targetDecl *ast.FuncDecl, targetDir string, targetRecvTypes string) (bool, []string) {
+	if taskCtx.Input.FuncTask.TypeCheck {
+		if IsFuncVarCallByTypes(taskCtx, call) {
+			return false, nil
+		}
+		if fn := GetCallTargetByTypes(taskCtx, call); fn != nil {
+			if fn.Pos() == targetDecl.Name.Pos() {
+				return true, nil
+			}
+			if IsInterfaceMethod(fn) {
+				for _, implFunc := range GetInterfaceMethodImplsByTypes(taskCtx, fn) {
+					if implFunc.Pos() == targetDecl.Name.Pos() {
+						return true, []string{EdgeTagDynamic}
+					}
+				}
+			}
+			return false, nil
+		}
+	}
+
+	callerDir, err := filepath.Abs(filepath.Dir(taskCtx.Input.FuncTask.Source))
+	if err != nil {
+		return false, nil
+	}
+	switch fun := ast.Unparen(util.StripTypeArgs(call.Fun)).(type) {
+	case *ast.Ident:
+		return targetDecl.Recv == nil && callerDir == targetDir, nil
+	case *ast.SelectorExpr:
+		if targetDecl.Recv == nil {
+			pkgIdent, ok := fun.X.(*ast.Ident)
+			if !ok {
+				return false, nil
+			}
+			importPath := GetFileInfo(taskCtx).ImportMap[pkgIdent.Name]
+			if importPath == "" {
+				return false, nil
+			}
+			importDir, err := util.GetImportDir(callerDir, importPath)
+			if err != nil {
+				return false, nil
+			}
+			absImportDir, err := filepath.Abs(importDir)
+			return err == nil && absImportDir == targetDir, nil
+		}
+		exprType := GetExprType(taskCtx, fun.X, call.Pos())
+		if exprType == nil {
+			return false, nil
+		}
+		methodDir, recvTypes := GetMethodRecvTypes(taskCtx, exprType, fun.Sel.Name)
+		if recvTypes != "" {
+			absMethodDir, err := filepath.Abs(methodDir)
+			return err == nil && absMethodDir == targetDir && recvTypes == targetRecvTypes, nil
+		}
+		if !IsInterfaceType(taskCtx, exprType) {
+			return false, nil
+		}
+		for _, methodTarget := range GetInterfaceMethodTargets(taskCtx, exprType, fun.Sel.Name) {
+			absMethodDir, err := filepath.Abs(methodTarget.Dir)
+			if err == nil && absMethodDir == targetDir && methodTarget.RecvTypes == targetRecvTypes {
+				return true, []string{EdgeTagDynamic}
+			}
+		}
+	}
+	return false, nil
+}
+
+// GetCallSiteVarNames maps the relevant parameters and receiver of targetDecl to the names used at the call,
+// e.g. req.ID for the relevant p.ID of func Get(p *Req) called as Get(req), call being in source.
+func GetCallSiteVarNames(taskCtx *model.TaskCtx, targetDecl *ast.FuncDecl, source string, call *ast.CallExpr) []string {
+	varNames := taskCtx.Input.FuncTask.VarNames
+	var callVarNames []string
+	mapName := func(paramName string, arg ast.Expr) {
+		for _, varName := range varNames {
+			if varName != paramName && !strings.HasPrefix(varName, paramName+".") {
+				continue
+			}
+			if argIdent, ok := arg.(*ast.Ident); ok {
+				callVarNames = append(callVarNames, argIdent.Name+strings.TrimPrefix(varName, paramName))
+				continue
+			}
+			if argSelectorExpr, ok := arg.(*ast.SelectorExpr); ok {
+				if nameParts := GetSelectorExprNameParts(argSelectorExpr); len(nameParts) > 0 {
+					callVarNames = append(callVarNames, strings.Join(nameParts, ".")+strings.TrimPrefix(varName, paramName))
+					continue
+				}
+			}
+			callVarNames = append(callVarNames, GetSourceUsedVarNames(taskCtx, source, arg)...)
+		}
+	}
+	if targetDecl.Recv != nil {
+		if selectorExpr, ok := ast.Unparen(util.StripTypeArgs(call.Fun)).(*ast.SelectorExpr); ok {
+			for _, field := range targetDecl.Recv.List {
+				for _, ident := range field.Names {
+					mapName(ident.Name, selectorExpr.X)
+				}
+			}
+		}
+	}
+	argIdx := 0
+	for _, field := range targetDecl.Type.Params.List {
+		for _, ident := range field.Names {
+			if argIdx < len(call.Args) {
+				mapName(ident.Name, call.Args[argIdx])
+			}
+			argIdx++
+		}
+	}
+	return util.MergeAndDeduplicate(nil, callVarNames)
+}
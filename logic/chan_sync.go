@@ -0,0 +1,198 @@
+package logic
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"github.com/juicymango/yeah_woo_go/model"
+)
+
+// GetChanNames returns the names of the channels of funcDecl: the parameters and variables of a channel type,
+// the variables assigned a make(chan T), and the names sent on or received from.
+func GetChanNames(funcDecl *ast.FuncDecl) map[string]bool {
+	chanNames := make(map[string]bool)
+	addNames := func(exprs ...ast.Expr) {
+		for _, expr := range exprs {
+			for _, name := range GetAssignedVarNames(expr) {
+				chanNames[name] = true
+			}
+		}
+	}
+	for _, fieldList := range []*ast.FieldList{funcDecl.Recv, funcDecl.Type.Params} {
+		if fieldList == nil {
+			continue
+		}
+		for _, field := range fieldList.List {
+			if _, ok := field.Type.(*ast.ChanType); ok {
+				for _, name := range field.Names {
+					addNames(name)
+				}
+			}
+		}
+	}
+	if funcDecl.Body == nil {
+		return chanNames
+	}
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SendStmt:
+			addNames(x.Chan)
+		case *ast.UnaryExpr:
+			if x.Op == token.ARROW {
+				addNames(x.X)
+			}
+		case *ast.ValueSpec:
+			if _, ok := x.Type.(*ast.ChanType); ok {
+				for _, name := range x.Names {
+					addNames(name)
+				}
+			}
+		case *ast.AssignStmt:
+			if len(x.Lhs) != len(x.Rhs) {
+				return true
+			}
+			for idx, rhs := range x.Rhs {
+				if call, ok := rhs.(*ast.CallExpr); ok && len(call.Args) > 0 {
+					if _, ok := call.Args[0].(*ast.ChanType); ok {
+						addNames(x.Lhs[idx])
+					}
+				}
+			}
+		}
+		return true
+	})
+	return chanNames
+}
+
+// GetChanVarNames returns the names reached in one step from the current VarNames through the channels of body:
+// the channel a relevant value is sent on, e.g. ch in ch <- order, and the variables receiving from a relevant
+// channel, e.g. v in v := <-ch, v, ok := <-ch, case v := <-ch and for v := range ch.
+func GetChanVarNames(taskCtx *model.TaskCtx, body *ast.BlockStmt, chanNames map[string]bool) []string {
+	var varNames []string
+	isRelevantRecv := func(expr ast.Expr) bool {
+		unaryExpr, ok := ast.Unparen(expr).(*ast.UnaryExpr)
+		return ok && unaryExpr.Op == token.ARROW && ExprTouchesTarget(taskCtx, unaryExpr.X)
+	}
+	addRecv := func(lhs []ast.Expr, rhs []ast.Expr) {
+		for idx, expr := range rhs {
+			if !isRelevantRecv(expr) {
+				continue
+			}
+			if len(lhs) == len(rhs) {
+				varNames = append(varNames, GetAssignedVarNames(lhs[idx])...)
+			} else if len(rhs) == 1 && len(lhs) > 0 {
+				// v, ok := <-ch, ok is not the value
+				varNames = append(varNames, GetAssignedVarNames(lhs[0])...)
+			}
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SendStmt:
+			if ExprTouchesTarget(taskCtx, x.Value) {
+				varNames = append(varNames, GetAssignedVarNames(x.Chan)...)
+			}
+		case *ast.AssignStmt:
+			addRecv(x.Lhs, x.Rhs)
+		case *ast.ValueSpec:
+			lhs := make([]ast.Expr, 0, len(x.Names))
+			for _, ident := range x.Names {
+				lhs = append(lhs, ident)
+			}
+			addRecv(lhs, x.Values)
+		case *ast.RangeStmt:
+			names := GetAssignedVarNames(x.X)
+			if x.Key != nil && len(names) > 0 && chanNames[names[0]] && ExprTouchesTarget(taskCtx, x.X) {
+				varNames = append(varNames, GetAssignedVarNames(x.Key)...)
+			}
+		}
+		return true
+	})
+	return varNames
+}
+
+// GetLockStmts returns the Lock and Unlock, or RLock and RUnlock, calls of the same mutex around a kept statement,
+// which are not kept: a mu.Lock() statement followed in the same block by mu.Unlock(), or by defer mu.Unlock()
+// holding the lock until the end of the block.
+func GetLockStmts(funcDecl *ast.FuncDecl, keptStmts map[ast.Node]bool) []ast.Stmt {
+	if funcDecl.Body == nil {
+		return nil
+	}
+	var lockStmts []ast.Stmt
+	checkList := func(stmts []ast.Stmt) {
+		for idx, stmt := range stmts {
+			mutex, unlockName := GetLockCall(stmt)
+			if mutex == "" {
+				continue
+			}
+			var unlockStmt ast.Stmt
+			end := stmts[len(stmts)-1].End()
+			for _, nextStmt := range stmts[idx+1:] {
+				if exprStmt, ok := nextStmt.(*ast.ExprStmt); ok && IsMethodCallOf(exprStmt.X, mutex, unlockName) {
+					unlockStmt, end = nextStmt, nextStmt.Pos()
+					break
+				}
+				if deferStmt, ok := nextStmt.(*ast.DeferStmt); ok && IsMethodCallOf(deferStmt.Call, mutex, unlockName) {
+					unlockStmt = nextStmt
+					break
+				}
+			}
+			if unlockStmt == nil || keptStmts[stmt] && keptStmts[unlockStmt] {
+				continue
+			}
+			for keptStmt := range keptStmts {
+				if keptStmt != unlockStmt && keptStmt.Pos() >= stmt.End() && keptStmt.End() <= end {
+					lockStmts = append(lockStmts, stmt, unlockStmt)
+					break
+				}
+			}
+		}
+	}
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.BlockStmt:
+			checkList(x.List)
+		case *ast.CaseClause:
+			checkList(x.Body)
+		case *ast.CommClause:
+			checkList(x.Body)
+		}
+		return true
+	})
+	return lockStmts
+}
+
+// GetLockCall returns the mutex of a mu.Lock() or mu.RLock() statement, e.g. "s.mu",
+// with the name of the method releasing it, or "" for other statements.
+func GetLockCall(stmt ast.Stmt) (string, string) {
+	exprStmt, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return "", ""
+	}
+	call, ok := exprStmt.X.(*ast.CallExpr)
+	if !ok || len(call.Args) > 0 {
+		return "", ""
+	}
+	fun, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return "", ""
+	}
+	switch fun.Sel.Name {
+	case "Lock":
+		return types.ExprString(fun.X), "Unlock"
+	case "RLock":
+		return types.ExprString(fun.X), "RUnlock"
+	}
+	return "", ""
+}
+
+// IsMethodCallOf reports whether expr calls the method funcName of recv without arguments, e.g. mu.Unlock().
+func IsMethodCallOf(expr ast.Expr, recv string, funcName string) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || len(call.Args) > 0 {
+		return false
+	}
+	fun, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && fun.Sel.Name == funcName && types.ExprString(fun.X) == recv
+}
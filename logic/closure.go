@@ -0,0 +1,143 @@
+package logic
+
+import (
+	"go/ast"
+	"slices"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+const (
+	EdgeTagAsync    = "async"    // the call runs in a goroutine
+	EdgeTagDeferred = "deferred" // the call runs when the caller returns
+)
+
+// GetClosureVarNames returns the names reached in one step from the current VarNames through the function literals of body:
+// the names a function literal touching a target is assigned to, e.g. f in f := func() { total++ },
+// so that the later calls of f are relevant, and the parameters of a function literal called with
+// relevant arguments, directly or through such a name, e.g. v in f := func(v int) {...}; f(total).
+func GetClosureVarNames(taskCtx *model.TaskCtx, body *ast.BlockStmt, funcLitMap map[string][]*ast.FuncLit) []string {
+	var varNames []string
+	for name, funcLits := range funcLitMap {
+		if slices.ContainsFunc(funcLits, func(funcLit *ast.FuncLit) bool { return ExprTouchesTarget(taskCtx, funcLit) }) {
+			varNames = append(varNames, name)
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		for _, funcLit := range GetCalledFuncLits(call, funcLitMap) {
+			for idx, paramName := range GetFuncTypeParamNames(funcLit.Type) {
+				if paramName != "_" && idx < len(call.Args) && ExprTouchesTarget(taskCtx, call.Args[idx]) {
+					varNames = append(varNames, paramName)
+				}
+			}
+		}
+		return true
+	})
+	slices.Sort(varNames)
+	return varNames
+}
+
+// GetFuncLitMap returns the function literals assigned in body by the name they are assigned to,
+// e.g. "f" for f := func() {...} and "s.OnDone" for s.OnDone = func() {...}.
+func GetFuncLitMap(body *ast.BlockStmt) map[string][]*ast.FuncLit {
+	funcLitMap := make(map[string][]*ast.FuncLit)
+	addFuncLit := func(lhs ast.Expr, rhs ast.Expr) {
+		funcLit, ok := ast.Unparen(rhs).(*ast.FuncLit)
+		if !ok {
+			return
+		}
+		for _, name := range GetAssignedVarNames(lhs) {
+			funcLitMap[name] = append(funcLitMap[name], funcLit)
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			if len(x.Lhs) == len(x.Rhs) {
+				for idx := range x.Lhs {
+					addFuncLit(x.Lhs[idx], x.Rhs[idx])
+				}
+			}
+		case *ast.ValueSpec:
+			if len(x.Names) == len(x.Values) {
+				for idx := range x.Names {
+					addFuncLit(x.Names[idx], x.Values[idx])
+				}
+			}
+		}
+		return true
+	})
+	return funcLitMap
+}
+
+// GetCalledFuncLits returns the function literals call may run: the called literal itself,
+// or the ones assigned to the called name.
+func GetCalledFuncLits(call *ast.CallExpr, funcLitMap map[string][]*ast.FuncLit) []*ast.FuncLit {
+	fun := ast.Unparen(call.Fun)
+	if funcLit, ok := fun.(*ast.FuncLit); ok {
+		return []*ast.FuncLit{funcLit}
+	}
+	// e.g. "handlers" for handlers[key](), like handlers[key] = func() {...} in GetFuncLitMap
+	if names := GetAssignedVarNames(fun); len(names) > 0 {
+		return funcLitMap[names[0]]
+	}
+	return nil
+}
+
+// GetFuncTypeParamNames returns the names of the parameters of funcType in order, "_" for the unnamed ones.
+func GetFuncTypeParamNames(funcType *ast.FuncType) []string {
+	if funcType.Params == nil {
+		return nil
+	}
+	var paramNames []string
+	for _, field := range funcType.Params.List {
+		if len(field.Names) == 0 {
+			paramNames = append(paramNames, "_")
+			continue
+		}
+		for _, name := range field.Names {
+			paramNames = append(paramNames, name.Name)
+		}
+	}
+	return paramNames
+}
+
+// GetCallSiteTags returns the edge tags of the calls of funcDecl which do not run in place:
+// EdgeTagAsync for the call of a go statement, EdgeTagDeferred for the call of a defer statement,
+// and the same for the calls in the function literal they run, directly or through the name it is assigned to.
+func GetCallSiteTags(funcDecl *ast.FuncDecl) map[ast.Node][]string {
+	if funcDecl.Body == nil {
+		return nil
+	}
+	var funcLitMap map[string][]*ast.FuncLit
+	callSiteTags := make(map[ast.Node][]string)
+	addTag := func(call *ast.CallExpr, edgeTag string) {
+		callSiteTags[call] = util.MergeAndDeduplicate(callSiteTags[call], []string{edgeTag})
+		if funcLitMap == nil {
+			funcLitMap = GetFuncLitMap(funcDecl.Body)
+		}
+		for _, funcLit := range GetCalledFuncLits(call, funcLitMap) {
+			ast.Inspect(funcLit.Body, func(n ast.Node) bool {
+				if innerCall, ok := n.(*ast.CallExpr); ok {
+					callSiteTags[innerCall] = util.MergeAndDeduplicate(callSiteTags[innerCall], []string{edgeTag})
+				}
+				return true
+			})
+		}
+	}
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.GoStmt:
+			addTag(x.Call, EdgeTagAsync)
+		case *ast.DeferStmt:
+			addTag(x.Call, EdgeTagDeferred)
+		}
+		return true
+	})
+	return callSiteTags
+}
@@ -0,0 +1,184 @@
+package logic
+
+import (
+	"go/ast"
+	"go/token"
+	"log"
+	"slices"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// FilterRelevantFuncNodeInfo filters the relevant nodes of funcNodeInfo. The locks of a mutex around a kept statement,
+// and with ControlDeps the jump statements deciding whether a kept statement runs, are kept too,
+// with the conditions guarding them, until none is added.
+func FilterRelevantFuncNodeInfo(taskCtx *model.TaskCtx, funcNodeInfo *model.NodeInfo) *model.NodeInfo {
+	taskCtx.ControlNodes = nil
+	filterRelevantNodeInfo := FilterRelevantNodeInfo(taskCtx, funcNodeInfo)
+	funcDecl, ok := funcNodeInfo.Node.(*ast.FuncDecl)
+	if !ok || funcDecl.Body == nil {
+		return filterRelevantNodeInfo
+	}
+	var stmtDepsList []*model.StmtDeps
+	if taskCtx.Input.FuncTask.ControlDeps {
+		stmtDepsList = GetStmtDepsList(funcDecl)
+	}
+	controlNodes := make(map[ast.Node]bool)
+	for {
+		keptStmts := GetKeptStmts(filterRelevantNodeInfo)
+		newStmtCount := 0
+		newStmts := GetLockStmts(funcDecl, keptStmts)
+		if taskCtx.Input.FuncTask.ControlDeps {
+			newStmts = append(newStmts, GetDecidingJumpStmts(stmtDepsList, keptStmts)...)
+		}
+		for _, stmt := range newStmts {
+			if !controlNodes[stmt] {
+				controlNodes[stmt] = true
+				newStmtCount++
+			}
+		}
+		if newStmtCount == 0 {
+			break
+		}
+		log.Printf("FilterRelevantFuncNodeInfo ControlNodes, task:%s, newStmts:%d", util.JsonString(&taskCtx.Input.FuncTask), newStmtCount)
+		// again, the statements guarding them may be decided by other jumps
+		taskCtx.ControlNodes = controlNodes
+		if result := taskCtx.FuncTaskMap[util.GetFuncTaskKey(taskCtx.Input.FuncTask)]; result != nil {
+			result.Matches = nil
+		}
+		filterRelevantNodeInfo = FilterRelevantNodeInfo(taskCtx, funcNodeInfo)
+	}
+	taskCtx.ControlNodes = nil
+	return filterRelevantNodeInfo
+}
+
+// GetDecidingJumpStmts returns the jump statements not kept which decide whether a kept statement runs:
+// a return, goto or panic before it or in the same loop, unless they are in different branches of an if or switch,
+// a break of a loop around it, a continue before it in the loop, and a break before it in the same case clause.
+func GetDecidingJumpStmts(stmtDepsList []*model.StmtDeps, keptStmts map[ast.Node]bool) []ast.Stmt {
+	var jumpStmts []ast.Stmt
+	for _, jumpDeps := range stmtDepsList {
+		if keptStmts[jumpDeps.Stmt] || !IsJumpStmt(jumpDeps.Stmt) {
+			continue
+		}
+		for _, stmtDeps := range stmtDepsList {
+			if !keptStmts[stmtDeps.Stmt] || slices.Contains(jumpDeps.Parents, stmtDeps.Stmt) {
+				continue
+			}
+			if IsDecidingJumpStmt(jumpDeps, stmtDeps) {
+				jumpStmts = append(jumpStmts, jumpDeps.Stmt)
+				break
+			}
+		}
+	}
+	return jumpStmts
+}
+
+// IsJumpStmt reports whether stmt may stop the statements after it from running.
+func IsJumpStmt(stmt ast.Stmt) bool {
+	switch x := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BranchStmt:
+		return x.Tok != token.FALLTHROUGH
+	case *ast.ExprStmt:
+		return IsExitCall(x.X)
+	}
+	return false
+}
+
+// IsExitCall reports whether expr is a call never returning, like panic, os.Exit or log.Fatal.
+func IsExitCall(expr ast.Expr) bool {
+	callExpr, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	switch fun := callExpr.Fun.(type) {
+	case *ast.Ident:
+		return fun.Name == "panic"
+	case *ast.SelectorExpr:
+		pkg, ok := fun.X.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		switch pkg.Name {
+		case "os":
+			return fun.Sel.Name == "Exit"
+		case "log":
+			return slices.Contains([]string{"Fatal", "Fatalf", "Fatalln", "Panic", "Panicf", "Panicln"}, fun.Sel.Name)
+		}
+	}
+	return false
+}
+
+// IsDecidingJumpStmt reports whether the jump statement of jumpDeps decides whether the statement of stmtDeps runs.
+func IsDecidingJumpStmt(jumpDeps *model.StmtDeps, stmtDeps *model.StmtDeps) bool {
+	isBefore := jumpDeps.Stmt.End() <= stmtDeps.Stmt.Pos() && !IsInExclusiveBranches(jumpDeps, stmtDeps)
+	branchStmt, ok := jumpDeps.Stmt.(*ast.BranchStmt)
+	if !ok || branchStmt.Tok == token.GOTO {
+		// the next iterations of a loop around both do not run either
+		return isBefore || slices.ContainsFunc(jumpDeps.Loops, func(loop ast.Stmt) bool { return slices.Contains(stmtDeps.Loops, loop) })
+	}
+	target := GetBranchTarget(jumpDeps, branchStmt)
+	if target == nil || !slices.Contains(stmtDeps.Parents, target) {
+		return false
+	}
+	switch target.(type) {
+	case *ast.ForStmt, *ast.RangeStmt:
+		if branchStmt.Tok == token.BREAK {
+			return true
+		}
+		return isBefore
+	}
+	// the break of a switch or select only skips the rest of its case clause
+	return isBefore
+}
+
+// GetBranchTarget returns the statement a break or continue leaves, the labeled one or the innermost.
+func GetBranchTarget(jumpDeps *model.StmtDeps, branchStmt *ast.BranchStmt) ast.Stmt {
+	for idx := len(jumpDeps.Parents) - 1; idx >= 0; idx-- {
+		parent := jumpDeps.Parents[idx]
+		if branchStmt.Label != nil {
+			if labeledStmt, ok := parent.(*ast.LabeledStmt); ok && labeledStmt.Label.Name == branchStmt.Label.Name {
+				return labeledStmt.Stmt
+			}
+			continue
+		}
+		switch parent.(type) {
+		case *ast.ForStmt, *ast.RangeStmt:
+			return parent
+		case *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+			if branchStmt.Tok == token.BREAK {
+				return parent
+			}
+		}
+	}
+	return nil
+}
+
+// IsInExclusiveBranches reports whether the two statements are in different branches of an if, switch or select,
+// so that running one means the other does not run.
+func IsInExclusiveBranches(stmtDeps1 *model.StmtDeps, stmtDeps2 *model.StmtDeps) bool {
+	parents1 := append(slices.Clip(stmtDeps1.Parents), stmtDeps1.Stmt)
+	parents2 := append(slices.Clip(stmtDeps2.Parents), stmtDeps2.Stmt)
+	commonIdx := 0
+	for commonIdx < len(parents1) && commonIdx < len(parents2) && parents1[commonIdx] == parents2[commonIdx] {
+		commonIdx++
+	}
+	if commonIdx == 0 || commonIdx == len(parents1) || commonIdx == len(parents2) {
+		// no common parent, or one is in the other
+		return false
+	}
+	switch common := parents1[commonIdx-1].(type) {
+	case *ast.IfStmt:
+		isInBody := func(stmt ast.Stmt) bool {
+			return common.Body.Pos() <= stmt.Pos() && stmt.Pos() < common.Body.End()
+		}
+		return isInBody(parents1[commonIdx]) != isInBody(parents2[commonIdx])
+	case *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+		// in different case clauses
+		return true
+	}
+	return false
+}
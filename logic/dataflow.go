@@ -0,0 +1,239 @@
+package logic
+
+import (
+	"go/ast"
+	"go/types"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+const maxDataflowIterations = 100
+
+// PropagateVarNames adds to the VarNames of the current task, until nothing changes:
+// with Dataflow, the names which get their value from a relevant expression, e.g. b in b := a.X when a is relevant;
+// with BackwardDataflow, the names in the value assigned to a relevant left side, e.g. a.X when b is relevant.
+// The added names are recorded in DataflowVarNames of the task result.
+func PropagateVarNames(taskCtx *model.TaskCtx, funcNodeInfo *model.NodeInfo) {
+	funcDecl, ok := funcNodeInfo.Node.(*ast.FuncDecl)
+	if !ok || funcDecl.Body == nil {
+		return
+	}
+	seedVarNames := taskCtx.Input.FuncTask.VarNames
+	for i := 0; i < maxDataflowIterations; i++ {
+		newVarNames := util.MergeAndDeduplicate(taskCtx.Input.FuncTask.VarNames, GetDataflowVarNames(taskCtx, funcDecl.Body))
+		if len(newVarNames) == len(taskCtx.Input.FuncTask.VarNames) {
+			break
+		}
+		taskCtx.Input.FuncTask.VarNames = newVarNames
+	}
+
+	dataflowVarNames := slices.DeleteFunc(slices.Clone(taskCtx.Input.FuncTask.VarNames), func(varName string) bool {
+		return slices.Contains(seedVarNames, varName)
+	})
+	result := GetFuncTaskResult(taskCtx)
+	result.FuncTask.DataflowVarNames = util.MergeAndDeduplicate(result.FuncTask.DataflowVarNames, dataflowVarNames)
+	log.Printf("PropagateVarNames seedVarNames:%+v, dataflowVarNames:%+v", seedVarNames, dataflowVarNames)
+}
+
+// PropagateValueFlowVarNames adds to the VarNames of the current task, until nothing changes, the names
+// a value reaches through the function literals, see GetClosureVarNames, and the channels, see GetChanVarNames.
+// The added names are recorded in DataflowVarNames of the task result, like the ones of Dataflow.
+func PropagateValueFlowVarNames(taskCtx *model.TaskCtx, funcNodeInfo *model.NodeInfo) {
+	funcDecl, ok := funcNodeInfo.Node.(*ast.FuncDecl)
+	if !ok || funcDecl.Body == nil {
+		return
+	}
+	funcLitMap := GetFuncLitMap(funcDecl.Body)
+	chanNames := GetChanNames(funcDecl)
+	seedVarNames := taskCtx.Input.FuncTask.VarNames
+	for i := 0; i < maxDataflowIterations; i++ {
+		newVarNames := util.MergeAndDeduplicate(taskCtx.Input.FuncTask.VarNames, GetClosureVarNames(taskCtx, funcDecl.Body, funcLitMap))
+		newVarNames = util.MergeAndDeduplicate(newVarNames, GetChanVarNames(taskCtx, funcDecl.Body, chanNames))
+		if len(newVarNames) == len(taskCtx.Input.FuncTask.VarNames) {
+			break
+		}
+		taskCtx.Input.FuncTask.VarNames = newVarNames
+	}
+
+	flowVarNames := slices.DeleteFunc(slices.Clone(taskCtx.Input.FuncTask.VarNames), func(varName string) bool {
+		return slices.Contains(seedVarNames, varName)
+	})
+	if len(flowVarNames) == 0 {
+		return
+	}
+	result := GetFuncTaskResult(taskCtx)
+	result.FuncTask.DataflowVarNames = util.MergeAndDeduplicate(result.FuncTask.DataflowVarNames, flowVarNames)
+	log.Printf("PropagateValueFlowVarNames seedVarNames:%+v, flowVarNames:%+v", seedVarNames, flowVarNames)
+}
+
+// GetDataflowVarNames returns the names reached in one step from the current VarNames,
+// through assignments, var declarations and range clauses.
+func GetDataflowVarNames(taskCtx *model.TaskCtx, body *ast.BlockStmt) []string {
+	var varNames []string
+	addFlow := func(lhs []ast.Expr, rhs []ast.Expr) {
+		if len(lhs) == len(rhs) {
+			for idx := range lhs {
+				varNames = append(varNames, GetExprFlowVarNames(taskCtx, lhs[idx:idx+1], rhs[idx:idx+1])...)
+			}
+			return
+		}
+		// multi-value, e.g. a, err := f(x)
+		varNames = append(varNames, GetExprFlowVarNames(taskCtx, lhs, rhs)...)
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			addFlow(x.Lhs, x.Rhs)
+		case *ast.ValueSpec:
+			lhs := make([]ast.Expr, 0, len(x.Names))
+			for _, ident := range x.Names {
+				lhs = append(lhs, ident)
+			}
+			if len(x.Values) > 0 {
+				addFlow(lhs, x.Values)
+			}
+		case *ast.RangeStmt:
+			lhs := make([]ast.Expr, 0, 2)
+			for _, expr := range []ast.Expr{x.Key, x.Value} {
+				if expr != nil {
+					lhs = append(lhs, expr)
+				}
+			}
+			varNames = append(varNames, GetExprFlowVarNames(taskCtx, lhs, []ast.Expr{x.X})...)
+		}
+		return true
+	})
+	return varNames
+}
+
+// GetExprFlowVarNames returns the names of lhs if rhs touches a target with Dataflow,
+// and the names of rhs if lhs touches a target with BackwardDataflow.
+func GetExprFlowVarNames(taskCtx *model.TaskCtx, lhs []ast.Expr, rhs []ast.Expr) []string {
+	var varNames []string
+	if taskCtx.Input.FuncTask.Dataflow && slices.ContainsFunc(rhs, func(expr ast.Expr) bool { return ExprTouchesTarget(taskCtx, expr) }) {
+		for _, expr := range lhs {
+			varNames = append(varNames, GetAssignedVarNames(expr)...)
+		}
+	}
+	if taskCtx.Input.FuncTask.BackwardDataflow && slices.ContainsFunc(lhs, func(expr ast.Expr) bool { return ExprTouchesTarget(taskCtx, expr) }) {
+		for _, expr := range rhs {
+			varNames = append(varNames, GetSourceUsedVarNames(taskCtx, taskCtx.Input.FuncTask.Source, expr)...)
+		}
+	}
+	return varNames
+}
+
+// ExprTouchesTarget reports whether any identifier or selector in expr is a target variable,
+// the same check FilterRelevantNodeInfo does for each node.
+func ExprTouchesTarget(taskCtx *model.TaskCtx, expr ast.Expr) bool {
+	touches := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if touches {
+			return false
+		}
+		switch x := n.(type) {
+		case *ast.Ident:
+			touches = IsTargetVariable(taskCtx, x)
+		case *ast.SelectorExpr:
+			touches = IsTargetVariable(taskCtx, x)
+		}
+		return !touches
+	})
+	return touches
+}
+
+// GetAssignedVarNames returns the name written by an assignment to expr,
+// e.g. "b" for b, "a.B" for a.B, "m" for m[k] and "p" for *p.
+func GetAssignedVarNames(expr ast.Expr) []string {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		if x.Name == "_" {
+			return nil
+		}
+		return []string{x.Name}
+	case *ast.SelectorExpr:
+		nameParts := GetSelectorExprNameParts(x)
+		if len(nameParts) == 0 {
+			return nil
+		}
+		return []string{strings.Join(nameParts, ".")}
+	case *ast.IndexExpr:
+		return GetAssignedVarNames(x.X)
+	case *ast.StarExpr:
+		return GetAssignedVarNames(x.X)
+	case *ast.ParenExpr:
+		return GetAssignedVarNames(x.X)
+	}
+	return nil
+}
+
+// GetUsedVarNames returns the names of the variables read in expr, leaving out called functions.
+func GetUsedVarNames(expr ast.Expr) []string {
+	return GetUsedVarNamesExcept(expr, nil)
+}
+
+// GetSourceUsedVarNames is GetUsedVarNames for an expression of source, leaving out the import names,
+// e.g. strconv of strconv.Itoa(a) is not a variable.
+func GetSourceUsedVarNames(taskCtx *model.TaskCtx, source string, expr ast.Expr) []string {
+	return GetUsedVarNamesExcept(expr, func(ident *ast.Ident) bool {
+		return IsImportName(taskCtx, source, ident)
+	})
+}
+
+// IsImportName reports whether ident of source names an imported package rather than a variable.
+func IsImportName(taskCtx *model.TaskCtx, source string, ident *ast.Ident) bool {
+	if obj := GetIdentObject(taskCtx, ident); obj != nil {
+		_, ok := obj.(*types.PkgName)
+		return ok
+	}
+	if _, ok := GetImportMap(taskCtx, source)[ident.Name]; !ok {
+		return false
+	}
+	// a local variable hiding the import
+	return source != taskCtx.Input.FuncTask.Source || GetIdentType(taskCtx, ident.Name, ident.Pos()) == nil
+}
+
+// GetUsedVarNamesExcept is GetUsedVarNames leaving out the identifiers isExcluded, nil for none.
+func GetUsedVarNamesExcept(expr ast.Expr, isExcluded func(ident *ast.Ident) bool) []string {
+	var varNames []string
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.CallExpr:
+			for _, arg := range x.Args {
+				varNames = append(varNames, GetUsedVarNamesExcept(arg, isExcluded)...)
+			}
+			if selectorExpr, ok := x.Fun.(*ast.SelectorExpr); ok {
+				// the receiver of a method call, not the package of pkg.F
+				varNames = append(varNames, GetUsedVarNamesExcept(selectorExpr.X, isExcluded)...)
+			}
+			return false
+		case *ast.Ident:
+			if isExcluded != nil && isExcluded(x) {
+				return false
+			}
+			if !slices.Contains([]string{"nil", "true", "false", "iota"}, x.Name) {
+				varNames = append(varNames, GetAssignedVarNames(x)...)
+			}
+			return false
+		case *ast.SelectorExpr:
+			varNames = append(varNames, GetAssignedVarNames(x)...)
+			return false
+		case *ast.CompositeLit:
+			for _, elt := range x.Elts {
+				if keyValueExpr, ok := elt.(*ast.KeyValueExpr); ok {
+					elt = keyValueExpr.Value
+				}
+				varNames = append(varNames, GetUsedVarNamesExcept(elt, isExcluded)...)
+			}
+			return false
+		case *ast.FuncLit:
+			return false
+		}
+		return true
+	})
+	return varNames
+}
@@ -0,0 +1,159 @@
+package logic
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/juicymango/yeah_woo_go/model"
+)
+
+// DiskCacheVersion is the version of the FileSummaries on disk, to increase when their format
+// or the way they are built changes, so that the summaries of the older versions are not used.
+const DiskCacheVersion = 3
+
+// DiskCacheDirEnv is the environment variable giving the cache dir when the input does not.
+const DiskCacheDirEnv = "YEAH_WOO_GO_CACHE_DIR"
+
+// GetDiskCacheDir returns the dir of the FileSummaries of the current version, or "" without a disk cache.
+func GetDiskCacheDir(taskCtx *model.TaskCtx) string {
+	cacheDir := taskCtx.Input.CacheDir
+	if cacheDir == "" {
+		cacheDir = os.Getenv(DiskCacheDirEnv)
+	}
+	if cacheDir == "" {
+		return ""
+	}
+	return filepath.Join(cacheDir, fmt.Sprintf("v%d", DiskCacheVersion))
+}
+
+// GetFileSummaryPath returns the file of the FileSummary of absSource in cacheDir, named by the hash of the path.
+func GetFileSummaryPath(cacheDir string, absSource string) string {
+	pathHash := sha256.Sum256([]byte(absSource))
+	return filepath.Join(cacheDir, hex.EncodeToString(pathHash[:])+".json")
+}
+
+// GetContentHash returns the hex sha256 of content.
+func GetContentHash(content []byte) string {
+	contentHash := sha256.Sum256(content)
+	return hex.EncodeToString(contentHash[:])
+}
+
+// LoadFileSummary returns the FileSummary of absSource from the disk cache, or nil when there is none,
+// or when it was written by another version or for another content of the file.
+func LoadFileSummary(taskCtx *model.TaskCtx, absSource string) *model.FileSummary {
+	if fileSummary := taskCtx.FileSummaryMap[absSource]; fileSummary != nil {
+		return fileSummary
+	}
+	cacheDir := GetDiskCacheDir(taskCtx)
+	if cacheDir == "" {
+		return nil
+	}
+	summaryBytes, err := os.ReadFile(GetFileSummaryPath(cacheDir, absSource))
+	if err != nil {
+		return nil
+	}
+	fileSummary := &model.FileSummary{}
+	if err := json.Unmarshal(summaryBytes, fileSummary); err != nil {
+		log.Printf("LoadFileSummary UnmarshalErr, source:%s, err:%+v", absSource, err)
+		return nil
+	}
+	if fileSummary.Version != DiskCacheVersion || fileSummary.Path != absSource {
+		return nil
+	}
+	content, err := os.ReadFile(absSource)
+	if err != nil || GetContentHash(content) != fileSummary.ContentHash {
+		log.Printf("LoadFileSummary Changed, source:%s", absSource)
+		return nil
+	}
+	if taskCtx.FileSummaryMap == nil {
+		taskCtx.FileSummaryMap = make(map[string]*model.FileSummary)
+	}
+	taskCtx.FileSummaryMap[absSource] = fileSummary
+	return fileSummary
+}
+
+// SaveFileSummary writes the FileSummary of fileInfo, parsed from content, to the disk cache.
+// The errors are only logged, the next run parses the file again.
+func SaveFileSummary(taskCtx *model.TaskCtx, absSource string, content []byte, fileInfo *model.FileInfo) {
+	cacheDir := GetDiskCacheDir(taskCtx)
+	if cacheDir == "" || taskCtx.FileSummaryMap[absSource] != nil {
+		return
+	}
+	fileSummary := GetFileSummary(taskCtx, absSource, content, fileInfo)
+	if taskCtx.FileSummaryMap == nil {
+		taskCtx.FileSummaryMap = make(map[string]*model.FileSummary)
+	}
+	taskCtx.FileSummaryMap[absSource] = fileSummary
+
+	summaryBytes, err := json.Marshal(fileSummary)
+	if err != nil {
+		log.Printf("SaveFileSummary MarshalErr, source:%s, err:%+v", absSource, err)
+		return
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		log.Printf("SaveFileSummary MkdirAllErr, cacheDir:%s, err:%+v", cacheDir, err)
+		return
+	}
+	// through a temporary file, so that a run reading it never sees half of it
+	tmpFile, err := os.CreateTemp(cacheDir, "summary-*.tmp")
+	if err != nil {
+		log.Printf("SaveFileSummary CreateTempErr, cacheDir:%s, err:%+v", cacheDir, err)
+		return
+	}
+	_, err = tmpFile.Write(summaryBytes)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), GetFileSummaryPath(cacheDir, absSource))
+	}
+	if err != nil {
+		log.Printf("SaveFileSummary WriteErr, source:%s, err:%+v", absSource, err)
+		os.Remove(tmpFile.Name())
+	}
+}
+
+// GetFileSummary returns the FileSummary of fileInfo, parsed from content.
+func GetFileSummary(taskCtx *model.TaskCtx, absSource string, content []byte, fileInfo *model.FileInfo) *model.FileSummary {
+	fileSummary := &model.FileSummary{
+		Version:     DiskCacheVersion,
+		Path:        absSource,
+		ContentHash: GetContentHash(content),
+		Package:     fileInfo.Package,
+		Imports:     GetFileImports(fileInfo),
+		Funcs:       make([]model.SymbolSummary, 0),
+		Types:       make([]model.SymbolSummary, 0),
+		Vars:        make([]model.SymbolSummary, 0),
+	}
+	for funcKey, funcNode := range fileInfo.FuncMap {
+		position := taskCtx.FileSet.Position(funcNode.Node.Pos())
+		fileSummary.Funcs = append(fileSummary.Funcs, model.SymbolSummary{
+			RecvTypes: funcKey.RecvTypes,
+			Name:      funcKey.Name,
+			Line:      position.Line,
+			Column:    position.Column,
+		})
+	}
+	slices.SortFunc(fileSummary.Funcs, func(a, b model.SymbolSummary) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Column, b.Column))
+	})
+	ForEachGenDeclSpec(fileInfo, func(tok string, name string, spec *model.NodeInfo) {
+		position := taskCtx.FileSet.Position(spec.Node.Pos())
+		symbolSummary := model.SymbolSummary{Name: name, Line: position.Line, Column: position.Column}
+		switch tok {
+		case "type":
+			symbolSummary.TypeKind = GetTypeKind(spec)
+			fileSummary.Types = append(fileSummary.Types, symbolSummary)
+		case "var":
+			fileSummary.Vars = append(fileSummary.Vars, symbolSummary)
+		}
+	})
+	return fileSummary
+}
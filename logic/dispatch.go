@@ -0,0 +1,222 @@
+package logic
+
+import (
+	"go/ast"
+	"go/build"
+	"go/types"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+const EdgeTagDynamic = "dynamic"
+
+// FilterRelevantCallExprInterfaceMethod fans a call through an interface out to the method
+// of every type implementing the interface, see GetImplementationDirs, as dynamic-dispatch edges.
+func FilterRelevantCallExprInterfaceMethod(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo, exprType *model.ExprType, funcName string) {
+	methodTargets := GetInterfaceMethodTargets(taskCtx, exprType, funcName)
+	log.Printf("FilterRelevantCallExprInterfaceMethod funcName:%s, interface:%s, methodTargets:%s", funcName, GetExprTypeString(taskCtx, exprType), util.JsonString(methodTargets))
+	isFunNameRelevant := slices.Contains(taskCtx.Input.FuncTask.VarNames, funcName)
+	if !isFunNameRelevant && taskCtx.Input.FuncTask.OnlyRelevantFunc {
+		return
+	}
+	for _, methodTarget := range methodTargets {
+		funcDeclInfo := GetFuncDeclInPackage(taskCtx, methodTarget.Dir, methodTarget.RecvTypes, methodTarget.FuncName)
+		if funcDeclInfo == nil {
+			continue
+		}
+		FilterRelevantCallExprFuncFiles(taskCtx, nodeInfo, []string{funcDeclInfo.Source}, methodTarget.RecvTypes, methodTarget.FuncName, isFunNameRelevant, []string{EdgeTagDynamic})
+	}
+}
+
+// IsInterfaceType reports whether the underlying type of exprType is an interface.
+func IsInterfaceType(taskCtx *model.TaskCtx, exprType *model.ExprType) bool {
+	underlying := GetUnderlyingType(taskCtx, exprType)
+	if underlying == nil {
+		return false
+	}
+	_, ok := underlying.Expr.(*ast.InterfaceType)
+	return ok
+}
+
+// GetInterfaceMethods returns the methods of an interface type by name, including embedded interfaces.
+func GetInterfaceMethods(taskCtx *model.TaskCtx, exprType *model.ExprType) map[string]*ast.FuncType {
+	methods := make(map[string]*ast.FuncType)
+	GetInterfaceMethodsSub(taskCtx, exprType, methods, 0)
+	return methods
+}
+
+func GetInterfaceMethodsSub(taskCtx *model.TaskCtx, exprType *model.ExprType, methods map[string]*ast.FuncType, depth int) {
+	if depth > maxTypeResolveDepth {
+		return
+	}
+	underlying := GetUnderlyingType(taskCtx, exprType)
+	if underlying == nil {
+		return
+	}
+	interfaceType, ok := underlying.Expr.(*ast.InterfaceType)
+	if !ok {
+		return
+	}
+	for _, field := range interfaceType.Methods.List {
+		if funcType, ok := field.Type.(*ast.FuncType); ok {
+			for _, ident := range field.Names {
+				methods[ident.Name] = funcType
+			}
+			continue
+		}
+		embeddedType := &model.ExprType{Dir: underlying.Dir, Source: underlying.Source, Expr: field.Type}
+		GetInterfaceMethodsSub(taskCtx, embeddedType, methods, depth+1)
+	}
+}
+
+// GetInterfaceMethodTargets returns the method funcName of every named non-interface type of the
+// packages of GetImplementationDirs having all the methods of the interface, compared by name and number of
+// parameters and results.
+func GetInterfaceMethodTargets(taskCtx *model.TaskCtx, exprType *model.ExprType, funcName string) []*model.MethodTarget {
+	methods := GetInterfaceMethods(taskCtx, exprType)
+	if methods[funcName] == nil {
+		return nil
+	}
+	methodNames := util.SortedKeys(methods)
+	var methodTargets []*model.MethodTarget
+	interfaceDir, _ := GetNamedType(taskCtx, exprType)
+	for _, dir := range GetImplementationDirs(taskCtx, interfaceDir) {
+		packageInfo := GetPackageInfo(taskCtx, dir)
+		for _, typeName := range util.SortedKeys(packageInfo.TypeMap) {
+			typeDecl := packageInfo.TypeMap[typeName]
+			// known from the FileSummary, so that the files of the types that cannot implement the interface are not parsed
+			if typeDecl.IsInterface || (!typeDecl.Embeds && !HasMethodNames(packageInfo, typeName, methodNames)) {
+				continue
+			}
+			namedType := &model.ExprType{Dir: dir, Source: typeDecl.Source, Expr: ast.NewIdent(typeName)}
+			if IsInterfaceType(taskCtx, namedType) {
+				continue
+			}
+			implements := true
+			for methodName, funcType := range methods {
+				methodDir, recvTypes := GetMethodRecvTypes(taskCtx, namedType, methodName)
+				funcDeclInfo := GetFuncDeclInPackage(taskCtx, methodDir, recvTypes, methodName)
+				if recvTypes == "" || funcDeclInfo == nil || !IsSameFuncArity(funcDeclInfo.NodeInfo.Node.(*ast.FuncDecl).Type, funcType) {
+					implements = false
+					break
+				}
+			}
+			if !implements {
+				continue
+			}
+			methodDir, recvTypes := GetMethodRecvTypes(taskCtx, namedType, funcName)
+			methodTargets = append(methodTargets, &model.MethodTarget{Dir: methodDir, RecvTypes: recvTypes, FuncName: funcName})
+		}
+	}
+	return methodTargets
+}
+
+// IsSameFuncArity reports whether two function types have the same number of parameters and results.
+func IsSameFuncArity(funcType1 *ast.FuncType, funcType2 *ast.FuncType) bool {
+	return funcType1.Params.NumFields() == funcType2.Params.NumFields() && funcType1.Results.NumFields() == funcType2.Results.NumFields()
+}
+
+// FilterRelevantCallExprDispatchByTypes is FilterRelevantCallExprInterfaceMethod for type checking,
+// where fn is the abstract interface method.
+func FilterRelevantCallExprDispatchByTypes(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo, fn *types.Func) {
+	isFunNameRelevant := slices.Contains(taskCtx.Input.FuncTask.VarNames, fn.Name())
+	if !isFunNameRelevant && taskCtx.Input.FuncTask.OnlyRelevantFunc {
+		return
+	}
+	for _, implFunc := range GetInterfaceMethodImplsByTypes(taskCtx, fn) {
+		source, recvTypes := GetFuncDeclSourceByTypes(taskCtx, implFunc)
+		if source == "" {
+			continue
+		}
+		log.Printf("FilterRelevantCallExprDispatchByTypes Target, source:%s, recvTypes:%s, funcName:%s", source, recvTypes, implFunc.Name())
+		FilterRelevantCallExprFuncFiles(taskCtx, nodeInfo, []string{source}, recvTypes, implFunc.Name(), isFunNameRelevant, []string{EdgeTagDynamic})
+	}
+}
+
+// GetInterfaceMethodImplsByTypes returns the methods implementing the interface method fn,
+// for every named type of the packages of GetImplementationDirs whose pointer implements the interface.
+func GetInterfaceMethodImplsByTypes(taskCtx *model.TaskCtx, fn *types.Func) []*types.Func {
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok || sig.Recv() == nil {
+		return nil
+	}
+	iface, ok := sig.Recv().Type().Underlying().(*types.Interface)
+	if !ok {
+		return nil
+	}
+	var implFuncs []*types.Func
+	interfaceDir := filepath.Dir(taskCtx.FileSet.Position(fn.Pos()).Filename)
+	if absInterfaceDir, err := filepath.Abs(interfaceDir); err != nil || IsGoRootDir(absInterfaceDir) {
+		interfaceDir = ""
+	}
+	for _, dir := range GetImplementationDirs(taskCtx, interfaceDir) {
+		// nil for the package being checked, whose types are not complete yet
+		pkg := GetTypesPackage(taskCtx, dir).Pkg
+		if pkg == nil {
+			continue
+		}
+		scope := pkg.Scope()
+		for _, name := range scope.Names() {
+			typeName, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || typeName.IsAlias() || types.IsInterface(typeName.Type()) {
+				continue
+			}
+			if named, ok := typeName.Type().(*types.Named); ok && named.TypeParams().Len() > 0 {
+				continue
+			}
+			ptr := types.NewPointer(typeName.Type())
+			if !types.Implements(ptr, iface) {
+				continue
+			}
+			obj, _, _ := types.LookupFieldOrMethod(ptr, false, fn.Pkg(), fn.Name())
+			if implFunc, ok := obj.(*types.Func); ok {
+				implFuncs = append(implFuncs, implFunc)
+			}
+		}
+	}
+	return implFuncs
+}
+
+// GetImplementationDirs returns the sorted package directories to look for the implementations of an interface in:
+// the directories of the go files under the caller roots, the module root by default, and interfaceDir, "" for none.
+// They do not depend on the packages loaded so far, so the implementations do not depend on the order of the tasks.
+// The directory of the current source is spelled as in the task, the others are absolute like the imports.
+func GetImplementationDirs(taskCtx *model.TaskCtx, interfaceDir string) []string {
+	currentDir := filepath.Clean(filepath.Dir(taskCtx.Input.FuncTask.Source))
+	absCurrentDir, err := filepath.Abs(currentDir)
+	if err != nil {
+		absCurrentDir = currentDir
+	}
+	var dirs []string
+	addDir := func(dir string) {
+		absDir, err := filepath.Abs(dir)
+		if err != nil {
+			return
+		}
+		if absDir == absCurrentDir {
+			absDir = currentDir
+		}
+		dirs = append(dirs, absDir)
+	}
+	if interfaceDir != "" {
+		addDir(interfaceDir)
+	}
+	for _, root := range GetCallerRoots(taskCtx) {
+		for _, file := range GetRootGoFiles(taskCtx, root) {
+			addDir(filepath.Dir(file))
+		}
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+// IsGoRootDir reports whether absDir is in the standard library, whose implementations are not looked for.
+func IsGoRootDir(absDir string) bool {
+	goRoot := build.Default.GOROOT
+	return goRoot != "" && strings.HasPrefix(absDir, filepath.Clean(goRoot)+string(filepath.Separator))
+}
@@ -0,0 +1,77 @@
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// AddTaskError records err on the result of the current task, once.
+// Errors of a task without result, e.g. a caller being checked, are only logged.
+func AddTaskError(taskCtx *model.TaskCtx, err error) {
+	result := taskCtx.FuncTaskMap[util.GetFuncTaskKey(taskCtx.Input.FuncTask)]
+	if result == nil {
+		log.Printf("AddTaskError NoResult, err:%+v, task:%s", err, util.JsonString(&taskCtx.Input.FuncTask))
+		return
+	}
+	AddResultError(result, err)
+}
+
+// AddResultError records err on result, once.
+func AddResultError(result *model.FuncTaskResult, err error) {
+	for _, resultErr := range result.Errors {
+		if resultErr.Error() == err.Error() {
+			return
+		}
+	}
+	result.Errors = append(result.Errors, err)
+}
+
+// AddImportError records the error of resolving importPath on the current task.
+// The standard library is not resolved to a directory, so its errors are not recorded.
+func AddImportError(taskCtx *model.TaskCtx, importPath string, err error) {
+	if util.IsStdImportPath(importPath) {
+		return
+	}
+	AddTaskError(taskCtx, err)
+}
+
+// GetTaskErrors returns the errors of the input tasks which could not run, or without their SliceTarget, as TaskErrors.
+func GetTaskErrors(taskCtx *model.TaskCtx) error {
+	var errs []error
+	for _, result := range taskCtx.FuncTaskResults {
+		if !result.IsFromInput {
+			continue
+		}
+		key := util.GetFuncTaskKey(result.FuncTask)
+		if result.FuncNodeInfo != nil {
+			for _, err := range result.Errors {
+				if errors.Is(err, model.ErrSliceTarget) {
+					errs = append(errs, &model.TaskError{Key: key, Err: err})
+				}
+			}
+			continue
+		}
+		for _, err := range result.Errors {
+			errs = append(errs, &model.TaskError{Key: key, Err: err})
+		}
+		if len(result.Errors) == 0 {
+			errs = append(errs, &model.TaskError{Key: key, Err: model.ErrFuncNotFound})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckFuncLimit returns the error of Context, or ErrFuncLimit once MaxFuncs functions are analyzed.
+func CheckFuncLimit(taskCtx *model.TaskCtx) error {
+	if taskCtx.Context != nil && taskCtx.Context.Err() != nil {
+		return taskCtx.Context.Err()
+	}
+	if taskCtx.MaxFuncs > 0 && len(taskCtx.FuncTaskResults) >= taskCtx.MaxFuncs {
+		return fmt.Errorf("%w: %d", model.ErrFuncLimit, taskCtx.MaxFuncs)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package logic
+
+import (
+	"go/token"
+	"log"
+	"os"
+
+	"github.com/juicymango/yeah_woo_go/model"
+)
+
+// NewFileCache returns an empty FileCache, to share between runs.
+func NewFileCache() *model.FileCache {
+	return &model.FileCache{
+		FileSet: token.NewFileSet(),
+		Entries: make(map[string]*model.FileCacheEntry),
+	}
+}
+
+// fileCacheMinDroppedSize is the size of the changed files below which StartFileCacheRun keeps the FileSet.
+const fileCacheMinDroppedSize = 16 << 20
+
+// StartFileCacheRun returns the FileSet of a new run using fileCache, to call before the run parses any file.
+// A token.FileSet only grows: the files dropped from the cache since they changed stay in it. Once they take
+// more than the files still cached, and over fileCacheMinDroppedSize, it starts a new FileSet and empties the
+// cache, the files are then parsed again by the runs needing them.
+func StartFileCacheRun(fileCache *model.FileCache) *token.FileSet {
+	fileCache.Mu.Lock()
+	defer fileCache.Mu.Unlock()
+	if fileCache.DroppedSize < fileCacheMinDroppedSize {
+		return fileCache.FileSet
+	}
+	var cachedSize int64
+	for _, entry := range fileCache.Entries {
+		cachedSize += entry.Size
+	}
+	if fileCache.DroppedSize <= cachedSize {
+		return fileCache.FileSet
+	}
+	log.Printf("StartFileCacheRun NewFileSet, droppedSize:%d, cachedSize:%d, cachedFiles:%d", fileCache.DroppedSize, cachedSize, len(fileCache.Entries))
+	fileCache.FileSet = token.NewFileSet()
+	fileCache.Entries = make(map[string]*model.FileCacheEntry)
+	fileCache.DroppedSize = 0
+	return fileCache.FileSet
+}
+
+// GetCachedFileInfo returns the FileInfo of absSource parsed by a previous run of the server, or by another
+// task running at the same time, if the file has the same mtime and size. The ImportMap is rebuilt for the
+// ExtraImports of the current task. When it returns nil, the caller parses the file, and must call
+// PutCachedFileInfo, with nil if the file can not be parsed, for the tasks waiting for it.
+func GetCachedFileInfo(taskCtx *model.TaskCtx, absSource string, source string) *model.FileInfo {
+	fileCache := taskCtx.FileCache
+	if fileCache == nil {
+		return nil
+	}
+	fileCache.Mu.Lock()
+	for fileCache.Loading[absSource] != nil {
+		loading := fileCache.Loading[absSource]
+		fileCache.Mu.Unlock()
+		<-loading
+		fileCache.Mu.Lock()
+	}
+	entry := fileCache.Entries[absSource]
+	if entry != nil {
+		stat, err := os.Stat(absSource)
+		if err != nil || !stat.ModTime().Equal(entry.ModTime) || stat.Size() != entry.Size {
+			log.Printf("GetCachedFileInfo Changed, source:%s", source)
+			fileCache.DroppedSize += entry.Size
+			delete(fileCache.Entries, absSource)
+			entry = nil
+		}
+	}
+	if entry == nil {
+		if fileCache.Loading == nil {
+			fileCache.Loading = make(map[string]chan struct{})
+		}
+		fileCache.Loading[absSource] = make(chan struct{})
+		fileCache.Mu.Unlock()
+		return nil
+	}
+	fileCache.Mu.Unlock()
+
+	fileInfo := *entry.FileInfo
+	fileInfo.Source = source
+	GetFileInfoImportMap(taskCtx, &fileInfo)
+	return &fileInfo
+}
+
+// PutCachedFileInfo keeps a newly parsed FileInfo for the next runs of the server and the other tasks,
+// fileInfo is nil when the file could not be parsed.
+func PutCachedFileInfo(taskCtx *model.TaskCtx, absSource string, fileInfo *model.FileInfo) {
+	fileCache := taskCtx.FileCache
+	if fileCache == nil {
+		return
+	}
+	fileCache.Mu.Lock()
+	defer fileCache.Mu.Unlock()
+	if loading := fileCache.Loading[absSource]; loading != nil {
+		close(loading)
+		delete(fileCache.Loading, absSource)
+	}
+	if fileInfo == nil {
+		return
+	}
+	stat, err := os.Stat(absSource)
+	if err != nil {
+		return
+	}
+	if fileCache.Entries == nil {
+		fileCache.Entries = make(map[string]*model.FileCacheEntry)
+	}
+	// a copy, the current task may still change its ImportMap
+	cachedFileInfo := *fileInfo
+	fileCache.Entries[absSource] = &model.FileCacheEntry{
+		ModTime:  stat.ModTime(),
+		Size:     stat.Size(),
+		FileInfo: &cachedFileInfo,
+	}
+}
@@ -1,10 +1,13 @@
 package logic
 
 import (
+	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/token"
 	"log"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 
@@ -17,9 +20,25 @@ func FilterRelevantNodeInfo(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) *m
 		return nil
 	}
 
+	// FuncDecl dataflow, before checking the children
+	if nodeInfo.Type == "*ast.FuncDecl" && (taskCtx.Input.FuncTask.Dataflow || taskCtx.Input.FuncTask.BackwardDataflow) {
+		PropagateVarNames(taskCtx, nodeInfo)
+	}
+	// FuncDecl closures, channels and the calls run by go and defer, before checking the children
+	if nodeInfo.Type == "*ast.FuncDecl" {
+		PropagateValueFlowVarNames(taskCtx, nodeInfo)
+		taskCtx.CallSiteTags = GetCallSiteTags(nodeInfo.Node.(*ast.FuncDecl))
+	}
+	// FuncDecl backward slice, before checking the children
+	if nodeInfo.Type == "*ast.FuncDecl" {
+		taskCtx.SliceNodes = GetSliceNodes(taskCtx, nodeInfo)
+	}
+
 	// default
 	newNodeInfo := util.CloneNodeInfo(nodeInfo)
 	newNodeInfo.RelevantTaskResult = &model.RelevantTaskResult{}
+	// whether a statement in the node is kept, e.g. the body of an if
+	hasRelevantStmt := false
 	for name, fieldNodeInfo := range nodeInfo.NodeFields {
 		fieldNewNodeInfo := FilterRelevantNodeInfo(taskCtx, fieldNodeInfo)
 		if fieldNewNodeInfo == nil {
@@ -30,6 +49,9 @@ func FilterRelevantNodeInfo(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) *m
 			if fieldNewNodeInfo.RelevantTaskResult.IsRelevant || taskCtx.Input.FuncTask.ShowAll {
 				newNodeInfo.RelevantTaskResult.IsRelevant = true
 			}
+			if _, ok := fieldNodeInfo.Node.(ast.Stmt); ok && fieldNewNodeInfo.RelevantTaskResult.IsRelevant {
+				hasRelevantStmt = true
+			}
 			if fieldNewNodeInfo.RelevantTaskResult.NotFilterByBlock {
 				newNodeInfo.RelevantTaskResult.NotFilterByBlock = true
 			}
@@ -45,19 +67,36 @@ func FilterRelevantNodeInfo(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) *m
 			if fieldNewNodeInfo.RelevantTaskResult.IsRelevant || taskCtx.Input.FuncTask.ShowAll {
 				newNodeInfo.RelevantTaskResult.IsRelevant = true
 			}
+			if _, ok := fieldNodeInfo.Node.(ast.Stmt); ok && fieldNewNodeInfo.RelevantTaskResult.IsRelevant {
+				hasRelevantStmt = true
+			}
 			if fieldNewNodeInfo.RelevantTaskResult.NotFilterByBlock {
 				newNodeInfo.RelevantTaskResult.NotFilterByBlock = true
 			}
 		}
 	}
 
+	// the statements of a backward slice are kept whatever the names in them, and so are the ones holding
+	// a kept statement, e.g. the if around a jump of ControlNodes, blocks are kept by their statements
+	if _, ok := nodeInfo.Node.(ast.Stmt); ok && taskCtx.SliceNodes != nil && nodeInfo.Type != "*ast.BlockStmt" {
+		newNodeInfo.RelevantTaskResult.IsRelevant = taskCtx.SliceNodes[nodeInfo.Node] || hasRelevantStmt || taskCtx.Input.FuncTask.ShowAll
+	}
+	// the locks and jumps of the kept statements, see FilterRelevantFuncNodeInfo
+	if taskCtx.ControlNodes[nodeInfo.Node] {
+		newNodeInfo.RelevantTaskResult.IsRelevant = true
+	}
+
 	// Ident / SelectorExpr
 	if nodeInfo.Type == "*ast.Ident" || nodeInfo.Type == "*ast.SelectorExpr" {
 		if newNodeInfo.RelevantTaskResult.IsRelevant {
 			return newNodeInfo
 		}
 		expr := nodeInfo.Node.(ast.Expr)
-		newNodeInfo.RelevantTaskResult.IsRelevant = IsTargetVariable(taskCtx, expr) || taskCtx.Input.FuncTask.ShowAll
+		varName := GetTargetVarName(taskCtx, expr)
+		if varName != "" {
+			AddMatch(taskCtx, expr, varName)
+		}
+		newNodeInfo.RelevantTaskResult.IsRelevant = varName != "" || taskCtx.Input.FuncTask.ShowAll
 		log.Printf("FilterRelevantNodeInfo Ident / SelectorExpr, node:%+v, IsRelevant:%+v", util.JsonString(nodeInfo), newNodeInfo.RelevantTaskResult.IsRelevant)
 		return newNodeInfo
 	}
@@ -106,6 +145,7 @@ func FilterRelevantNodeInfo(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) *m
 	if nodeInfo.Type == "*ast.FuncDecl" {
 		FilterRelevantFuncCalls(taskCtx, nodeInfo)
 		FilterRelevantFuncCallerKeys(taskCtx, nodeInfo)
+		FilterRelevantFindCallers(taskCtx, nodeInfo)
 		return newNodeInfo
 	}
 
@@ -113,8 +153,19 @@ func FilterRelevantNodeInfo(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) *m
 }
 
 func IsTargetVariable(taskCtx *model.TaskCtx, expr ast.Expr) bool {
+	return GetTargetVarName(taskCtx, expr) != ""
+}
+
+// GetTargetVarName returns the element of VarNames expr matches, or "" if none.
+func GetTargetVarName(taskCtx *model.TaskCtx, expr ast.Expr) string {
+	leftmostIdent := GetLeftmostIdent(expr)
+	if taskCtx.Input.FuncTask.TypeCheck {
+		if isTarget, ok := IsTargetObject(taskCtx, leftmostIdent); ok && !isTarget {
+			return ""
+		}
+	}
 	if taskCtx.Input.FuncTask.SubsequenceMatch {
-		return IsTargetVariableSubsequenceMatch(taskCtx, expr)
+		return GetTargetVarNameSubsequenceMatch(taskCtx, expr)
 	}
 	var nameParts []string
 	switch x := expr.(type) {
@@ -124,7 +175,7 @@ func IsTargetVariable(taskCtx *model.TaskCtx, expr ast.Expr) bool {
 		// If varName is in the form of "a.B.C", construct the full name from SelectorExpr
 		nameParts = GetSelectorExprNameParts(x)
 	default:
-		return false
+		return ""
 	}
 	for _, varName := range taskCtx.Input.FuncTask.VarNames {
 		varNameParts := strings.Split(varName, ".")
@@ -138,14 +189,15 @@ func IsTargetVariable(taskCtx *model.TaskCtx, expr ast.Expr) bool {
 				break
 			}
 		}
-		if match {
-			return true
+		// with TypeCheck, the same variable, not another one of the same name in another scope
+		if match && IsVarNameObject(taskCtx, varName, leftmostIdent) {
+			return varName
 		}
 	}
-	return false
+	return ""
 }
 
-func IsTargetVariableSubsequenceMatch(taskCtx *model.TaskCtx, expr ast.Expr) bool {
+func GetTargetVarNameSubsequenceMatch(taskCtx *model.TaskCtx, expr ast.Expr) string {
 	var nameParts []string
 	switch x := expr.(type) {
 	case *ast.Ident:
@@ -154,15 +206,15 @@ func IsTargetVariableSubsequenceMatch(taskCtx *model.TaskCtx, expr ast.Expr) boo
 		// If varName is in the form of "a.B.C", construct the full name from SelectorExpr
 		nameParts = GetSelectorExprNameParts(x)
 	default:
-		return false
+		return ""
 	}
 	for _, varName := range taskCtx.Input.FuncTask.VarNames {
 		varNameParts := strings.Split(varName, ".")
 		if IsSubsequence(nameParts, varNameParts) {
-			return true
+			return varName
 		}
 	}
-	return false
+	return ""
 }
 
 // GetSelectorExprNameParts recursively constructs the full variable name from a SelectorExpr,
@@ -204,52 +256,145 @@ func IsSubsequence(needle, haystack []string) bool {
 	return false
 }
 
+// GetFuncNodeInfo returns the NodeInfo of the function of the current task, and sets the Source and the RecvTypes
+// of the task to those of its declaration, see FindFuncNodeInfo.
 func GetFuncNodeInfo(taskCtx *model.TaskCtx) *model.NodeInfo {
-	fileInfo := GetFileInfo(taskCtx)
-	if fileInfo == nil {
-		log.Printf("GetFuncNodeInfo fileInfo nil, task:%+v", util.JsonString(&taskCtx.Input.FuncTask))
-		return nil
-	}
-	funcNode := fileInfo.FuncMap[model.FuncKey{
+	declKey, declInfo, err := FindFuncNodeInfo(taskCtx, taskCtx.Input.FuncTask.Source, model.FuncKey{
 		RecvTypes: taskCtx.Input.FuncTask.RecvTypes,
 		Name:      taskCtx.Input.FuncTask.FuncName,
-	}]
-	if funcNode == nil {
-		log.Printf("GetFuncNodeInfo funcNode nil, task:%+v", util.JsonString(&taskCtx.Input.FuncTask))
+	})
+	if err != nil {
+		log.Printf("GetFuncNodeInfo Err, err:%+v, task:%+v", err, util.JsonString(&taskCtx.Input.FuncTask))
+		AddTaskError(taskCtx, err)
 		return nil
 	}
-	return funcNode
+	taskCtx.Input.FuncTask.Source = declInfo.Source
+	taskCtx.Input.FuncTask.RecvTypes = declKey.RecvTypes
+	return declInfo.NodeInfo
+}
+
+// FindFuncNodeInfo returns the function funcKey of the package of source, with the FuncKey and the source of its
+// declaration: source itself when it declares it, else the file of the package given by LookupFuncDecl,
+// e.g. for "Svc" or "(s *Svc)" naming a method of *Svc. The error wraps ErrParse or ErrFuncNotFound.
+func FindFuncNodeInfo(taskCtx *model.TaskCtx, source string, funcKey model.FuncKey) (model.FuncKey, *model.DeclInfo, error) {
+	fileInfo, err := LoadFileInfo(taskCtx, source)
+	if err != nil {
+		return model.FuncKey{}, nil, err
+	}
+	for _, declKey := range GetFuncKeySpellings(funcKey.RecvTypes, funcKey.Name) {
+		if funcNode := fileInfo.FuncMap[declKey]; funcNode != nil {
+			return declKey, &model.DeclInfo{Source: fileInfo.Source, NodeInfo: funcNode}, nil
+		}
+	}
+	withTests := strings.HasSuffix(source, "_test.go")
+	declKey, declInfo := LookupFuncDecl(taskCtx, filepath.Dir(source), funcKey.RecvTypes, funcKey.Name, withTests)
+	declInfo = ResolveDeclInfo(taskCtx, declInfo)
+	if declInfo == nil {
+		return model.FuncKey{}, nil, GetFuncNotFoundError(taskCtx, source, funcKey)
+	}
+	log.Printf("FindFuncNodeInfo OtherFile, source:%s, funcKey:%+v, declSource:%s, declKey:%+v", source, funcKey, declInfo.Source, declKey)
+	return declKey, &model.DeclInfo{Source: GetCanonicalSource(taskCtx, declInfo.Source), NodeInfo: declInfo.NodeInfo}, nil
 }
 
+// GetFileInfo returns the FileInfo of the source of the current task, or nil with the error on the task.
 func GetFileInfo(taskCtx *model.TaskCtx) *model.FileInfo {
-	if taskCtx.FileInfoMap[taskCtx.Input.FuncTask.Source] != nil {
-		return taskCtx.FileInfoMap[taskCtx.Input.FuncTask.Source]
+	fileInfo, err := LoadFileInfo(taskCtx, taskCtx.Input.FuncTask.Source)
+	if err != nil {
+		AddTaskError(taskCtx, err)
+		return nil
+	}
+	return fileInfo
+}
+
+// GetFileInfoBySource parses source once per run, "a.go" and "./a.go" share the same FileInfo.
+// It returns nil when source can not be parsed, the error is only kept in FileErrorMap, see GetFileError:
+// most files are parsed for the symbol index of their package, and are not those of the task.
+func GetFileInfoBySource(taskCtx *model.TaskCtx, source string) *model.FileInfo {
+	fileInfo, err := LoadFileInfo(taskCtx, source)
+	if err != nil {
+		return nil
+	}
+	return fileInfo
+}
+
+// GetFileError returns why source could not be parsed, or nil when it was parsed or not tried yet.
+func GetFileError(taskCtx *model.TaskCtx, source string) error {
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		absSource = source
+	}
+	return taskCtx.FileErrorMap[absSource]
+}
+
+// LoadFileInfo is GetFileInfoBySource returning the error, which wraps ErrParse.
+func LoadFileInfo(taskCtx *model.TaskCtx, source string) (*model.FileInfo, error) {
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		absSource = source
+	}
+	if taskCtx.FileInfoMap[absSource] != nil {
+		return taskCtx.FileInfoMap[absSource], nil
+	}
+	if err := taskCtx.FileErrorMap[absSource]; err != nil {
+		return nil, err
 	}
 
 	if taskCtx.FileInfoMap == nil {
 		taskCtx.FileInfoMap = make(map[string]*model.FileInfo)
 	}
+	if fileInfo := GetCachedFileInfo(taskCtx, absSource, source); fileInfo != nil {
+		taskCtx.FileInfoMap[absSource] = fileInfo
+		return fileInfo, nil
+	}
 	// Create a new token file set which is needed for parsing
 	if taskCtx.FileSet == nil {
 		taskCtx.FileSet = token.NewFileSet()
 	}
 
-	// Parse the file containing the Go program
-	fileNode, err := parser.ParseFile(taskCtx.FileSet, taskCtx.Input.FuncTask.Source, nil, parser.ParseComments)
+	// Parse the file containing the Go program, from its content read here with a disk cache to hash it
+	var content []byte
+	var src any
+	if GetDiskCacheDir(taskCtx) != "" {
+		if content, err = os.ReadFile(source); err == nil {
+			src = content
+		}
+	}
+	fileNode, err := parser.ParseFile(taskCtx.FileSet, source, src, parser.ParseComments)
 	if err != nil {
-		log.Printf("GetFileInfo ParseFileErr, err:%+v, task:%+v", err, util.JsonString(&taskCtx.Input.FuncTask))
-		return nil
+		log.Printf("GetFileInfo ParseFileErr, err:%+v, source:%s, task:%+v", err, source, util.JsonString(&taskCtx.Input.FuncTask))
+		if taskCtx.FileErrorMap == nil {
+			taskCtx.FileErrorMap = make(map[string]error)
+		}
+		// %w twice, so that a missing file is also fs.ErrNotExist
+		taskCtx.FileErrorMap[absSource] = fmt.Errorf("%w: %w", model.ErrParse, err)
+		PutCachedFileInfo(taskCtx, absSource, nil)
+		return nil, taskCtx.FileErrorMap[absSource]
 	}
 	nodeInfo := util.GetNodeInfo(fileNode)
 	fileInfo := &model.FileInfo{
+		Source:   source,
 		NodeInfo: nodeInfo,
 		Package:  nodeInfo.NodeFields["Name"].StringFields["Name"],
 	}
-	taskCtx.FileInfoMap[taskCtx.Input.FuncTask.Source] = fileInfo
+	taskCtx.FileInfoMap[absSource] = fileInfo
 
 	GetFileInfoFuncMap(taskCtx, fileInfo)
 	GetFileInfoImportMap(taskCtx, fileInfo)
-	return fileInfo
+	PutCachedFileInfo(taskCtx, absSource, fileInfo)
+	if content != nil {
+		SaveFileSummary(taskCtx, absSource, content, fileInfo)
+	}
+	return fileInfo, nil
+}
+
+// GetCanonicalSource returns the path source was first parsed with, so that a file reached
+// by a relative and by an absolute path gives a single FuncTaskKey.
+func GetCanonicalSource(taskCtx *model.TaskCtx, source string) string {
+	fileInfo := GetFileInfoBySource(taskCtx, source)
+	if fileInfo == nil {
+		return source
+	}
+	return fileInfo.Source
 }
 
 func GetFileInfoFuncMap(taskCtx *model.TaskCtx, fileInfo *model.FileInfo) {
@@ -281,7 +426,8 @@ func GetFileInfoFuncMap(taskCtx *model.TaskCtx, fileInfo *model.FileInfo) {
 					log.Printf("GetFileInfo FprintToStringFail, err:%+v, recv:%+v", err, util.JsonString(recv))
 					continue
 				}
-				recvTypes = append(recvTypes, recvType)
+				// a method of *List[T] has the key *List, see StripRecvTypeParams
+				recvTypes = append(recvTypes, util.StripRecvTypeParams(recvType))
 			}
 		}
 		recvTypesStr := strings.Join(recvTypes, ",")
@@ -293,20 +439,36 @@ func GetFileInfoFuncMap(taskCtx *model.TaskCtx, fileInfo *model.FileInfo) {
 }
 
 func GetFileInfoImportMap(taskCtx *model.TaskCtx, fileInfo *model.FileInfo) {
+	imports := GetFileImports(fileInfo)
+	if imports == nil {
+		return
+	}
+	fileInfo.ImportMap = imports
+	AddExtraImports(taskCtx, fileInfo.ImportMap)
+	log.Printf("GetFileInfoImportMap ImportMap:%+v", fileInfo.ImportMap)
+}
+
+// GetFileImports returns the imports of the file by name, nil when it has none.
+func GetFileImports(fileInfo *model.FileInfo) map[string]string {
 	imports, ok := fileInfo.NodeInfo.NodeListFields["Imports"]
 	if !ok {
-		return
+		return nil
 	}
-	fileInfo.ImportMap = make(map[string]string)
+	importMap := make(map[string]string)
 	for _, imp := range imports {
 		if imp.NodeFields["Name"] != nil {
-			fileInfo.ImportMap[imp.NodeFields["Name"].StringFields["Name"]] = util.RemoveQuotesIfPresent(imp.NodeFields["Path"].StringFields["Value"])
+			importMap[imp.NodeFields["Name"].StringFields["Name"]] = util.RemoveQuotesIfPresent(imp.NodeFields["Path"].StringFields["Value"])
 			continue
 		}
 		path := util.RemoveQuotesIfPresent(imp.NodeFields["Path"].StringFields["Value"])
 		name := util.GetPackageNameFromPath(path)
-		fileInfo.ImportMap[name] = path
+		importMap[name] = path
 	}
+	return importMap
+}
+
+// AddExtraImports adds the ExtraImports of the current task to importMap.
+func AddExtraImports(taskCtx *model.TaskCtx, importMap map[string]string) {
 	for _, imp := range taskCtx.Input.FuncTask.ExtraImports {
 		name := ""
 		path := imp
@@ -318,7 +480,6 @@ func GetFileInfoImportMap(taskCtx *model.TaskCtx, fileInfo *model.FileInfo) {
 		if name == "" {
 			name = util.GetPackageNameFromPath(path)
 		}
-		fileInfo.ImportMap[name] = path
+		importMap[name] = path
 	}
-	log.Printf("GetFileInfoImportMap ImportMap:%+v", fileInfo.ImportMap)
 }
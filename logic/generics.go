@@ -0,0 +1,152 @@
+package logic
+
+import (
+	"go/ast"
+	"go/types"
+	"log"
+	"path/filepath"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// GetCallTypeArgs returns the type arguments of a call of the current task, in the order of the type parameters:
+// the explicit ones of an instantiation like Map[int, string](xs), or with TypeCheck the inferred ones,
+// and those of the receiver for a method of a generic type. It returns nil when they are not known.
+func GetCallTypeArgs(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) []string {
+	if nodeInfo == nil {
+		return nil
+	}
+	call, ok := nodeInfo.Node.(*ast.CallExpr)
+	if !ok {
+		return nil
+	}
+	if typeArgs := GetCallTypeArgsByTypes(taskCtx, call); len(typeArgs) > 0 {
+		return typeArgs
+	}
+	var indices []ast.Expr
+	switch fun := ast.Unparen(call.Fun).(type) {
+	case *ast.IndexExpr:
+		indices = []ast.Expr{fun.Index}
+	case *ast.IndexListExpr:
+		indices = fun.Indices
+	}
+	typeArgs := make([]string, 0, len(indices))
+	for _, index := range indices {
+		typeArg, err := util.FprintToString(taskCtx.FileSet, index)
+		if err != nil {
+			log.Printf("GetCallTypeArgs FprintToStringFail, err:%+v", err)
+			return nil
+		}
+		typeArgs = append(typeArgs, typeArg)
+	}
+	return typeArgs
+}
+
+// GetCallTypeArgsByTypes returns the type arguments of a call given by type checking, or nil.
+func GetCallTypeArgsByTypes(taskCtx *model.TaskCtx, call *ast.CallExpr) []string {
+	if !taskCtx.Input.FuncTask.TypeCheck {
+		return nil
+	}
+	typesPackage := GetTypesPackage(taskCtx, filepath.Dir(taskCtx.Input.FuncTask.Source))
+	if typesPackage.Info == nil {
+		return nil
+	}
+	var typeList *types.TypeList
+	switch fun := ast.Unparen(util.StripTypeArgs(call.Fun)).(type) {
+	case *ast.Ident:
+		typeList = typesPackage.Info.Instances[fun].TypeArgs
+	case *ast.SelectorExpr:
+		if instance, ok := typesPackage.Info.Instances[fun.Sel]; ok {
+			typeList = instance.TypeArgs
+			break
+		}
+		selection := typesPackage.Info.Selections[fun]
+		if selection == nil {
+			return nil
+		}
+		recvType := selection.Recv()
+		if pointer, ok := recvType.(*types.Pointer); ok {
+			recvType = pointer.Elem()
+		}
+		if named, ok := recvType.(*types.Named); ok {
+			typeList = named.TypeArgs()
+		}
+	}
+	if typeList == nil {
+		return nil
+	}
+	typeArgs := make([]string, 0, typeList.Len())
+	for idx := range typeList.Len() {
+		typeArgs = append(typeArgs, types.TypeString(typeList.At(idx), types.RelativeTo(typesPackage.Pkg)))
+	}
+	return typeArgs
+}
+
+// GetTypeParamNames returns the names of the type parameters of a generic function,
+// or of the receiver of a method of a generic type, e.g. ["K", "V"] for func (m *Map[K, V]) Get.
+func GetTypeParamNames(funcDecl *ast.FuncDecl) []string {
+	var names []string
+	if funcDecl.Recv != nil && len(funcDecl.Recv.List) > 0 {
+		recvType := funcDecl.Recv.List[0].Type
+		if starExpr, ok := recvType.(*ast.StarExpr); ok {
+			recvType = starExpr.X
+		}
+		var indices []ast.Expr
+		switch x := recvType.(type) {
+		case *ast.IndexExpr:
+			indices = []ast.Expr{x.Index}
+		case *ast.IndexListExpr:
+			indices = x.Indices
+		}
+		for _, index := range indices {
+			if ident, ok := index.(*ast.Ident); ok {
+				names = append(names, ident.Name)
+			}
+		}
+		return names
+	}
+	if funcDecl.Type.TypeParams == nil {
+		return nil
+	}
+	for _, field := range funcDecl.Type.TypeParams.List {
+		for _, name := range field.Names {
+			names = append(names, name.Name)
+		}
+	}
+	return names
+}
+
+// GetTypeArgPairs pairs the type parameters of funcDecl with typeArgs, e.g. ["K=string", "V=int"].
+// The explicit type arguments may be fewer than the type parameters, the others are inferred.
+func GetTypeArgPairs(funcDecl *ast.FuncDecl, typeArgs []string) []string {
+	if len(typeArgs) == 0 {
+		return nil
+	}
+	typeParamNames := GetTypeParamNames(funcDecl)
+	var pairs []string
+	for idx, name := range typeParamNames {
+		if idx >= len(typeArgs) {
+			break
+		}
+		pairs = append(pairs, name+"="+typeArgs[idx])
+	}
+	return pairs
+}
+
+// AddCallTypeArgs records the type arguments of the calls of callerResult to calleeResult, see AddCallEdge.
+func AddCallTypeArgs(callerResult *model.FuncTaskResult, calleeResult *model.FuncTaskResult, typeArgs []string) {
+	if len(typeArgs) == 0 {
+		return
+	}
+	calleeKey := util.GetFuncTaskKey(calleeResult.FuncTask)
+	callerKey := util.GetFuncTaskKey(callerResult.FuncTask)
+	if callerResult.CalleeTypeArgs == nil {
+		callerResult.CalleeTypeArgs = make(map[model.FuncTaskKey][]string)
+	}
+	callerResult.CalleeTypeArgs[calleeKey] = util.MergeAndDeduplicate(callerResult.CalleeTypeArgs[calleeKey], typeArgs)
+	if calleeResult.CallerTypeArgs == nil {
+		calleeResult.CallerTypeArgs = make(map[model.FuncTaskKey][]string)
+	}
+	calleeResult.CallerTypeArgs[callerKey] = util.MergeAndDeduplicate(calleeResult.CallerTypeArgs[callerKey], typeArgs)
+}
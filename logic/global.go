@@ -0,0 +1,475 @@
+package logic
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+const (
+	GlobalAccessRead  = "read"
+	GlobalAccessWrite = "write"
+)
+
+// globalTarget is the declaration of the var or field of a GlobalTask.
+type globalTarget struct {
+	dir      string // absolute dir of the package
+	typeName string // the type of the field, "" for a var
+	name     string // the var or the field
+	declPos  token.Pos
+}
+
+// GetGlobalFuncTasks returns a task for each function of the scope of globalTask reading or writing its var or field,
+// in the order of the files and of the functions, with the names it is used by as VarNames and how as GlobalAccess,
+// and the GlobalSites of the reads and writes in the same order. The other settings of the tasks are the ones
+// of the Template. It sets the current task to each function to resolve its expressions, the caller restores the task.
+func GetGlobalFuncTasks(taskCtx *model.TaskCtx, globalTask model.GlobalTask) ([]model.FuncTask, []model.GlobalSite, error) {
+	target, err := GetGlobalTarget(taskCtx, globalTask)
+	if err != nil {
+		return nil, nil, err
+	}
+	var funcTasks []model.FuncTask
+	var globalSites []model.GlobalSite
+	for _, source := range GetGlobalCandidateFiles(taskCtx, globalTask, target) {
+		fileInfo := GetFileInfoBySource(taskCtx, source)
+		if fileInfo == nil {
+			continue
+		}
+		funcKeys := make([]model.FuncKey, 0, len(fileInfo.FuncMap))
+		for funcKey := range fileInfo.FuncMap {
+			funcKeys = append(funcKeys, funcKey)
+		}
+		slices.SortFunc(funcKeys, func(a, b model.FuncKey) int {
+			return strings.Compare(a.RecvTypes+"."+a.Name, b.RecvTypes+"."+b.Name)
+		})
+		for _, funcKey := range funcKeys {
+			funcDecl, ok := fileInfo.FuncMap[funcKey].Node.(*ast.FuncDecl)
+			if !ok || funcDecl.Body == nil {
+				continue
+			}
+			taskCtx.Input.FuncTask = globalTask.Template
+			util.SetSubTask(&taskCtx.Input.FuncTask)
+			taskCtx.Input.FuncTask.Source = source
+			taskCtx.Input.FuncTask.RecvTypes = funcKey.RecvTypes
+			taskCtx.Input.FuncTask.FuncName = funcKey.Name
+			varNames, sites := GetGlobalAccess(taskCtx, funcDecl, target)
+			if len(sites) == 0 {
+				continue
+			}
+			funcTask := taskCtx.Input.FuncTask
+			funcTask.VarNames = util.MergeAndDeduplicate(globalTask.Template.VarNames, varNames)
+			key := util.FuncTaskKeyToString(util.GetFuncTaskKey(funcTask))
+			var globalAccess []string
+			for _, site := range sites {
+				site.Global, site.Key = globalTask.Name, key
+				globalSites = append(globalSites, site)
+				globalAccess = util.MergeAndDeduplicate(globalAccess, []string{site.Access})
+			}
+			funcTask.GlobalAccess = globalAccess
+			log.Printf("GetGlobalFuncTasks Func, global:%s, source:%s, funcKey:%+v, varNames:%+v, globalAccess:%+v", globalTask.Name, source, funcKey, funcTask.VarNames, globalAccess)
+			funcTasks = append(funcTasks, funcTask)
+		}
+	}
+	return funcTasks, globalSites, nil
+}
+
+// GetGlobalTarget finds the declaration of the var or field of globalTask in the package of its Source,
+// or returns an error wrapping ErrGlobalNotFound.
+func GetGlobalTarget(taskCtx *model.TaskCtx, globalTask model.GlobalTask) (*globalTarget, error) {
+	dir := filepath.Dir(globalTask.Source)
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	notFoundErr := fmt.Errorf("%w: %s in %s", model.ErrGlobalNotFound, globalTask.Name, dir)
+	typeName, fieldName, isField := strings.Cut(globalTask.Name, ".")
+	if !isField {
+		varDecl := GetVarDecl(taskCtx, dir, globalTask.Name)
+		if varDecl == nil {
+			return nil, notFoundErr
+		}
+		for _, ident := range varDecl.NodeInfo.Node.(*ast.ValueSpec).Names {
+			if ident.Name == globalTask.Name {
+				return &globalTarget{dir: absDir, name: ident.Name, declPos: ident.Pos()}, nil
+			}
+		}
+		return nil, notFoundErr
+	}
+	typeDecl := GetTypeDecl(taskCtx, dir, typeName)
+	if typeDecl == nil {
+		return nil, notFoundErr
+	}
+	structType, ok := typeDecl.NodeInfo.Node.(*ast.TypeSpec).Type.(*ast.StructType)
+	if !ok {
+		return nil, notFoundErr
+	}
+	for _, field := range structType.Fields.List {
+		for _, ident := range field.Names {
+			if ident.Name == fieldName {
+				return &globalTarget{dir: absDir, typeName: typeName, name: fieldName, declPos: ident.Pos()}, nil
+			}
+		}
+	}
+	return nil, notFoundErr
+}
+
+// GetGlobalCandidateFiles returns the go files of the scope of globalTask mentioning the var or field, sorted.
+func GetGlobalCandidateFiles(taskCtx *model.TaskCtx, globalTask model.GlobalTask, target *globalTarget) []string {
+	dir := filepath.Dir(globalTask.Source)
+	files := GetPackageInfo(taskCtx, dir).Files
+	if globalTask.Scope == model.GlobalScopeModule {
+		root := dir
+		if goModPath := util.FindFileUpwards(target.dir, "go.mod"); goModPath != "" {
+			root = filepath.Dir(goModPath)
+		}
+		if taskCtx.GoFilesMap == nil {
+			taskCtx.GoFilesMap = make(map[string][]string)
+		}
+		var ok bool
+		files, ok = taskCtx.GoFilesMap[root]
+		if !ok {
+			var err error
+			files, err = util.ListGoFiles(root)
+			if err != nil {
+				log.Printf("GetGlobalCandidateFiles ListGoFilesErr, root:%s, err:%+v", root, err)
+			}
+			taskCtx.GoFilesMap[root] = files
+		}
+	} else if globalTask.Scope != "" && globalTask.Scope != model.GlobalScopePackage {
+		log.Printf("GetGlobalCandidateFiles UnknownScope, scope:%s", globalTask.Scope)
+	}
+	var candidates []string
+	for _, file := range files {
+		contains, err := util.FileContainsString(file, target.name)
+		if err != nil || !contains {
+			continue
+		}
+		candidates = append(candidates, GetSourceInStyle(globalTask.Source, file))
+	}
+	slices.Sort(candidates)
+	return slices.Compact(candidates)
+}
+
+// GetGlobalAccess returns the names funcDecl, the current function, uses the global by,
+// e.g. "config" or "pkg.Config" for a var and "o.Status" for a field, and a GlobalSite for each read and write
+// in the order of the source, without Global and Key. An assignment to the global or into it, an increment
+// and &global are writes, and so is the key of a field in a composite literal, e.g. Status in o := &Order{Status: s},
+// used by the names the literal is assigned to.
+func GetGlobalAccess(taskCtx *model.TaskCtx, funcDecl *ast.FuncDecl, target *globalTarget) ([]string, []model.GlobalSite) {
+	writtenExprs := GetWrittenExprs(funcDecl.Body)
+	localNames := GetLocalNames(funcDecl)
+	compositeLitNames := GetCompositeLitNames(funcDecl.Body)
+	var varNames []string
+	var sites []model.GlobalSite
+	addSite := func(expr ast.Expr, access string, names []string) {
+		position := taskCtx.FileSet.Position(expr.Pos())
+		sites = append(sites, model.GlobalSite{
+			Access: access,
+			Source: taskCtx.Input.FuncTask.Source,
+			Line:   position.Line,
+			Column: position.Column,
+			Expr:   types.ExprString(expr),
+		})
+		varNames = util.MergeAndDeduplicate(varNames, names)
+	}
+	addExpr := func(expr ast.Expr) {
+		access := GlobalAccessRead
+		if writtenExprs[expr] {
+			access = GlobalAccessWrite
+		}
+		addSite(expr, access, GetGlobalVarNames(expr))
+	}
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.SelectorExpr:
+			if IsGlobalExpr(taskCtx, x, target, localNames) {
+				addExpr(x)
+			}
+			// not the Sel, a field or var of another type or package
+			ast.Inspect(x.X, visit)
+			return false
+		case *ast.Ident:
+			if IsGlobalExpr(taskCtx, x, target, localNames) {
+				addExpr(x)
+			}
+		case *ast.CompositeLit:
+			for _, elt := range x.Elts {
+				keyValueExpr, ok := elt.(*ast.KeyValueExpr)
+				if !ok {
+					continue
+				}
+				if key, ok := keyValueExpr.Key.(*ast.Ident); ok && IsGlobalCompositeLitKey(taskCtx, x, key, target) {
+					addSite(key, GlobalAccessWrite, compositeLitNames[x])
+				}
+			}
+		case *ast.KeyValueExpr:
+			// the keys of a composite literal are fields, not vars
+			if _, ok := x.Key.(*ast.Ident); ok {
+				ast.Inspect(x.Value, visit)
+				return false
+			}
+		}
+		return true
+	}
+	ast.Inspect(funcDecl.Body, visit)
+	return varNames, sites
+}
+
+// IsGlobalExpr reports whether expr of the current function is the global target:
+// the var itself, or through the name of its package, e.g. pkg.Config, or the field of a value of its type.
+// With TypeCheck, the object of the identifier decides when it is known.
+func IsGlobalExpr(taskCtx *model.TaskCtx, expr ast.Expr, target *globalTarget, localNames map[string][]localScope) bool {
+	var ident *ast.Ident
+	switch x := expr.(type) {
+	case *ast.Ident:
+		ident = x
+	case *ast.SelectorExpr:
+		ident = x.Sel
+	}
+	if ident == nil || ident.Name != target.name {
+		return false
+	}
+	if obj := GetIdentObject(taskCtx, ident); obj != nil {
+		return obj.Pos() == target.declPos
+	}
+	sourceDir, err := filepath.Abs(filepath.Dir(taskCtx.Input.FuncTask.Source))
+	if err != nil {
+		return false
+	}
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return target.typeName == "" && sourceDir == target.dir && !IsLocalName(localNames, x)
+	case *ast.SelectorExpr:
+		if target.typeName == "" {
+			pkgIdent, ok := x.X.(*ast.Ident)
+			if !ok || IsLocalName(localNames, pkgIdent) {
+				return false
+			}
+			importDir := GetImportDirByName(taskCtx, taskCtx.Input.FuncTask.Source, pkgIdent.Name)
+			absImportDir, err := filepath.Abs(importDir)
+			return importDir != "" && err == nil && absImportDir == target.dir
+		}
+		xType := GetExprType(taskCtx, x.X, x.Pos())
+		if xType == nil {
+			return false
+		}
+		// the field may be promoted from an embedded type
+		typeDir, typeName := GetFieldOwnerType(taskCtx, xType, target.name)
+		return IsGlobalTargetType(target, typeDir, typeName)
+	}
+	return false
+}
+
+// IsGlobalCompositeLitKey reports whether key of compositeLit, of the current function, is the field target.
+func IsGlobalCompositeLitKey(taskCtx *model.TaskCtx, compositeLit *ast.CompositeLit, key *ast.Ident, target *globalTarget) bool {
+	if target.typeName == "" || key.Name != target.name {
+		return false
+	}
+	if obj := GetIdentObject(taskCtx, key); obj != nil {
+		return obj.Pos() == target.declPos
+	}
+	litType := GetExprType(taskCtx, compositeLit, compositeLit.Pos())
+	if litType == nil {
+		return false
+	}
+	typeDir, typeName := GetNamedType(taskCtx, litType)
+	return IsGlobalTargetType(target, typeDir, typeName)
+}
+
+// IsGlobalTargetType reports whether the type typeName of the package in typeDir declares the field target.
+func IsGlobalTargetType(target *globalTarget, typeDir string, typeName string) bool {
+	absTypeDir, err := filepath.Abs(typeDir)
+	return typeName == target.typeName && err == nil && absTypeDir == target.dir
+}
+
+// GetCompositeLitNames returns the names each composite literal of body is assigned to, e.g. o for o := &Order{}.
+func GetCompositeLitNames(body *ast.BlockStmt) map[*ast.CompositeLit][]string {
+	compositeLitNames := make(map[*ast.CompositeLit][]string)
+	addNames := func(lhs ast.Expr, rhs ast.Expr) {
+		rhs = ast.Unparen(rhs)
+		if unaryExpr, ok := rhs.(*ast.UnaryExpr); ok && unaryExpr.Op == token.AND {
+			rhs = ast.Unparen(unaryExpr.X)
+		}
+		if compositeLit, ok := rhs.(*ast.CompositeLit); ok {
+			compositeLitNames[compositeLit] = GetAssignedVarNames(lhs)
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			if len(x.Lhs) == len(x.Rhs) {
+				for idx := range x.Lhs {
+					addNames(x.Lhs[idx], x.Rhs[idx])
+				}
+			}
+		case *ast.ValueSpec:
+			if len(x.Names) == len(x.Values) {
+				for idx := range x.Names {
+					addNames(x.Names[idx], x.Values[idx])
+				}
+			}
+		}
+		return true
+	})
+	return compositeLitNames
+}
+
+// GetGlobalVarNames returns the name of a use of the global, e.g. "o.Status",
+// or the variable holding it when the use has no name, e.g. "items" for items[i].Status.
+func GetGlobalVarNames(expr ast.Expr) []string {
+	if GetLeftmostIdent(expr) != nil {
+		return GetAssignedVarNames(expr)
+	}
+	if selectorExpr, ok := expr.(*ast.SelectorExpr); ok {
+		return GetAssignedVarNames(selectorExpr.X)
+	}
+	return nil
+}
+
+// GetWrittenExprs returns the expressions body writes, with the ones they are part of,
+// e.g. a.B.C and a.B for a.B.C = x, items[i] for items[i]++, and v for &v.
+func GetWrittenExprs(body *ast.BlockStmt) map[ast.Expr]bool {
+	writtenExprs := make(map[ast.Expr]bool)
+	addWritten := func(expr ast.Expr) {
+		for expr != nil {
+			writtenExprs[expr] = true
+			switch x := expr.(type) {
+			case *ast.SelectorExpr:
+				expr = x.X
+			case *ast.IndexExpr:
+				expr = x.X
+			case *ast.StarExpr:
+				expr = x.X
+			case *ast.ParenExpr:
+				expr = x.X
+			default:
+				expr = nil
+			}
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range x.Lhs {
+				addWritten(lhs)
+			}
+		case *ast.IncDecStmt:
+			addWritten(x.X)
+		case *ast.UnaryExpr:
+			if x.Op == token.AND {
+				addWritten(x.X)
+			}
+		case *ast.RangeStmt:
+			if x.Tok == token.ASSIGN {
+				for _, expr := range []ast.Expr{x.Key, x.Value} {
+					if expr != nil {
+						addWritten(expr)
+					}
+				}
+			}
+		}
+		return true
+	})
+	return writtenExprs
+}
+
+// localScope is where a local name is visible, from after its declaration to the end of its block.
+type localScope struct {
+	pos token.Pos
+	end token.Pos
+}
+
+// GetLocalNames returns the names funcDecl declares, each with the scopes it is declared in: its receiver,
+// parameters and results, and the variables of its body, function literals included.
+func GetLocalNames(funcDecl *ast.FuncDecl) map[string][]localScope {
+	localNames := make(map[string][]localScope)
+	var stack []ast.Node
+	// the innermost function, block or statement of stack opening a scope
+	getScopeNode := func() ast.Node {
+		for idx := len(stack) - 1; idx >= 0; idx-- {
+			switch stack[idx].(type) {
+			case *ast.FuncDecl, *ast.FuncLit, *ast.BlockStmt, *ast.CaseClause, *ast.CommClause,
+				*ast.IfStmt, *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt:
+				return stack[idx]
+			}
+		}
+		return funcDecl
+	}
+	addIdents := func(pos token.Pos, exprs ...ast.Expr) {
+		scopeNode := getScopeNode()
+		for _, expr := range exprs {
+			if ident, ok := expr.(*ast.Ident); ok && ident.Name != "_" {
+				// the name declared too, before its scope in x := 1
+				localNames[ident.Name] = append(localNames[ident.Name], localScope{pos: ident.Pos(), end: ident.End()}, localScope{pos: pos, end: scopeNode.End()})
+			}
+		}
+	}
+	ast.Inspect(funcDecl, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		switch x := n.(type) {
+		case *ast.FuncType:
+			// the parameters of a function, not of a func type
+			if len(stack) > 0 {
+				switch stack[len(stack)-1].(type) {
+				case *ast.FuncDecl, *ast.FuncLit:
+				default:
+					return false
+				}
+			}
+		case *ast.Field:
+			for _, name := range x.Names {
+				addIdents(getScopeNode().Pos(), name)
+			}
+		case *ast.AssignStmt:
+			if x.Tok == token.DEFINE {
+				addIdents(x.End(), x.Lhs...)
+			}
+		case *ast.ValueSpec:
+			for _, name := range x.Names {
+				addIdents(x.End(), name)
+			}
+		case *ast.StructType, *ast.InterfaceType:
+			// fields and methods, not variables
+			return false
+		}
+		stack = append(stack, n)
+		if rangeStmt, ok := n.(*ast.RangeStmt); ok && rangeStmt.Tok == token.DEFINE {
+			addIdents(rangeStmt.Body.Pos(), rangeStmt.Key, rangeStmt.Value)
+		}
+		return true
+	})
+	return localNames
+}
+
+// IsLocalName reports whether ident is in the scope of a local name of GetLocalNames, so not a package-level one.
+func IsLocalName(localNames map[string][]localScope, ident *ast.Ident) bool {
+	for _, scope := range localNames[ident.Name] {
+		if scope.pos <= ident.Pos() && ident.Pos() < scope.end {
+			return true
+		}
+	}
+	return false
+}
+
+// IsInLocalScope reports whether pos is in the scope starting at scopePos of a local name of GetLocalNames,
+// e.g. after the end of the statement declaring it, up to the end of its block.
+func IsInLocalScope(localNames map[string][]localScope, name string, scopePos token.Pos, pos token.Pos) bool {
+	for _, scope := range localNames[name] {
+		if scope.pos == scopePos && scope.pos <= pos && pos < scope.end {
+			return true
+		}
+	}
+	return false
+}
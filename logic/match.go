@@ -0,0 +1,62 @@
+package logic
+
+import (
+	"go/ast"
+	"slices"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// AddMatch records on the current task result that expr is relevant because it matches varName.
+func AddMatch(taskCtx *model.TaskCtx, expr ast.Expr, varName string) {
+	result := GetFuncTaskResult(taskCtx)
+	name := varName
+	switch x := expr.(type) {
+	case *ast.Ident:
+		name = x.Name
+	case *ast.SelectorExpr:
+		name = strings.Join(GetSelectorExprNameParts(x), ".")
+	}
+	match := &model.Match{
+		Name:    name,
+		VarName: varName,
+		Rule:    model.MatchRulePrefix,
+		Origin:  model.MatchOriginVarNames,
+	}
+	switch {
+	case taskCtx.Input.FuncTask.SubsequenceMatch:
+		match.Rule = model.MatchRuleSubsequence
+	case taskCtx.Input.FuncTask.ExactMatch:
+		match.Rule = model.MatchRuleExact
+	}
+	if slices.Contains(result.FuncTask.DataflowVarNames, varName) {
+		match.Origin = model.MatchOriginDataflow
+	}
+	if _, ok := IsTargetObject(taskCtx, GetLeftmostIdent(expr)); ok {
+		match.TypeChecked = true
+	}
+	SetMatchPosition(taskCtx, match, expr)
+	result.Matches = append(result.Matches, match)
+}
+
+// AddCalleeMatch records on the caller result that call is relevant because the called function is.
+func AddCalleeMatch(taskCtx *model.TaskCtx, result *model.FuncTaskResult, call ast.Node, calleeKey model.FuncTaskKey) {
+	if call == nil {
+		return
+	}
+	match := &model.Match{
+		Name:    calleeKey.FuncName,
+		VarName: util.FuncTaskKeyToString(calleeKey),
+		Rule:    model.MatchRuleCallee,
+	}
+	SetMatchPosition(taskCtx, match, call)
+	result.Matches = append(result.Matches, match)
+}
+
+func SetMatchPosition(taskCtx *model.TaskCtx, match *model.Match, node ast.Node) {
+	position := taskCtx.FileSet.Position(node.Pos())
+	match.Line = position.Line
+	match.Column = position.Column
+}
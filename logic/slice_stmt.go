@@ -0,0 +1,148 @@
+package logic
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/juicymango/yeah_woo_go/model"
+)
+
+// GetSliceStmts compares the statements of funcDecl with the statements left in filterRelevantNodeInfo,
+// and returns, in source order, the kept statements and the outermost removed statements.
+// Blocks are not listed, their statements are.
+func GetSliceStmts(funcDecl *ast.FuncDecl, filterRelevantNodeInfo *model.NodeInfo) []*model.SliceStmt {
+	keptStmts := GetKeptStmts(filterRelevantNodeInfo)
+	var sliceStmts []*model.SliceStmt
+	var walk func(stmt ast.Stmt, parent ast.Node)
+	walk = func(stmt ast.Stmt, parent ast.Node) {
+		if !keptStmts[stmt] {
+			sliceStmts = append(sliceStmts, &model.SliceStmt{Stmt: stmt, Parent: parent})
+			return
+		}
+		children := GetChildStmts(stmt)
+		if _, ok := stmt.(*ast.BlockStmt); ok {
+			for _, child := range children {
+				walk(child, parent)
+			}
+			return
+		}
+		sliceStmt := &model.SliceStmt{Stmt: stmt, Parent: parent, Kept: true}
+		for _, child := range children {
+			if keptStmts[child] {
+				sliceStmt.HasKeptChild = true
+			}
+		}
+		sliceStmts = append(sliceStmts, sliceStmt)
+		for _, child := range children {
+			walk(child, stmt)
+		}
+	}
+	if funcDecl.Body != nil {
+		for _, stmt := range funcDecl.Body.List {
+			walk(stmt, funcDecl)
+		}
+	}
+	return sliceStmts
+}
+
+// GetKeptStmts returns the statements left in filterRelevantNodeInfo.
+func GetKeptStmts(filterRelevantNodeInfo *model.NodeInfo) map[ast.Node]bool {
+	keptStmts := make(map[ast.Node]bool)
+	var collectKept func(nodeInfo *model.NodeInfo)
+	collectKept = func(nodeInfo *model.NodeInfo) {
+		if nodeInfo == nil {
+			return
+		}
+		if _, ok := nodeInfo.Node.(ast.Stmt); ok {
+			keptStmts[nodeInfo.Node] = true
+		}
+		for _, fieldNodeInfo := range nodeInfo.NodeFields {
+			collectKept(fieldNodeInfo)
+		}
+		for _, fieldNodeInfos := range nodeInfo.NodeListFields {
+			for _, fieldNodeInfo := range fieldNodeInfos {
+				collectKept(fieldNodeInfo)
+			}
+		}
+	}
+	collectKept(filterRelevantNodeInfo)
+	return keptStmts
+}
+
+// GetChildStmts returns the statements directly nested in stmt, leaving out function literals.
+func GetChildStmts(stmt ast.Stmt) []ast.Stmt {
+	var children []ast.Stmt
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		if n == stmt {
+			return true
+		}
+		if _, ok := n.(*ast.FuncLit); ok {
+			return false
+		}
+		if child, ok := n.(ast.Stmt); ok {
+			children = append(children, child)
+			return false
+		}
+		return true
+	})
+	return children
+}
+
+// GetElidedRuns groups the removed statements following each other in the same parent.
+func GetElidedRuns(sliceStmts []*model.SliceStmt) [][]*model.SliceStmt {
+	var runs [][]*model.SliceStmt
+	var run []*model.SliceStmt
+	for _, sliceStmt := range sliceStmts {
+		if len(run) > 0 && (sliceStmt.Kept || sliceStmt.Parent != run[0].Parent) {
+			runs = append(runs, run)
+			run = nil
+		}
+		if !sliceStmt.Kept {
+			run = append(run, sliceStmt)
+		}
+	}
+	if len(run) > 0 {
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// GetSliceRanges returns the ranges of the kept and of the removed statements of funcDecl.
+// A kept statement containing other kept statements, like an if, gives the range of its first line only.
+func GetSliceRanges(fset *token.FileSet, funcDecl *ast.FuncDecl, filterRelevantNodeInfo *model.NodeInfo) ([]model.SourceRange, []model.SourceRange) {
+	kept := make([]model.SourceRange, 0)
+	removed := make([]model.SourceRange, 0)
+	for _, sliceStmt := range GetSliceStmts(funcDecl, filterRelevantNodeInfo) {
+		switch {
+		case !sliceStmt.Kept:
+			removed = append(removed, GetSourceRange(fset, sliceStmt.Stmt.Pos(), sliceStmt.Stmt.End()))
+		case sliceStmt.HasKeptChild:
+			kept = append(kept, GetSourceLineRange(fset, sliceStmt.Stmt.Pos()))
+		default:
+			kept = append(kept, GetSourceRange(fset, sliceStmt.Stmt.Pos(), sliceStmt.Stmt.End()))
+		}
+	}
+	return kept, removed
+}
+
+func GetSourceRange(fset *token.FileSet, start token.Pos, end token.Pos) model.SourceRange {
+	startPosition := fset.Position(start)
+	endPosition := fset.Position(end)
+	return model.SourceRange{
+		StartLine:   startPosition.Line,
+		StartColumn: startPosition.Column,
+		EndLine:     endPosition.Line,
+		EndColumn:   endPosition.Column,
+	}
+}
+
+// GetSourceLineRange returns the range from pos to the end of its line.
+func GetSourceLineRange(fset *token.FileSet, pos token.Pos) model.SourceRange {
+	file := fset.File(pos)
+	line := file.Line(pos)
+	end := token.Pos(file.Base() + file.Size())
+	if line < file.LineCount() {
+		end = file.LineStart(line+1) - 1
+	}
+	return GetSourceRange(fset, pos, end)
+}
@@ -0,0 +1,75 @@
+package logic
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// LookupFuncDecl finds the function or method funcName of the package in dir in its symbol index, see GetPackageInfo.
+// The receiver may be spelled "*Svc", "Svc" or "(s *Svc)", and a method of *Svc is found from "Svc" and the other way,
+// the FuncKey returned has the RecvTypes of the declaration. With withTests, the _test.go files of the package
+// named like the current file are looked into when the other files do not declare it.
+// The DeclInfo of a file indexed from the disk cache has no NodeInfo, see ResolveDeclInfo.
+func LookupFuncDecl(taskCtx *model.TaskCtx, dir string, recvTypes string, funcName string, withTests bool) (model.FuncKey, *model.DeclInfo) {
+	packageInfo := GetPackageInfo(taskCtx, dir)
+	funcKeys := GetFuncKeySpellings(recvTypes, funcName)
+	for _, funcKey := range funcKeys {
+		if declInfo := packageInfo.FuncMap[funcKey]; declInfo != nil {
+			return funcKey, declInfo
+		}
+	}
+	if !withTests {
+		return model.FuncKey{}, nil
+	}
+	currentFileInfo := GetFileInfo(taskCtx)
+	for _, source := range packageInfo.TestFiles {
+		fileInfo := GetFileInfoBySource(taskCtx, source)
+		if fileInfo == nil || currentFileInfo == nil || fileInfo.Package != currentFileInfo.Package {
+			continue
+		}
+		for _, funcKey := range funcKeys {
+			if funcNode := fileInfo.FuncMap[funcKey]; funcNode != nil {
+				return funcKey, &model.DeclInfo{Source: source, NodeInfo: funcNode}
+			}
+		}
+	}
+	return model.FuncKey{}, nil
+}
+
+// GetFuncKeySpellings returns the FuncKeys a function may be declared with, the spelling given first.
+func GetFuncKeySpellings(recvTypes string, funcName string) []model.FuncKey {
+	recvTypes = util.NormalizeRecvTypes(recvTypes)
+	funcKeys := []model.FuncKey{{RecvTypes: recvTypes, Name: funcName}}
+	if recvTypes == "" || strings.Contains(recvTypes, ",") {
+		return funcKeys
+	}
+	if otherRecvTypes, ok := strings.CutPrefix(recvTypes, "*"); ok {
+		return append(funcKeys, model.FuncKey{RecvTypes: otherRecvTypes, Name: funcName})
+	}
+	return append(funcKeys, model.FuncKey{RecvTypes: "*" + recvTypes, Name: funcName})
+}
+
+// GetFuncNotFoundError returns the error of a function funcKey not declared in source, wrapping ErrFuncNotFound,
+// telling where the symbol index of the package declares it, when it does.
+func GetFuncNotFoundError(taskCtx *model.TaskCtx, source string, funcKey model.FuncKey) error {
+	err := fmt.Errorf("%w: %s in %s", model.ErrFuncNotFound, GetFuncKeyString(funcKey), source)
+	declKey, declInfo := LookupFuncDecl(taskCtx, filepath.Dir(source), funcKey.RecvTypes, funcKey.Name, false)
+	if declInfo == nil {
+		return err
+	}
+	log.Printf("GetFuncNotFoundError Declared, source:%s, funcKey:%+v, declSource:%s, declKey:%+v", source, funcKey, declInfo.Source, declKey)
+	return fmt.Errorf("%w, declared as %s in %s", err, GetFuncKeyString(declKey), declInfo.Source)
+}
+
+// GetFuncKeyString returns funcKey as written in the errors, e.g. "(*Service).Handle" or "Handle".
+func GetFuncKeyString(funcKey model.FuncKey) string {
+	if funcKey.RecvTypes == "" {
+		return funcKey.Name
+	}
+	return fmt.Sprintf("(%s).%s", funcKey.RecvTypes, funcKey.Name)
+}
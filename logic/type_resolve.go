@@ -0,0 +1,730 @@
+package logic
+
+import (
+	"go/ast"
+	"go/build"
+	"go/token"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+const maxTypeResolveDepth = 10
+
+// GetMethodRecvTypes returns the package dir and the FuncMap RecvTypes of the method
+// funcName of exprType, looking into embedded fields for promoted methods.
+func GetMethodRecvTypes(taskCtx *model.TaskCtx, exprType *model.ExprType, funcName string) (string, string) {
+	return GetMethodRecvTypesSub(taskCtx, exprType, funcName, 0)
+}
+
+func GetMethodRecvTypesSub(taskCtx *model.TaskCtx, exprType *model.ExprType, funcName string, depth int) (string, string) {
+	if depth > maxTypeResolveDepth {
+		return "", ""
+	}
+	dir, typeName := GetNamedType(taskCtx, exprType)
+	if typeName == "" {
+		return "", ""
+	}
+	for _, recvTypes := range []string{"*" + typeName, typeName} {
+		if GetFuncDeclInPackage(taskCtx, dir, recvTypes, funcName) != nil {
+			return dir, recvTypes
+		}
+	}
+	structType := GetUnderlyingType(taskCtx, exprType)
+	if structType == nil {
+		return "", ""
+	}
+	for _, embeddedType := range GetEmbeddedFieldTypes(structType) {
+		dir, recvTypes := GetMethodRecvTypesSub(taskCtx, embeddedType, funcName, depth+1)
+		if recvTypes != "" {
+			return dir, recvTypes
+		}
+	}
+	return "", ""
+}
+
+// GetExprType returns the static type of an expression of the current function,
+// only using the declarations before pos.
+func GetExprType(taskCtx *model.TaskCtx, expr ast.Expr, pos token.Pos) *model.ExprType {
+	source := taskCtx.Input.FuncTask.Source
+	dir := filepath.Dir(source)
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return GetIdentType(taskCtx, x.Name, pos)
+	case *ast.SelectorExpr:
+		if ident, ok := x.X.(*ast.Ident); ok && GetIdentType(taskCtx, ident.Name, pos) == nil {
+			importDir := GetImportDirByName(taskCtx, source, ident.Name)
+			if importDir != "" {
+				return GetPackageVarType(taskCtx, importDir, x.Sel.Name)
+			}
+		}
+		xType := GetExprType(taskCtx, x.X, pos)
+		if xType == nil {
+			return nil
+		}
+		return GetFieldType(taskCtx, xType, x.Sel.Name)
+	case *ast.ParenExpr:
+		return GetExprType(taskCtx, x.X, pos)
+	case *ast.StarExpr:
+		xType := GetExprType(taskCtx, x.X, pos)
+		if xType == nil {
+			return nil
+		}
+		if starExpr, ok := xType.Expr.(*ast.StarExpr); ok {
+			return &model.ExprType{Dir: xType.Dir, Source: xType.Source, Expr: starExpr.X}
+		}
+		return nil
+	case *ast.UnaryExpr:
+		if x.Op != token.AND {
+			return nil
+		}
+		xType := GetExprType(taskCtx, x.X, pos)
+		if xType == nil {
+			return nil
+		}
+		return &model.ExprType{Dir: xType.Dir, Source: xType.Source, Expr: &ast.StarExpr{X: xType.Expr}}
+	case *ast.CompositeLit:
+		if x.Type == nil {
+			return nil
+		}
+		return &model.ExprType{Dir: dir, Source: source, Expr: x.Type}
+	case *ast.TypeAssertExpr:
+		if x.Type == nil {
+			return nil
+		}
+		return &model.ExprType{Dir: dir, Source: source, Expr: x.Type}
+	case *ast.CallExpr:
+		return GetCallResultType(taskCtx, x, 0, pos)
+	case *ast.IndexExpr:
+		xType := GetExprType(taskCtx, x.X, pos)
+		if xType == nil {
+			return nil
+		}
+		return GetElemType(taskCtx, xType, false)
+	}
+	return nil
+}
+
+// GetIdentType looks for the declaration of name in the current function before pos,
+// then in its receiver and parameters, then in the package-level vars.
+func GetIdentType(taskCtx *model.TaskCtx, name string, pos token.Pos) *model.ExprType {
+	source := taskCtx.Input.FuncTask.Source
+	dir := filepath.Dir(source)
+	result := GetFuncTaskResult(taskCtx)
+	if result.FuncNodeInfo == nil {
+		return nil
+	}
+	funcDecl, ok := result.FuncNodeInfo.Node.(*ast.FuncDecl)
+	if !ok {
+		return nil
+	}
+
+	// the last declaration before pos whose scope holds pos wins, see GetLocalNames
+	localNames := GetLocalNames(funcDecl)
+	var declNode ast.Node
+	declIdx := 0
+	setDecl := func(node ast.Node, idx int, scopePos token.Pos) {
+		if IsInLocalScope(localNames, name, scopePos, pos) {
+			declNode, declIdx = node, idx
+		}
+	}
+	if funcDecl.Body != nil {
+		ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+			if n == nil || n.Pos() >= pos {
+				return false
+			}
+			switch x := n.(type) {
+			case *ast.AssignStmt:
+				if x.Tok != token.DEFINE {
+					return true
+				}
+				for idx, lhs := range x.Lhs {
+					if ident, ok := lhs.(*ast.Ident); ok && ident.Name == name {
+						setDecl(x, idx, x.End())
+					}
+				}
+			case *ast.ValueSpec:
+				for idx, ident := range x.Names {
+					if ident.Name == name {
+						setDecl(x, idx, x.End())
+					}
+				}
+			case *ast.RangeStmt:
+				if x.Tok != token.DEFINE {
+					return true
+				}
+				if ident, ok := x.Key.(*ast.Ident); ok && ident.Name == name {
+					setDecl(x, 0, x.Body.Pos())
+				}
+				if ident, ok := x.Value.(*ast.Ident); ok && ident.Name == name {
+					setDecl(x, 1, x.Body.Pos())
+				}
+			case *ast.FuncLit:
+				for _, field := range x.Type.Params.List {
+					for _, ident := range field.Names {
+						if ident.Name == name {
+							setDecl(field, 0, x.Pos())
+						}
+					}
+				}
+			}
+			return true
+		})
+	}
+
+	switch x := declNode.(type) {
+	case *ast.AssignStmt:
+		if len(x.Lhs) == len(x.Rhs) {
+			return GetExprType(taskCtx, x.Rhs[declIdx], x.Pos())
+		}
+		if call, ok := x.Rhs[0].(*ast.CallExpr); ok && len(x.Rhs) == 1 {
+			return GetCallResultType(taskCtx, call, declIdx, x.Pos())
+		}
+		return nil
+	case *ast.ValueSpec:
+		if x.Type != nil {
+			return &model.ExprType{Dir: dir, Source: source, Expr: x.Type}
+		}
+		if len(x.Names) == len(x.Values) {
+			return GetExprType(taskCtx, x.Values[declIdx], x.Pos())
+		}
+		if len(x.Values) == 1 {
+			if call, ok := x.Values[0].(*ast.CallExpr); ok {
+				return GetCallResultType(taskCtx, call, declIdx, x.Pos())
+			}
+		}
+		return nil
+	case *ast.RangeStmt:
+		xType := GetExprType(taskCtx, x.X, x.Pos())
+		if xType == nil {
+			return nil
+		}
+		return GetElemType(taskCtx, xType, declIdx == 0)
+	case *ast.Field:
+		return &model.ExprType{Dir: dir, Source: source, Expr: x.Type}
+	}
+
+	fieldLists := []*ast.FieldList{funcDecl.Recv, funcDecl.Type.Params, funcDecl.Type.Results}
+	for _, fieldList := range fieldLists {
+		if fieldList == nil {
+			continue
+		}
+		for _, field := range fieldList.List {
+			for _, ident := range field.Names {
+				if ident.Name == name {
+					return &model.ExprType{Dir: dir, Source: source, Expr: field.Type}
+				}
+			}
+		}
+	}
+
+	return GetPackageVarType(taskCtx, dir, name)
+}
+
+// GetCallResultType returns the type of the idx-th result of a call to a function
+// or method declared in the loaded packages.
+func GetCallResultType(taskCtx *model.TaskCtx, call *ast.CallExpr, idx int, pos token.Pos) *model.ExprType {
+	source := taskCtx.Input.FuncTask.Source
+	dir := filepath.Dir(source)
+	var funcDeclInfo *model.DeclInfo
+	switch fun := util.StripTypeArgs(call.Fun).(type) {
+	case *ast.Ident:
+		if fun.Name == "new" && len(call.Args) == 1 {
+			return &model.ExprType{Dir: dir, Source: source, Expr: &ast.StarExpr{X: call.Args[0]}}
+		}
+		funcDeclInfo = GetFuncDeclInPackage(taskCtx, dir, "", fun.Name)
+	case *ast.SelectorExpr:
+		if ident, ok := fun.X.(*ast.Ident); ok && GetIdentType(taskCtx, ident.Name, pos) == nil {
+			importDir := GetImportDirByName(taskCtx, source, ident.Name)
+			if importDir != "" {
+				funcDeclInfo = GetFuncDeclInPackage(taskCtx, importDir, "", fun.Sel.Name)
+				break
+			}
+		}
+		xType := GetExprType(taskCtx, fun.X, pos)
+		if xType == nil {
+			return nil
+		}
+		methodDir, recvTypes := GetMethodRecvTypes(taskCtx, xType, fun.Sel.Name)
+		if recvTypes == "" {
+			return nil
+		}
+		funcDeclInfo = GetFuncDeclInPackage(taskCtx, methodDir, recvTypes, fun.Sel.Name)
+	}
+	if funcDeclInfo == nil {
+		return nil
+	}
+	funcDecl := funcDeclInfo.NodeInfo.Node.(*ast.FuncDecl)
+	if funcDecl.Type.Results == nil {
+		return nil
+	}
+	resultIdx := 0
+	for _, field := range funcDecl.Type.Results.List {
+		nameCount := max(len(field.Names), 1)
+		if idx < resultIdx+nameCount {
+			return &model.ExprType{Dir: filepath.Dir(funcDeclInfo.Source), Source: funcDeclInfo.Source, Expr: field.Type}
+		}
+		resultIdx += nameCount
+	}
+	return nil
+}
+
+// GetFieldType returns the type of the field fieldName of exprType, including promoted fields.
+func GetFieldType(taskCtx *model.TaskCtx, exprType *model.ExprType, fieldName string) *model.ExprType {
+	return GetFieldTypeSub(taskCtx, exprType, fieldName, 0)
+}
+
+func GetFieldTypeSub(taskCtx *model.TaskCtx, exprType *model.ExprType, fieldName string, depth int) *model.ExprType {
+	if depth > maxTypeResolveDepth {
+		return nil
+	}
+	structType := GetUnderlyingType(taskCtx, exprType)
+	if structType == nil {
+		return nil
+	}
+	x, ok := structType.Expr.(*ast.StructType)
+	if !ok {
+		return nil
+	}
+	for _, field := range x.Fields.List {
+		for _, ident := range field.Names {
+			if ident.Name == fieldName {
+				return &model.ExprType{Dir: structType.Dir, Source: structType.Source, Expr: field.Type}
+			}
+		}
+	}
+	for _, embeddedType := range GetEmbeddedFieldTypes(structType) {
+		if _, typeName := GetNamedType(taskCtx, embeddedType); typeName == fieldName {
+			return embeddedType
+		}
+		if fieldType := GetFieldTypeSub(taskCtx, embeddedType, fieldName, depth+1); fieldType != nil {
+			return fieldType
+		}
+	}
+	return nil
+}
+
+// GetEmbeddedFieldTypes returns the types of the embedded fields of a struct type.
+func GetEmbeddedFieldTypes(structType *model.ExprType) []*model.ExprType {
+	x, ok := structType.Expr.(*ast.StructType)
+	if !ok {
+		return nil
+	}
+	var embeddedTypes []*model.ExprType
+	for _, field := range x.Fields.List {
+		if len(field.Names) == 0 {
+			embeddedTypes = append(embeddedTypes, &model.ExprType{Dir: structType.Dir, Source: structType.Source, Expr: field.Type})
+		}
+	}
+	return embeddedTypes
+}
+
+// GetFieldOwnerType returns the package dir and the name of the named type declaring the field fieldName of exprType,
+// exprType itself or a type embedded in it, or empty strings when it has no such field.
+func GetFieldOwnerType(taskCtx *model.TaskCtx, exprType *model.ExprType, fieldName string) (string, string) {
+	return GetFieldOwnerTypeSub(taskCtx, exprType, fieldName, 0)
+}
+
+func GetFieldOwnerTypeSub(taskCtx *model.TaskCtx, exprType *model.ExprType, fieldName string, depth int) (string, string) {
+	if depth > maxTypeResolveDepth {
+		return "", ""
+	}
+	structType := GetUnderlyingType(taskCtx, exprType)
+	if structType == nil {
+		return "", ""
+	}
+	x, ok := structType.Expr.(*ast.StructType)
+	if !ok {
+		return "", ""
+	}
+	for _, field := range x.Fields.List {
+		for _, ident := range field.Names {
+			if ident.Name == fieldName {
+				return GetNamedType(taskCtx, exprType)
+			}
+		}
+	}
+	for _, embeddedType := range GetEmbeddedFieldTypes(structType) {
+		if dir, typeName := GetFieldOwnerTypeSub(taskCtx, embeddedType, fieldName, depth+1); typeName != "" {
+			return dir, typeName
+		}
+	}
+	return "", ""
+}
+
+// GetElemType returns the element type, or the key type if isKey, of a slice, array, map or channel.
+func GetElemType(taskCtx *model.TaskCtx, exprType *model.ExprType, isKey bool) *model.ExprType {
+	underlying := GetUnderlyingType(taskCtx, exprType)
+	if underlying == nil {
+		return nil
+	}
+	var elem ast.Expr
+	switch x := underlying.Expr.(type) {
+	case *ast.ArrayType:
+		if !isKey {
+			elem = x.Elt
+		}
+	case *ast.MapType:
+		elem = x.Value
+		if isKey {
+			elem = x.Key
+		}
+	case *ast.ChanType:
+		if isKey {
+			elem = x.Value
+		}
+	}
+	if elem == nil {
+		return nil
+	}
+	return &model.ExprType{Dir: underlying.Dir, Source: underlying.Source, Expr: elem}
+}
+
+// GetUnderlyingType follows pointers and named types until it reaches a type literal.
+func GetUnderlyingType(taskCtx *model.TaskCtx, exprType *model.ExprType) *model.ExprType {
+	for i := 0; i < maxTypeResolveDepth; i++ {
+		switch x := exprType.Expr.(type) {
+		case *ast.StarExpr:
+			exprType = &model.ExprType{Dir: exprType.Dir, Source: exprType.Source, Expr: x.X}
+			continue
+		case *ast.ParenExpr:
+			exprType = &model.ExprType{Dir: exprType.Dir, Source: exprType.Source, Expr: x.X}
+			continue
+		}
+		dir, typeName := GetNamedType(taskCtx, exprType)
+		if typeName == "" {
+			return exprType
+		}
+		typeDecl := GetTypeDecl(taskCtx, dir, typeName)
+		if typeDecl == nil {
+			return nil
+		}
+		typeSpec := typeDecl.NodeInfo.Node.(*ast.TypeSpec)
+		exprType = &model.ExprType{Dir: dir, Source: typeDecl.Source, Expr: typeSpec.Type}
+	}
+	return nil
+}
+
+// GetNamedType returns the package dir and the name of the named type of exprType,
+// ignoring pointers and type arguments and following aliases.
+// It returns empty strings for type literals and for types not declared in the loaded packages.
+func GetNamedType(taskCtx *model.TaskCtx, exprType *model.ExprType) (string, string) {
+	for i := 0; i < maxTypeResolveDepth; i++ {
+		dir, typeName := "", ""
+		switch x := util.StripTypeArgs(exprType.Expr).(type) {
+		case *ast.StarExpr:
+			exprType = &model.ExprType{Dir: exprType.Dir, Source: exprType.Source, Expr: x.X}
+			continue
+		case *ast.ParenExpr:
+			exprType = &model.ExprType{Dir: exprType.Dir, Source: exprType.Source, Expr: x.X}
+			continue
+		case *ast.Ident:
+			dir, typeName = exprType.Dir, x.Name
+		case *ast.SelectorExpr:
+			ident, ok := x.X.(*ast.Ident)
+			if !ok {
+				return "", ""
+			}
+			dir, typeName = GetImportDirByName(taskCtx, exprType.Source, ident.Name), x.Sel.Name
+		default:
+			return "", ""
+		}
+		if dir == "" {
+			return "", ""
+		}
+		typeDecl := GetTypeDecl(taskCtx, dir, typeName)
+		if typeDecl == nil {
+			return "", ""
+		}
+		typeSpec := typeDecl.NodeInfo.Node.(*ast.TypeSpec)
+		if !typeSpec.Assign.IsValid() {
+			return dir, typeName
+		}
+		exprType = &model.ExprType{Dir: dir, Source: typeDecl.Source, Expr: typeSpec.Type}
+	}
+	return "", ""
+}
+
+// GetPackageVarType returns the declared type of a package-level var.
+func GetPackageVarType(taskCtx *model.TaskCtx, dir string, name string) *model.ExprType {
+	varDecl := GetVarDecl(taskCtx, dir, name)
+	if varDecl == nil {
+		return nil
+	}
+	valueSpec := varDecl.NodeInfo.Node.(*ast.ValueSpec)
+	if valueSpec.Type != nil {
+		return &model.ExprType{Dir: dir, Source: varDecl.Source, Expr: valueSpec.Type}
+	}
+	for idx, ident := range valueSpec.Names {
+		if ident.Name != name || idx >= len(valueSpec.Values) {
+			continue
+		}
+		// only literals, other values would need the file of the var as the current file
+		value := valueSpec.Values[idx]
+		isPointer := false
+		if unaryExpr, ok := value.(*ast.UnaryExpr); ok && unaryExpr.Op == token.AND {
+			value, isPointer = unaryExpr.X, true
+		}
+		compositeLit, ok := value.(*ast.CompositeLit)
+		if !ok || compositeLit.Type == nil {
+			return nil
+		}
+		typeExpr := compositeLit.Type
+		if isPointer {
+			typeExpr = &ast.StarExpr{X: typeExpr}
+		}
+		return &model.ExprType{Dir: dir, Source: varDecl.Source, Expr: typeExpr}
+	}
+	return nil
+}
+
+// GetImportDirByName returns the dir of the package imported as name in source, or "".
+func GetImportDirByName(taskCtx *model.TaskCtx, source string, name string) string {
+	importPath := GetImportMap(taskCtx, source)[name]
+	if importPath == "" {
+		return ""
+	}
+	dir, err := util.GetImportDir(filepath.Dir(source), importPath)
+	if err != nil {
+		AddImportError(taskCtx, importPath, err)
+		return ""
+	}
+	return dir
+}
+
+// GetImportMap returns the imports of source by name, from its FileSummary when it is not parsed.
+func GetImportMap(taskCtx *model.TaskCtx, source string) map[string]string {
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		absSource = source
+	}
+	if fileSummary := taskCtx.FileSummaryMap[absSource]; fileSummary != nil && taskCtx.FileInfoMap[absSource] == nil {
+		if fileSummary.Imports == nil {
+			return nil
+		}
+		importMap := maps.Clone(fileSummary.Imports)
+		AddExtraImports(taskCtx, importMap)
+		return importMap
+	}
+	fileInfo := GetFileInfoBySource(taskCtx, source)
+	if fileInfo == nil {
+		return nil
+	}
+	return fileInfo.ImportMap
+}
+
+// GetFuncDeclInPackage returns the declaration of a function or method in the package in dir.
+func GetFuncDeclInPackage(taskCtx *model.TaskCtx, dir string, recvTypes string, funcName string) *model.DeclInfo {
+	return ResolveDeclInfo(taskCtx, GetPackageInfo(taskCtx, dir).FuncMap[model.FuncKey{RecvTypes: recvTypes, Name: funcName}])
+}
+
+// GetTypeDecl returns the declaration of a type in the package in dir.
+func GetTypeDecl(taskCtx *model.TaskCtx, dir string, typeName string) *model.DeclInfo {
+	return ResolveDeclInfo(taskCtx, GetPackageInfo(taskCtx, dir).TypeMap[typeName])
+}
+
+// GetVarDecl returns the declaration of a package-level var in the package in dir.
+func GetVarDecl(taskCtx *model.TaskCtx, dir string, name string) *model.DeclInfo {
+	return ResolveDeclInfo(taskCtx, GetPackageInfo(taskCtx, dir).VarMap[name])
+}
+
+// ResolveDeclInfo parses the file of a declaration indexed from a FileSummary, and sets its NodeInfo,
+// found by its position. It returns nil when declInfo is nil or the declaration is not found.
+func ResolveDeclInfo(taskCtx *model.TaskCtx, declInfo *model.DeclInfo) *model.DeclInfo {
+	if declInfo == nil || declInfo.NodeInfo != nil {
+		return declInfo
+	}
+	fileInfo := GetFileInfoBySource(taskCtx, declInfo.Source)
+	if fileInfo == nil {
+		return nil
+	}
+	isDecl := func(nodeInfo *model.NodeInfo) bool {
+		position := taskCtx.FileSet.Position(nodeInfo.Node.Pos())
+		return position.Line == declInfo.Line && position.Column == declInfo.Column
+	}
+	for _, funcNode := range fileInfo.FuncMap {
+		if isDecl(funcNode) {
+			declInfo.NodeInfo = funcNode
+			return declInfo
+		}
+	}
+	ForEachGenDeclSpec(fileInfo, func(tok string, name string, spec *model.NodeInfo) {
+		if declInfo.NodeInfo == nil && isDecl(spec) {
+			declInfo.NodeInfo = spec
+		}
+	})
+	if declInfo.NodeInfo == nil {
+		log.Printf("ResolveDeclInfo NotFound, source:%s, line:%d, column:%d", declInfo.Source, declInfo.Line, declInfo.Column)
+		return nil
+	}
+	return declInfo
+}
+
+// ForEachGenDeclSpec calls f with each type of fileInfo, and each var with the spec declaring it.
+func ForEachGenDeclSpec(fileInfo *model.FileInfo, f func(tok string, name string, spec *model.NodeInfo)) {
+	for _, decl := range fileInfo.NodeInfo.NodeListFields["Decls"] {
+		if decl.Type != "*ast.GenDecl" {
+			continue
+		}
+		for _, spec := range decl.NodeListFields["Specs"] {
+			switch decl.TokenFields["Tok"] {
+			case "type":
+				f("type", spec.NodeFields["Name"].StringFields["Name"], spec)
+			case "var":
+				for _, name := range spec.NodeListFields["Names"] {
+					f("var", name.StringFields["Name"], spec)
+				}
+			}
+		}
+	}
+}
+
+// GetPackageInfo indexes the types, vars and functions of the go files of the package in dir, once per run.
+// With a disk cache, the files not changed since their FileSummary was written are not parsed,
+// their declarations are parsed when looked up, see ResolveDeclInfo.
+func GetPackageInfo(taskCtx *model.TaskCtx, dir string) *model.PackageInfo {
+	dir = filepath.Clean(dir)
+	if taskCtx.PackageInfoMap == nil {
+		taskCtx.PackageInfoMap = make(map[string]*model.PackageInfo)
+	}
+	if packageInfo := taskCtx.PackageInfoMap[dir]; packageInfo != nil {
+		return packageInfo
+	}
+	packageInfo := &model.PackageInfo{
+		Dir:     dir,
+		TypeMap: make(map[string]*model.DeclInfo),
+		VarMap:  make(map[string]*model.DeclInfo),
+		FuncMap: make(map[model.FuncKey]*model.DeclInfo),
+	}
+	taskCtx.PackageInfoMap[dir] = packageInfo
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("GetPackageInfo ReadDirErr, dir:%s, err:%+v", dir, err)
+		return packageInfo
+	}
+	summaryCount := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		if match, err := build.Default.MatchFile(dir, name); err != nil || !match {
+			continue
+		}
+		source := filepath.Join(dir, name)
+		if strings.HasSuffix(name, "_test.go") {
+			packageInfo.TestFiles = append(packageInfo.TestFiles, source)
+			continue
+		}
+		absSource, err := filepath.Abs(source)
+		if err != nil {
+			absSource = source
+		}
+		if taskCtx.FileInfoMap[absSource] == nil {
+			if fileSummary := LoadFileSummary(taskCtx, absSource); fileSummary != nil {
+				AddFileSummary(packageInfo, source, fileSummary)
+				summaryCount++
+				continue
+			}
+		}
+		fileInfo := GetFileInfoBySource(taskCtx, source)
+		if fileInfo == nil {
+			continue
+		}
+		packageInfo.Name = fileInfo.Package
+		packageInfo.Files = append(packageInfo.Files, source)
+		for funcKey, funcNode := range fileInfo.FuncMap {
+			packageInfo.FuncMap[funcKey] = GetDeclInfo(taskCtx, source, funcNode)
+		}
+		ForEachGenDeclSpec(fileInfo, func(tok string, name string, spec *model.NodeInfo) {
+			switch tok {
+			case "type":
+				packageInfo.TypeMap[name] = GetDeclInfo(taskCtx, source, spec)
+			case "var":
+				packageInfo.VarMap[name] = GetDeclInfo(taskCtx, source, spec)
+			}
+		})
+	}
+	log.Printf("GetPackageInfo dir:%s, name:%s, files:%+v, summaryCount:%d", dir, packageInfo.Name, packageInfo.Files, summaryCount)
+	return packageInfo
+}
+
+// GetDeclInfo returns the DeclInfo of a declaration of a parsed file.
+func GetDeclInfo(taskCtx *model.TaskCtx, source string, nodeInfo *model.NodeInfo) *model.DeclInfo {
+	position := taskCtx.FileSet.Position(nodeInfo.Node.Pos())
+	return &model.DeclInfo{Source: source, NodeInfo: nodeInfo, Line: position.Line, Column: position.Column, TypeKind: GetTypeKind(nodeInfo)}
+}
+
+// GetTypeKind returns the TypeKind of a *ast.TypeSpec, and the zero TypeKind of any other node.
+func GetTypeKind(nodeInfo *model.NodeInfo) model.TypeKind {
+	typeSpec, ok := nodeInfo.Node.(*ast.TypeSpec)
+	if !ok {
+		return model.TypeKind{}
+	}
+	if typeSpec.Assign.IsValid() {
+		return model.TypeKind{Embeds: true}
+	}
+	switch typeExpr := typeSpec.Type.(type) {
+	case *ast.InterfaceType:
+		for _, field := range typeExpr.Methods.List {
+			if len(field.Names) == 0 {
+				return model.TypeKind{IsInterface: true, Embeds: true}
+			}
+		}
+		return model.TypeKind{IsInterface: true}
+	case *ast.StructType:
+		for _, field := range typeExpr.Fields.List {
+			if len(field.Names) == 0 {
+				return model.TypeKind{Embeds: true}
+			}
+		}
+		return model.TypeKind{}
+	case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.IndexListExpr, *ast.ParenExpr:
+		// the underlying type may be an interface, or a struct with embedded fields
+		return model.TypeKind{Embeds: true}
+	}
+	return model.TypeKind{}
+}
+
+// HasMethodNames reports whether the package has a method of typeName or *typeName for each of methodNames.
+func HasMethodNames(packageInfo *model.PackageInfo, typeName string, methodNames []string) bool {
+	for _, methodName := range methodNames {
+		if packageInfo.FuncMap[model.FuncKey{RecvTypes: typeName, Name: methodName}] == nil &&
+			packageInfo.FuncMap[model.FuncKey{RecvTypes: "*" + typeName, Name: methodName}] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// AddFileSummary indexes the declarations of fileSummary in packageInfo, to parse when looked up.
+func AddFileSummary(packageInfo *model.PackageInfo, source string, fileSummary *model.FileSummary) {
+	packageInfo.Name = fileSummary.Package
+	packageInfo.Files = append(packageInfo.Files, source)
+	getDeclInfo := func(symbolSummary model.SymbolSummary) *model.DeclInfo {
+		return &model.DeclInfo{Source: source, Line: symbolSummary.Line, Column: symbolSummary.Column, TypeKind: symbolSummary.TypeKind}
+	}
+	for _, symbolSummary := range fileSummary.Funcs {
+		packageInfo.FuncMap[model.FuncKey{RecvTypes: symbolSummary.RecvTypes, Name: symbolSummary.Name}] = getDeclInfo(symbolSummary)
+	}
+	for _, symbolSummary := range fileSummary.Types {
+		packageInfo.TypeMap[symbolSummary.Name] = getDeclInfo(symbolSummary)
+	}
+	for _, symbolSummary := range fileSummary.Vars {
+		packageInfo.VarMap[symbolSummary.Name] = getDeclInfo(symbolSummary)
+	}
+}
+
+func GetExprTypeString(taskCtx *model.TaskCtx, exprType *model.ExprType) string {
+	typeString, err := util.FprintToString(taskCtx.FileSet, exprType.Expr)
+	if err != nil {
+		return ""
+	}
+	return typeString
+}
@@ -0,0 +1,348 @@
+package logic
+
+import (
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// SourceImporter type-checks the packages imported from Dir from source, finding them with
+// util.GetImportDir. The standard library is delegated to the go/importer source importer.
+type SourceImporter struct {
+	TaskCtx *model.TaskCtx
+	Dir     string
+}
+
+func (i *SourceImporter) Import(path string) (*types.Package, error) {
+	return i.ImportFrom(path, i.Dir, 0)
+}
+
+func (i *SourceImporter) ImportFrom(path string, fromDir string, mode types.ImportMode) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	if fromDir == "" {
+		fromDir = i.Dir
+	}
+	dir, err := util.GetImportDir(fromDir, path)
+	if err != nil || !util.IsDir(dir) {
+		if i.TaskCtx.StdImporter == nil {
+			i.TaskCtx.StdImporter = importer.ForCompiler(i.TaskCtx.FileSet, "source", nil)
+		}
+		return i.TaskCtx.StdImporter.Import(path)
+	}
+	typesPackage := GetTypesPackage(i.TaskCtx, dir)
+	if typesPackage.Loading {
+		return nil, fmt.Errorf("import cycle, path:%s, dir:%s", path, dir)
+	}
+	if typesPackage.Pkg == nil {
+		return nil, fmt.Errorf("type check %s failed, dir:%s, errors:%+v", path, dir, typesPackage.Errors)
+	}
+	return typesPackage.Pkg, nil
+}
+
+// LockedImporter is an Importer shared by the tasks analyzed at the same time, importing one package at a time.
+type LockedImporter struct {
+	mu       sync.Mutex
+	Importer types.Importer
+}
+
+func (i *LockedImporter) Import(path string) (*types.Package, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.Importer.Import(path)
+}
+
+// GetTypesPackage type-checks the package in dir, reusing the ast of FileInfoMap so that
+// the nodes of NodeInfo can be looked up in types.Info. Errors are collected, not fatal.
+func GetTypesPackage(taskCtx *model.TaskCtx, dir string) *model.TypesPackage {
+	dir = filepath.Clean(dir)
+	if taskCtx.TypesPackageMap == nil {
+		taskCtx.TypesPackageMap = make(map[string]*model.TypesPackage)
+	}
+	if typesPackage := taskCtx.TypesPackageMap[dir]; typesPackage != nil {
+		return typesPackage
+	}
+	typesPackage := &model.TypesPackage{
+		Dir:     dir,
+		Path:    util.GetDirImportPath(dir),
+		Loading: true,
+		Info: &types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+			Instances:  make(map[*ast.Ident]types.Instance),
+			Scopes:     make(map[ast.Node]*types.Scope),
+		},
+	}
+	taskCtx.TypesPackageMap[dir] = typesPackage
+
+	packageInfo := GetPackageInfo(taskCtx, dir)
+	files := make([]*ast.File, 0, len(packageInfo.Files))
+	for _, source := range packageInfo.Files {
+		fileInfo := GetFileInfoBySource(taskCtx, source)
+		if fileInfo == nil {
+			continue
+		}
+		if file, ok := fileInfo.NodeInfo.Node.(*ast.File); ok {
+			files = append(files, file)
+		}
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		absDir = dir
+	}
+	conf := types.Config{
+		Importer:    &SourceImporter{TaskCtx: taskCtx, Dir: absDir},
+		FakeImportC: true,
+		Error: func(err error) {
+			typesPackage.Errors = append(typesPackage.Errors, err)
+		},
+	}
+	pkg, err := conf.Check(typesPackage.Path, taskCtx.FileSet, files, typesPackage.Info)
+	if err != nil {
+		log.Printf("GetTypesPackage CheckErr, dir:%s, errorCount:%d, firstErr:%+v", dir, len(typesPackage.Errors), err)
+	}
+	typesPackage.Pkg = pkg
+	typesPackage.Loading = false
+	return typesPackage
+}
+
+// GetIdentObject returns the object an identifier of the current file defines or uses,
+// or nil when type checking is off or has no answer for it.
+func GetIdentObject(taskCtx *model.TaskCtx, ident *ast.Ident) types.Object {
+	if !taskCtx.Input.FuncTask.TypeCheck || ident == nil {
+		return nil
+	}
+	typesPackage := GetTypesPackage(taskCtx, filepath.Dir(taskCtx.Input.FuncTask.Source))
+	if typesPackage.Info == nil {
+		return nil
+	}
+	if obj := typesPackage.Info.Defs[ident]; obj != nil {
+		return obj
+	}
+	return typesPackage.Info.Uses[ident]
+}
+
+// IsTargetObject is the type-checked part of IsTargetVariable, for the leftmost identifier
+// of a name. It returns false for identifiers which cannot be the variable the user means:
+// package names, types, labels and builtins, see IsVarNameObject for the variables.
+// ok is false when there is no type information for the identifier.
+func IsTargetObject(taskCtx *model.TaskCtx, ident *ast.Ident) (isTarget bool, ok bool) {
+	obj := GetIdentObject(taskCtx, ident)
+	if obj == nil {
+		return false, false
+	}
+	switch obj.(type) {
+	case *types.PkgName, *types.TypeName, *types.Label, *types.Builtin, *types.Nil:
+		return false, true
+	}
+	return true, true
+}
+
+// IsVarNameObject reports whether the leftmost identifier ident of a name matching varName by name
+// is one of the variables the root of varName is bound to in the current function, see GetVarNameObjects.
+// It is true without type information for either, the names decide then.
+func IsVarNameObject(taskCtx *model.TaskCtx, varName string, ident *ast.Ident) bool {
+	obj := GetIdentObject(taskCtx, ident)
+	if obj == nil {
+		return true
+	}
+	root, _, _ := strings.Cut(varName, ".")
+	varNameObjs := GetVarNameObjects(taskCtx, root)
+	if len(varNameObjs) == 0 {
+		return true
+	}
+	return slices.Contains(varNameObjs, obj)
+}
+
+// GetVarNameObjects returns the variables the root of a VarName names in the current function: a receiver,
+// a parameter or a result, else every local of that name in the body, e.g. the err of each if err := f(),
+// else a package-level var. It returns nil when there is none, or without TypeCheck.
+func GetVarNameObjects(taskCtx *model.TaskCtx, root string) []types.Object {
+	result := GetFuncTaskResult(taskCtx)
+	if result.FuncNodeInfo == nil {
+		return nil
+	}
+	funcDecl, ok := result.FuncNodeInfo.Node.(*ast.FuncDecl)
+	if !ok {
+		return nil
+	}
+	varNameRoot := model.VarNameRoot{FuncDecl: funcDecl, Name: root}
+	if objs, ok := taskCtx.VarNameObjects[varNameRoot]; ok {
+		return objs
+	}
+	objs := ResolveVarNameObjects(taskCtx, funcDecl, root)
+	if taskCtx.VarNameObjects == nil {
+		taskCtx.VarNameObjects = make(map[model.VarNameRoot][]types.Object)
+	}
+	taskCtx.VarNameObjects[varNameRoot] = objs
+	log.Printf("GetVarNameObjects Bound, funcName:%s, root:%s, count:%d", funcDecl.Name.Name, root, len(objs))
+	return objs
+}
+
+// ResolveVarNameObjects is GetVarNameObjects without the cache.
+func ResolveVarNameObjects(taskCtx *model.TaskCtx, funcDecl *ast.FuncDecl, root string) []types.Object {
+	if !taskCtx.Input.FuncTask.TypeCheck {
+		return nil
+	}
+	typesPackage := GetTypesPackage(taskCtx, filepath.Dir(taskCtx.Input.FuncTask.Source))
+	if typesPackage.Info == nil {
+		return nil
+	}
+	funcScope := typesPackage.Info.Scopes[funcDecl.Type]
+	if funcScope == nil {
+		return nil
+	}
+	// the function scope holds the locals of the body too, those are matched below with the shadowing ones
+	if v, ok := funcScope.Lookup(root).(*types.Var); ok && (funcDecl.Body == nil || v.Pos() < funcDecl.Body.Pos()) {
+		return []types.Object{v}
+	}
+	var objs []types.Object
+	if funcDecl.Body != nil {
+		ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+			ident, ok := n.(*ast.Ident)
+			if !ok || ident.Name != root {
+				return true
+			}
+			if v, ok := typesPackage.Info.Defs[ident].(*types.Var); ok && !v.IsField() {
+				objs = append(objs, v)
+			}
+			return true
+		})
+	}
+	if len(objs) > 0 {
+		return objs
+	}
+	if _, outer := funcScope.LookupParent(root, token.NoPos); outer != nil {
+		if v, ok := outer.(*types.Var); ok {
+			return []types.Object{v}
+		}
+	}
+	return nil
+}
+
+// GetLeftmostIdent returns a for a, a.B and a.B.C, and nil for other expressions.
+func GetLeftmostIdent(expr ast.Expr) *ast.Ident {
+	for {
+		switch x := expr.(type) {
+		case *ast.Ident:
+			return x
+		case *ast.SelectorExpr:
+			expr = x.X
+		default:
+			return nil
+		}
+	}
+}
+
+// GetCallIdent returns the name a call expression calls, e.g. f of f(x) or of s.f(x), or nil.
+func GetCallIdent(call *ast.CallExpr) *ast.Ident {
+	switch fun := ast.Unparen(util.StripTypeArgs(call.Fun)).(type) {
+	case *ast.Ident:
+		return fun
+	case *ast.SelectorExpr:
+		return fun.Sel
+	}
+	return nil
+}
+
+// GetCallTargetByTypes returns the function or method a call expression calls, or nil.
+func GetCallTargetByTypes(taskCtx *model.TaskCtx, call *ast.CallExpr) *types.Func {
+	fn, ok := GetIdentObject(taskCtx, GetCallIdent(call)).(*types.Func)
+	if !ok {
+		return nil
+	}
+	return fn.Origin()
+}
+
+// IsFuncVarCallByTypes reports whether a call expression calls a variable or a field of func type,
+// e.g. a local closure f := func() {...}, not a declared function.
+func IsFuncVarCallByTypes(taskCtx *model.TaskCtx, call *ast.CallExpr) bool {
+	_, ok := GetIdentObject(taskCtx, GetCallIdent(call)).(*types.Var)
+	return ok
+}
+
+// FilterRelevantCallExprByTypes checks the exact called function given by type checking,
+// or every implementation of a called interface method.
+// It returns false when type checking does not know the called function,
+// so that the syntactic resolution can be tried.
+func FilterRelevantCallExprByTypes(taskCtx *model.TaskCtx, nodeInfo *model.NodeInfo) bool {
+	call, ok := nodeInfo.Node.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	if IsFuncVarCallByTypes(taskCtx, call) {
+		// a closure or a func value has no declaration to slice
+		return true
+	}
+	fn := GetCallTargetByTypes(taskCtx, call)
+	if fn == nil || fn.Pkg() == nil {
+		return false
+	}
+	if IsInterfaceMethod(fn) {
+		FilterRelevantCallExprDispatchByTypes(taskCtx, nodeInfo, fn)
+		return true
+	}
+	source, recvTypes := GetFuncDeclSourceByTypes(taskCtx, fn)
+	if source == "" {
+		// the standard library
+		return true
+	}
+	isFunNameRelevant := slices.Contains(taskCtx.Input.FuncTask.VarNames, fn.Name())
+	if !isFunNameRelevant && taskCtx.Input.FuncTask.OnlyRelevantFunc {
+		return true
+	}
+	log.Printf("FilterRelevantCallExprByTypes Target, source:%s, recvTypes:%s, funcName:%s", source, recvTypes, fn.Name())
+	FilterRelevantCallExprFuncFiles(taskCtx, nodeInfo, []string{source}, recvTypes, fn.Name(), isFunNameRelevant, nil)
+	return true
+}
+
+// GetFuncDeclSourceByTypes finds the FuncDecl of fn in the loaded files, and returns its source
+// and its FuncMap RecvTypes. Files of the current package keep the style of the current source.
+func GetFuncDeclSourceByTypes(taskCtx *model.TaskCtx, fn *types.Func) (string, string) {
+	filename := taskCtx.FileSet.Position(fn.Pos()).Filename
+	if filename == "" {
+		return "", ""
+	}
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return "", ""
+	}
+	// the standard library is type-checked without FileInfo
+	fileInfo := taskCtx.FileInfoMap[absFilename]
+	if fileInfo == nil {
+		return "", ""
+	}
+	for funcKey, funcNode := range fileInfo.FuncMap {
+		funcDecl, ok := funcNode.Node.(*ast.FuncDecl)
+		if !ok || funcDecl.Name.Pos() != fn.Pos() {
+			continue
+		}
+		currentDir := filepath.Dir(taskCtx.Input.FuncTask.Source)
+		if absCurrentDir, err := filepath.Abs(currentDir); err == nil && absCurrentDir == filepath.Dir(absFilename) {
+			filename = filepath.Join(currentDir, filepath.Base(filename))
+		}
+		return filename, funcKey.RecvTypes
+	}
+	return "", ""
+}
+
+func IsInterfaceMethod(fn *types.Func) bool {
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok || sig.Recv() == nil {
+		return false
+	}
+	return types.IsInterface(sig.Recv().Type())
+}
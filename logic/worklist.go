@@ -0,0 +1,87 @@
+package logic
+
+import (
+	"log"
+
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// EnqueueFuncTaskResult queues result to run with its FuncTask, unless it is queued already.
+func EnqueueFuncTaskResult(taskCtx *model.TaskCtx, result *model.FuncTaskResult) {
+	if result.Queued {
+		return
+	}
+	result.Queued = true
+	taskCtx.Worklist = append(taskCtx.Worklist, result)
+}
+
+// RunWorklist runs the queued results until none is left. A result is queued again when its VarNames grow,
+// see CheckNeedRunAndMergeVarNames, or when a function it calls becomes relevant.
+// It returns false when Input.MaxIterations runs stop it first, leaving the rest in the worklist.
+func RunWorklist(taskCtx *model.TaskCtx) bool {
+	currentFuncTask := taskCtx.Input.FuncTask
+	defer func() {
+		taskCtx.Input.FuncTask = currentFuncTask
+	}()
+	maxIterations := taskCtx.Input.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = model.DefaultMaxIterations
+	}
+	for len(taskCtx.Worklist) > 0 {
+		if taskCtx.Iterations >= maxIterations {
+			log.Printf("RunWorklist NotConverged, iterations:%d, queued:%d", taskCtx.Iterations, len(taskCtx.Worklist))
+			taskCtx.NotConverged = true
+			return false
+		}
+		result := taskCtx.Worklist[0]
+		taskCtx.Worklist = taskCtx.Worklist[1:]
+		result.Queued = false
+		taskCtx.Iterations++
+		RunFuncTaskResult(taskCtx, result)
+	}
+	return true
+}
+
+// RunFuncTaskResult filters the relevant nodes of result with its FuncTask.
+func RunFuncTaskResult(taskCtx *model.TaskCtx, result *model.FuncTaskResult) {
+	taskCtx.Input.FuncTask = result.FuncTask
+	result.Matches = nil
+	result.FilterRelevantNodeInfo = FilterRelevantFuncNodeInfo(taskCtx, result.FuncNodeInfo)
+	log.Printf("RunFuncTaskResult Done, task:%s, result:%s", util.JsonString(taskCtx.Input.FuncTask), util.JsonString(result.FilterRelevantNodeInfo.RelevantTaskResult))
+	UpdateFuncRelevance(taskCtx, result)
+}
+
+// SetForcedRelevant makes result relevant whatever its statements.
+func SetForcedRelevant(taskCtx *model.TaskCtx, result *model.FuncTaskResult) {
+	result.ForcedRelevant = true
+	UpdateFuncRelevance(taskCtx, result)
+}
+
+// UpdateFuncRelevance sets IsRelevant from the last run of result, and queues its callers again when it changes,
+// their calls of result are relevant only when result is.
+func UpdateFuncRelevance(taskCtx *model.TaskCtx, result *model.FuncTaskResult) {
+	if result.FilterRelevantNodeInfo == nil || result.FilterRelevantNodeInfo.RelevantTaskResult == nil {
+		// not run yet
+		return
+	}
+	if result.ForcedRelevant {
+		result.FilterRelevantNodeInfo.RelevantTaskResult.IsRelevant = true
+	}
+	isRelevant := result.FilterRelevantNodeInfo.RelevantTaskResult.IsRelevant
+	if isRelevant == result.IsRelevant {
+		return
+	}
+	result.IsRelevant = isRelevant
+	EnqueueCallers(taskCtx, result)
+}
+
+// EnqueueCallers queues the callers of result in the order they were found.
+func EnqueueCallers(taskCtx *model.TaskCtx, result *model.FuncTaskResult) {
+	for _, callerResult := range taskCtx.FuncTaskResults {
+		if result.CallerMap[util.GetFuncTaskKey(callerResult.FuncTask)] == callerResult && callerResult.FuncNodeInfo != nil {
+			log.Printf("EnqueueCallers QueueCaller, result:%s, caller:%s, isRelevant:%v", util.JsonString(util.GetFuncTaskKey(result.FuncTask)), util.JsonString(util.GetFuncTaskKey(callerResult.FuncTask)), result.IsRelevant)
+			EnqueueFuncTaskResult(taskCtx, callerResult)
+		}
+	}
+}
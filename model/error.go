@@ -0,0 +1,30 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of errors of a task, to check with errors.Is.
+var (
+	ErrFuncNotFound     = errors.New("function not found")
+	ErrParse            = errors.New("parse error")
+	ErrImportUnresolved = errors.New("import unresolved")
+	ErrFuncLimit        = errors.New("function limit reached") // TaskCtx.MaxFuncs
+	ErrSliceTarget      = errors.New("slice target not found") // FuncTask.SliceTarget
+	ErrGlobalNotFound   = errors.New("global not found")       // Input.Globals
+)
+
+// TaskError is an error of the task Key.
+type TaskError struct {
+	Key FuncTaskKey
+	Err error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("%s:%s|%s: %v", e.Key.Source, e.Key.RecvTypes, e.Key.FuncName, e.Err)
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
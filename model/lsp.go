@@ -0,0 +1,100 @@
+package model
+
+import "encoding/json"
+
+// The subset of the Language Server Protocol used by the lsp command.
+
+type LspRequest struct {
+	JsonRpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id,omitempty"` // nil for notifications
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type LspResponse struct {
+	JsonRpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *LspError        `json:"error,omitempty"`
+}
+
+type LspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type LspInitializeParams struct {
+	RootUri               string                `json:"rootUri"`
+	Capabilities          LspClientCapabilities `json:"capabilities"`
+	InitializationOptions *FuncTask             `json:"initializationOptions"` // the options of every task, e.g. type_check, dataflow
+}
+
+type LspClientCapabilities struct {
+	General struct {
+		PositionEncodings []string `json:"positionEncodings,omitempty"` // "utf-8", "utf-16" or "utf-32", "utf-16" when empty
+	} `json:"general"`
+}
+
+type LspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type LspRange struct {
+	Start LspPosition `json:"start"`
+	End   LspPosition `json:"end"`
+}
+
+type LspTextDocumentIdentifier struct {
+	Uri string `json:"uri"`
+}
+
+type LspTextDocumentPositionParams struct {
+	TextDocument LspTextDocumentIdentifier `json:"textDocument"`
+	Position     LspPosition               `json:"position"`
+}
+
+type LspDocumentHighlight struct {
+	Range LspRange `json:"range"`
+	Kind  int      `json:"kind"`
+}
+
+type LspExecuteCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments"`
+}
+
+// LspSlice is the result of the yeah_woo_go.slice command: the statements of the function
+// kept for VarName, to highlight, and the statements filtered out, to fold away.
+type LspSlice struct {
+	Uri     string     `json:"uri"`
+	FuncKey string     `json:"func_key"`
+	VarName string     `json:"var_name"`
+	Kept    []LspRange `json:"kept"`
+	Removed []LspRange `json:"removed"`
+	Task    *FuncTask  `json:"task,omitempty"`
+}
+
+type LspCallHierarchyItem struct {
+	Name           string       `json:"name"`
+	Kind           int          `json:"kind"`
+	Detail         string       `json:"detail,omitempty"`
+	Uri            string       `json:"uri"`
+	Range          LspRange     `json:"range"`
+	SelectionRange LspRange     `json:"selectionRange"`
+	Data           *FuncTaskKey `json:"data,omitempty"`
+}
+
+type LspCallHierarchyCallsParams struct {
+	Item LspCallHierarchyItem `json:"item"`
+}
+
+type LspCallHierarchyIncomingCall struct {
+	From       LspCallHierarchyItem `json:"from"`
+	FromRanges []LspRange           `json:"fromRanges"`
+}
+
+type LspCallHierarchyOutgoingCall struct {
+	To         LspCallHierarchyItem `json:"to"`
+	FromRanges []LspRange           `json:"fromRanges"`
+}
@@ -1,17 +1,53 @@
 package model
 
 import (
+	"context"
 	"go/ast"
 	"go/token"
+	"go/types"
+	"sync"
 	"time"
 )
 
 type Input struct {
-	Method   string     `json:"method"`
-	FuncTask FuncTask   `json:"func_task"`
-	Funcs    []FuncTask `json:"funcs"`
+	Method          string       `json:"method"`
+	FuncTask        FuncTask     `json:"func_task"`
+	Funcs           []FuncTask   `json:"funcs"`
+	PositionMapFile string       `json:"position_map_file,omitempty"` // where to write the PositionMappings of the output
+	OutputFormat    string       `json:"output_format,omitempty"`     // "go" by default, or "json" for a JsonOutput
+	Parallel        int          `json:"parallel,omitempty"`          // the most funcs analyzed at the same time, 1 by default
+	MaxIterations   int          `json:"max_iterations,omitempty"`    // the most runs of functions, DefaultMaxIterations by default
+	Globals         []GlobalTask `json:"globals,omitempty"`           // the package-level vars and fields to track, each function using one is added to Funcs
+	CacheDir        string       `json:"cache_dir,omitempty"`         // where to keep the FileSummaries between runs, $YEAH_WOO_GO_CACHE_DIR by default, none if both are empty
 }
 
+// GlobalTask is a package-level var or a field of a type, to slice every function reading or writing it.
+type GlobalTask struct {
+	Source   string   `json:"source"`          // a go file of the package declaring the var or the type
+	Name     string   `json:"name"`            // "config" for a package-level var, "Order.Status" for a field
+	Scope    string   `json:"scope,omitempty"` // GlobalScopePackage by default, or GlobalScopeModule
+	Template FuncTask `json:"template"`        // the other settings of the tasks added, e.g. exact_match or enable_call
+}
+
+// GlobalSite is a read or a write of a global in a function, a row of the summary of Input.Globals.
+type GlobalSite struct {
+	Global string `json:"global"` // the Name of the GlobalTask
+	Access string `json:"access"` // "read" or "write"
+	Key    string `json:"key"`    // the key of the function
+	Source string `json:"source"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Expr   string `json:"expr"` // e.g. "o.Status", or "Status" for the key of a composite literal
+}
+
+const (
+	GlobalScopePackage = "package" // the functions of the package of Source
+	GlobalScopeModule  = "module"  // the functions of the module of Source, using an exported var or field
+)
+
+// DefaultMaxIterations is the most runs of functions when Input.MaxIterations is not set.
+const DefaultMaxIterations = 10000
+
 type FuncTask struct {
 	Key              string                 `json:"key"`
 	Source           string                 `json:"source"`
@@ -34,13 +70,25 @@ type FuncTask struct {
 	OnlyRelevantFunc bool                   `json:"only_relevant_func"`
 	CollectComments  bool                   `json:"collect_comments"`
 	ShowAll          bool                   `json:"show_all"`
+	TypeCheck        bool                   `json:"type_check,omitempty"`
+	Dataflow         bool                   `json:"dataflow,omitempty"`
+	BackwardDataflow bool                   `json:"backward_dataflow,omitempty"`
+	DataflowVarNames []string               `json:"dataflow_var_names,omitempty"`
+	FindCallers      bool                   `json:"find_callers,omitempty"`
+	CallerDepth      int                    `json:"caller_depth,omitempty"`
+	CallerRoots      []string               `json:"caller_roots,omitempty"`
+	ShowElided       bool                   `json:"show_elided,omitempty"`
+	SliceTarget      string                 `json:"slice_target,omitempty"`  // "line:N", "return", "return:I" or "expr:E", keeps what affects it
+	ControlDeps      bool                   `json:"control_deps,omitempty"`  // keeps the returns, panics, breaks, continues and gotos deciding whether a kept statement runs
+	GlobalAccess     []string               `json:"global_access,omitempty"` // "read" and "write", how a task added for Input.Globals uses the global
 }
 
 type FuncTaskOutput struct {
-	Key        string                 `json:"key"`
-	Comments   []string               `json:"comments"`
-	CalleeTree map[string]interface{} `json:"callee_tree"`
-	CallerTree map[string]interface{} `json:"caller_tree"`
+	Key          string                 `json:"key"`
+	Comments     []string               `json:"comments"`
+	CalleeTree   map[string]interface{} `json:"callee_tree"`
+	CallerTree   map[string]interface{} `json:"caller_tree"`
+	GlobalAccess []string               `json:"global_access,omitempty"`
 }
 
 type FuncTaskKey struct {
@@ -57,6 +105,36 @@ type FuncTaskResult struct {
 	Started                bool
 	CalleeMap              map[FuncTaskKey]*FuncTaskResult
 	CallerMap              map[FuncTaskKey]*FuncTaskResult
+	CalleeEdgeTags         map[FuncTaskKey][]string // e.g. "dynamic"
+	CallerEdgeTags         map[FuncTaskKey][]string
+	CalleeTypeArgs         map[FuncTaskKey][]string // e.g. "T=int", for the calls of generic functions
+	CallerTypeArgs         map[FuncTaskKey][]string
+	Matches                []*Match             // why the identifiers and calls of FilterRelevantNodeInfo are relevant
+	Errors                 []error              // e.g. ErrParse, ErrFuncNotFound, ErrImportUnresolved
+	Queued                 bool                 // in the worklist of the TaskCtx
+	ForcedRelevant         bool                 // relevant whatever its statements, e.g. a called function named in VarNames
+	IsRelevant             bool                 // the last run found it relevant, its callers run again when it changes
+	UnlinkedCallers        map[FuncTaskKey]bool // the callers passing it no VarNames while it had none, with no edge to it
+}
+
+const (
+	MatchRulePrefix      = "prefix"      // a.B matches var name a or a.B.C
+	MatchRuleExact       = "exact"       // ExactMatch
+	MatchRuleSubsequence = "subsequence" // SubsequenceMatch
+	MatchRuleCallee      = "callee"      // a call of a relevant function
+
+	MatchOriginVarNames = "var_names" // VarNames of the task, given or passed from another task
+	MatchOriginDataflow = "dataflow"  // DataflowVarNames
+)
+
+type Match struct {
+	Name        string `json:"name"`     // the identifier, selector or called function
+	VarName     string `json:"var_name"` // the element of VarNames matched, or the key of the callee
+	Rule        string `json:"rule"`
+	Origin      string `json:"origin,omitempty"`
+	TypeChecked bool   `json:"type_checked,omitempty"` // confirmed by the object of go/types
+	Line        int    `json:"line"`
+	Column      int    `json:"column"`
 }
 
 type TaskCtx struct {
@@ -65,6 +143,89 @@ type TaskCtx struct {
 	FuncTaskMap     map[FuncTaskKey]*FuncTaskResult
 	FileSet         *token.FileSet
 	FileInfoMap     map[string]*FileInfo
+	PackageInfoMap  map[string]*PackageInfo
+	TypesPackageMap map[string]*TypesPackage
+	StdImporter     types.Importer
+	GoFilesMap      map[string][]string // root dir -> go files
+	FileCache       *FileCache          // shared between the runs of the server, nil for a single run
+	PositionMap     []PositionMapping
+	WithPositions   bool                           // record PositionMap without Input.PositionMapFile, for the server
+	FileErrorMap    map[string]error               // abs source -> why it could not be parsed
+	Context         context.Context                // no new function is analyzed once it is done, nil for no limit
+	MaxFuncs        int                            // the most functions to analyze, 0 for no limit
+	Worklist        []*FuncTaskResult              // the results to run again, first in first out
+	Iterations      int                            // the runs of results so far
+	NotConverged    bool                           // MaxIterations stopped the worklist before the VarNames stopped growing
+	SliceNodes      map[ast.Node]bool              // the statements of the backward slice of the current task, nil without SliceTarget
+	ControlNodes    map[ast.Node]bool              // the statements kept for the kept statements of the current task, e.g. the jumps deciding whether they run
+	CallSiteTags    map[ast.Node][]string          // the edge tags of the calls of the current task run by go or defer
+	GlobalSites     []GlobalSite                   // the reads and writes of Input.Globals, writes first
+	FileSummaryMap  map[string]*FileSummary        // abs source -> the FileSummary read from or written to the disk cache
+	VarNameObjects  map[VarNameRoot][]types.Object // the objects the roots of VarNames are bound to with TypeCheck, nil for none
+}
+
+// VarNameRoot is the root of a VarName, e.g. req of "req.ID", in a FuncDecl.
+type VarNameRoot struct {
+	FuncDecl ast.Node
+	Name     string
+}
+
+// StmtDeps are the variables a statement writes and reads, for the backward slice.
+// The reads of an if, for, switch or case clause are the ones of its condition, tag or case values.
+type StmtDeps struct {
+	Stmt    ast.Stmt
+	Defs    []string
+	Uses    []string
+	Parents []ast.Stmt // the enclosing statements, blocks left out
+	Loops   []ast.Stmt // the enclosing for and range statements
+}
+
+// FileCache keeps the parsed files between runs, FileSet is the FileSet of the runs since it was last
+// replaced by StartFileCacheRun.
+type FileCache struct {
+	FileSet     *token.FileSet
+	Mu          sync.Mutex                 // guards Entries, Loading and DroppedSize, FileSet is safe for concurrent use
+	Entries     map[string]*FileCacheEntry // absolute path -> entry
+	Loading     map[string]chan struct{}   // absolute path -> closed once the file being parsed is in Entries
+	DroppedSize int64                      // the size of the files of FileSet dropped from Entries since they changed
+}
+
+type FileCacheEntry struct {
+	ModTime  time.Time
+	Size     int64
+	FileInfo *FileInfo
+}
+
+type ServeResponse struct {
+	Output      string            `json:"output"` // the simplified go code, or the JsonOutput
+	Input       *Input            `json:"input"`  // the updated input
+	PositionMap []PositionMapping `json:"position_map,omitempty"`
+	Error       string            `json:"error,omitempty"` // e.g. the input tasks which could not run
+}
+
+// WriteOptions tells where the command line writes the updated Input.
+type WriteOptions struct {
+	OutputFile string // "<input>.out.json" by default
+	InPlace    bool   // overwrite the input file instead
+	DryRun     bool   // print the diff of the file instead of writing it
+}
+
+// SliceStmt is a statement of a function after filtering:
+// a kept statement, or the outermost statement of a removed region.
+type SliceStmt struct {
+	Stmt         ast.Stmt
+	Parent       ast.Node // the statement or FuncDecl the statement is directly nested in
+	Kept         bool
+	HasKeptChild bool
+}
+
+// PositionMapping links a line of the output to the original source.
+type PositionMapping struct {
+	OutputLine  int    `json:"output_line"`
+	Source      string `json:"source"`
+	Line        int    `json:"line"`
+	Column      int    `json:"column"`
+	ElidedLines int    `json:"elided_lines,omitempty"` // for the "// ... N lines elided" lines
 }
 
 type NodeInfo struct {
@@ -83,20 +244,160 @@ type RelevantTaskResult struct {
 }
 
 type FileInfo struct {
+	Source    string // the path the file was first parsed with
 	NodeInfo  *NodeInfo
 	Package   string
 	FuncMap   map[FuncKey]*NodeInfo
 	ImportMap map[string]string
 }
 
+// PackageInfo is the symbol index of the package in Dir, built once per run from the parsed files.
+type PackageInfo struct {
+	Dir       string
+	Name      string
+	Files     []string
+	TestFiles []string              // the _test.go files, only parsed when a test looks for a function
+	TypeMap   map[string]*DeclInfo  // type name -> *ast.TypeSpec
+	VarMap    map[string]*DeclInfo  // package-level var name -> *ast.ValueSpec
+	FuncMap   map[FuncKey]*DeclInfo // function or method -> *ast.FuncDecl
+}
+
+// TypesPackage is a package type-checked from the same ast as FileInfoMap.
+type TypesPackage struct {
+	Dir     string
+	Path    string
+	Pkg     *types.Package
+	Info    *types.Info
+	Errors  []error
+	Loading bool
+}
+
+type DeclInfo struct {
+	Source   string
+	NodeInfo *NodeInfo // nil until the file is parsed when the declaration comes from a FileSummary, see ResolveDeclInfo
+	Line     int       // the position of the *ast.FuncDecl, *ast.TypeSpec or *ast.ValueSpec
+	Column   int
+	TypeKind // of a *ast.TypeSpec, known without parsing its file again
+}
+
+// FileSummary is what the disk cache keeps of a parsed file, enough to index its package without parsing it again.
+// It is used only while the content of the file has the same ContentHash, and the Version is DiskCacheVersion.
+type FileSummary struct {
+	Version     int               `json:"version"`
+	Path        string            `json:"path"`         // absolute
+	ContentHash string            `json:"content_hash"` // hex sha256 of the content
+	Package     string            `json:"package"`
+	Imports     map[string]string `json:"imports"` // the ImportMap, without ExtraImports
+	Funcs       []SymbolSummary   `json:"funcs"`
+	Types       []SymbolSummary   `json:"types"`
+	Vars        []SymbolSummary   `json:"vars"`
+}
+
+// SymbolSummary is a declaration of a FileSummary.
+type SymbolSummary struct {
+	RecvTypes string `json:"recv_types,omitempty"` // as the keys of FuncMap
+	Name      string `json:"name"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	TypeKind         // of a type
+}
+
+// TypeKind is what GetInterfaceMethodTargets needs to know of a type declaration to skip it before parsing its file:
+// an interface is not a target, and a type that does not embed another one only has the methods in the FuncMap of its package.
+type TypeKind struct {
+	IsInterface bool `json:"is_interface,omitempty"`
+	Embeds      bool `json:"embeds,omitempty"` // an alias, a type defined by another type, or a struct or interface with embedded fields
+}
+
+// ExprType is a type expression together with where it was written,
+// which is needed to resolve the package qualifiers in it.
+type ExprType struct {
+	Dir    string
+	Source string
+	Expr   ast.Expr
+}
+
+// MethodTarget is a method declaration found by type resolution.
+type MethodTarget struct {
+	Dir       string
+	RecvTypes string
+	FuncName  string
+}
+
 type FuncKey struct {
 	RecvTypes string
 	Name      string
 }
 
+type GoMod struct {
+	Dir        string
+	ModulePath string
+	Requires   map[string]string       // module path -> version
+	Replaces   map[string]GoModReplace // "path" or "path@version" -> replacement
+	HasVendor  bool
+}
+
+type GoModReplace struct {
+	Path    string
+	Version string
+}
+
+type GoWork struct {
+	Dir      string
+	Uses     []string
+	Replaces map[string]GoModReplace
+}
+
 type Metrics struct {
 	Count       int           `json:"count"`
 	TotalTime   time.Duration `json:"-"`
 	TotalTimeMS int64         `json:"total_time_ms"`
 	AvgTimeMS   float64       `json:"avg_time_ms"`
 }
+
+const (
+	OutputFormatGo   = "go"
+	OutputFormatJson = "json"
+)
+
+// JsonOutput is the output with OutputFormat "json", for scripts.
+type JsonOutput struct {
+	Funcs       []*FuncResultOutput `json:"funcs"`
+	Iterations  int                 `json:"iterations"`             // the runs of functions
+	Converged   bool                `json:"converged"`              // false when max_iterations stopped the analysis
+	GlobalSites []GlobalSite        `json:"global_sites,omitempty"` // the reads and writes of Input.Globals, writes first
+}
+
+type FuncResultOutput struct {
+	Key              string            `json:"key"`
+	Source           string            `json:"source"`
+	RecvTypes        string            `json:"recv_types"`
+	FuncName         string            `json:"func_name"`
+	Comments         []string          `json:"comments,omitempty"`
+	VarNames         []string          `json:"var_names"`
+	DataflowVarNames []string          `json:"dataflow_var_names,omitempty"`
+	GlobalAccess     []string          `json:"global_access,omitempty"` // "read" and "write", for a task added for Input.Globals
+	IsFromInput      bool              `json:"is_from_input"`
+	KeptRanges       []SourceRange     `json:"kept_ranges"`
+	RemovedRanges    []SourceRange     `json:"removed_ranges"`
+	Matches          []*Match          `json:"matches"`
+	Callees          []CallEdge        `json:"callees"`
+	Callers          []CallEdge        `json:"callers"`
+	Code             string            `json:"code"`
+	PositionMap      []PositionMapping `json:"position_map"` // OutputLine is the line in Code
+	Errors           []string          `json:"errors,omitempty"`
+}
+
+// SourceRange is a range of the source, lines and columns count from 1 and End is exclusive.
+type SourceRange struct {
+	StartLine   int `json:"start_line"`
+	StartColumn int `json:"start_column"`
+	EndLine     int `json:"end_line"`
+	EndColumn   int `json:"end_column"`
+}
+
+type CallEdge struct {
+	Key      string   `json:"key"`
+	EdgeTags []string `json:"edge_tags,omitempty"`
+	TypeArgs []string `json:"type_args,omitempty"`
+}
@@ -0,0 +1,337 @@
+// Package slicer runs yeah_woo_go in process: it keeps the statements of functions relevant to
+// variables, follows their calls and callers, and returns the result as a Report.
+// It does not write to stdout or to any file, the progress is logged with the log package.
+//
+// Calls of Analyze and AnalyzeGlobals run one at a time in a process, whatever their Options:
+// the caches of go.mod files and of imports of the analysis are global to the process. A call
+// waits for the running one to return, so to analyze many tasks at the same time, pass them to
+// a single call with Options.Parallel, or run several processes.
+package slicer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/juicymango/yeah_woo_go/handler"
+	"github.com/juicymango/yeah_woo_go/logic"
+	"github.com/juicymango/yeah_woo_go/model"
+	"github.com/juicymango/yeah_woo_go/util"
+)
+
+// The errors of the tasks, to check with errors.Is.
+var (
+	ErrFuncNotFound     = model.ErrFuncNotFound
+	ErrParse            = model.ErrParse
+	ErrImportUnresolved = model.ErrImportUnresolved
+	ErrFuncLimit        = model.ErrFuncLimit
+	ErrSliceTarget      = model.ErrSliceTarget
+	ErrGlobalNotFound   = model.ErrGlobalNotFound
+	ErrInvalidTask      = errors.New("invalid task")
+)
+
+// Task is a function to slice and the variables of interest in it.
+type Task struct {
+	Source         string   // path of the go file
+	RecvTypes      string   // receiver types of a method, e.g. "*Service", empty for a function
+	FuncName       string   // name of the function
+	VarNames       []string // variables of interest, e.g. "req.ID"
+	FuncCalls      []string // calls to follow even when not relevant, "recv|pkg.Func"
+	FuncCallerKeys []string // callers to run, keys like "a.go:|Caller"
+	ExtraImports   []string // "name|path", for the calls of packages not imported by the file
+	SliceTarget    string   // "line:N", "return", "return:I" or "expr:E", to keep only the statements affecting it
+}
+
+// Global is a package var or a struct field, to slice every function reading or writing it.
+type Global struct {
+	Source string // a go file of the package declaring the var or the type
+	Name   string // the var, e.g. "config", or the field, e.g. "Order.Status"
+	Module bool   // look for the functions in the whole module, not only in the package
+}
+
+// Options apply to every Task and to the subtasks found.
+type Options struct {
+	// Matching of the variable names.
+	ExactMatch       bool // only the variables themselves, not their fields
+	SubsequenceMatch bool // names containing the parts of a variable name in order
+	Dataflow         bool // also the variables assigned from the relevant ones
+	BackwardDataflow bool // also the variables the relevant ones are assigned from
+	TypeCheck        bool // resolve names with go/types
+
+	// Statements kept besides the relevant ones.
+	ShowReturn   bool
+	ShowBreak    bool
+	ShowContinue bool
+	ShowAll      bool // every statement
+	ShowElided   bool // "// ... N lines elided" comments in the code
+	ControlDeps  bool // the returns, panics, breaks, continues and gotos deciding whether a kept statement runs
+
+	// Resolution of calls and callers.
+	EnableCall       bool     // follow the calls of relevant functions
+	OnlyRelevantFunc bool     // keep a call only when the called function is relevant
+	FindCallers      bool     // look for the callers of the functions of the tasks
+	CallerDepth      int      // levels of callers to look for, 1 by default
+	CallerRoots      []string // directories to look for callers in, the module root by default
+
+	// Limits.
+	MaxFuncs      int // the most functions to analyze, tasks included, 0 for no limit
+	MaxIterations int // the most runs of functions, each runs again when its VarNames grow, model.DefaultMaxIterations by default
+	Parallel      int // the most tasks analyzed at the same time, 1 by default, ignored with MaxFuncs
+
+	// Cache keeps the parsed files between calls of Analyze, nil for none.
+	Cache *Cache
+	// CacheDir keeps the summaries of the files on disk between runs, see Input.CacheDir.
+	CacheDir string
+}
+
+// Cache keeps the parsed files, a file changed since is parsed again.
+type Cache struct {
+	fileCache *model.FileCache
+}
+
+// NewCache returns an empty Cache, for Options.Cache.
+func NewCache() *Cache {
+	return &Cache{fileCache: logic.NewFileCache()}
+}
+
+// Report holds the result of every Task, then the relevant results of the subtasks.
+type Report struct {
+	Funcs     []*FuncReport
+	Tasks     []Task       // the tasks and subtasks, to run again with more VarNames
+	Truncated bool         // MaxFuncs or ctx stopped the analysis of some functions
+	Converged bool         // false when MaxIterations stopped the analysis before the VarNames stopped growing
+	Globals   []GlobalSite // the writes, then the reads, of each Global of AnalyzeGlobals
+}
+
+// GlobalSite is a read or a write of a Global.
+type GlobalSite struct {
+	Global string // the Name of the Global
+	Access string // "read" or "write"
+	Key    string // the key of the function
+	Source string
+	Line   int
+	Column int
+	Expr   string // e.g. "o.Status", or "Status" for the key of a composite literal
+}
+
+// FuncReport is the result of a function, with its code and why its statements are kept.
+type FuncReport struct {
+	Key              string // e.g. "service.go:*Service|Handle"
+	Source           string
+	RecvTypes        string
+	FuncName         string
+	Comments         []string
+	VarNames         []string
+	DataflowVarNames []string
+	GlobalAccess     []string // "read" and "write", for a function of AnalyzeGlobals
+	IsFromInput      bool     // the function of a Task, not of a subtask
+	KeptRanges       []Range
+	RemovedRanges    []Range
+	Matches          []Match
+	Callees          []CallEdge
+	Callers          []CallEdge
+	Code             string
+	PositionMap      []PositionMapping // OutputLine is the line in Code
+	Errors           []string
+}
+
+// Range is a range of the source, lines and columns count from 1 and End is exclusive.
+type Range struct {
+	StartLine   int
+	StartColumn int
+	EndLine     int
+	EndColumn   int
+}
+
+// Match is a name of the function matching one of its VarNames, which keeps the statement around it.
+type Match struct {
+	Name        string // the identifier, selector or called function
+	VarName     string // the element of VarNames matched, or the key of the callee
+	Rule        string
+	Origin      string
+	TypeChecked bool // confirmed by the object of go/types
+	Line        int
+	Column      int
+}
+
+// CallEdge is a call between two functions of the Report.
+type CallEdge struct {
+	Key      string   // the key of the other function
+	EdgeTags []string // e.g. "dynamic" for a call through an interface
+	TypeArgs []string // e.g. "T=int" for a call of a generic function
+}
+
+// PositionMapping is the original position of a line of FuncReport.Code.
+type PositionMapping struct {
+	OutputLine  int
+	Source      string
+	Line        int
+	Column      int
+	ElidedLines int // for the "// ... N lines elided" lines
+}
+
+// Analyze runs tasks with opts. Calls of Analyze run one at a time, see the package doc.
+// When some tasks could not run, it returns the Report of the others with an error joining a model.TaskError
+// for each of them. Once ctx is done, no new function is analyzed, and the error includes ctx.Err().
+func Analyze(ctx context.Context, opts Options, tasks []Task) (*Report, error) {
+	funcTasks := make([]model.FuncTask, 0, len(tasks))
+	for idx, task := range tasks {
+		if task.Source == "" || task.FuncName == "" {
+			return nil, fmt.Errorf("%w: task %d needs Source and FuncName", ErrInvalidTask, idx)
+		}
+		funcTasks = append(funcTasks, GetFuncTask(opts, task))
+	}
+	return AnalyzeInput(ctx, opts, &model.Input{Funcs: funcTasks})
+}
+
+// AnalyzeGlobals runs a task with opts for each function reading or writing the globals, like Analyze.
+// The FuncReport of each of them tells how in GlobalAccess, and Report.Globals lists each read and write.
+func AnalyzeGlobals(ctx context.Context, opts Options, globals []Global) (*Report, error) {
+	globalTasks := make([]model.GlobalTask, 0, len(globals))
+	for idx, global := range globals {
+		if global.Source == "" || global.Name == "" {
+			return nil, fmt.Errorf("%w: global %d needs Source and Name", ErrInvalidTask, idx)
+		}
+		globalTask := model.GlobalTask{
+			Source:   global.Source,
+			Name:     global.Name,
+			Template: GetFuncTask(opts, Task{}),
+		}
+		if global.Module {
+			globalTask.Scope = model.GlobalScopeModule
+		}
+		globalTasks = append(globalTasks, globalTask)
+	}
+	return AnalyzeInput(ctx, opts, &model.Input{Globals: globalTasks})
+}
+
+// AnalyzeInput runs the Funcs and Globals of input with the limits and the Cache of opts.
+func AnalyzeInput(ctx context.Context, opts Options, input *model.Input) (*Report, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	analyzeMu.Lock()
+	defer analyzeMu.Unlock()
+	// go.mod files may have changed since the last call
+	util.ResetModuleCache()
+	input.Method = "GetRelevantFuncs"
+	input.OutputFormat = model.OutputFormatJson
+	input.Parallel = opts.Parallel
+	input.MaxIterations = opts.MaxIterations
+	input.CacheDir = opts.CacheDir
+	taskCtx := &model.TaskCtx{
+		Input:    input,
+		Context:  ctx,
+		MaxFuncs: opts.MaxFuncs,
+	}
+	if opts.Cache != nil {
+		taskCtx.FileCache = opts.Cache.fileCache
+		taskCtx.FileSet = logic.StartFileCacheRun(opts.Cache.fileCache)
+	}
+	err := handler.AnalyzeRelevantFuncs(taskCtx)
+	report := &Report{
+		Converged: !taskCtx.NotConverged,
+	}
+	for _, funcResultOutput := range handler.GetJsonOutput(taskCtx).Funcs {
+		report.Funcs = append(report.Funcs, GetFuncReport(funcResultOutput))
+	}
+	for _, funcTask := range taskCtx.Input.Funcs {
+		report.Tasks = append(report.Tasks, GetTask(funcTask))
+	}
+	for _, globalSite := range taskCtx.GlobalSites {
+		report.Globals = append(report.Globals, GlobalSite(globalSite))
+	}
+	for _, result := range taskCtx.FuncTaskResults {
+		for _, resultErr := range result.Errors {
+			if errors.Is(resultErr, ErrFuncLimit) || errors.Is(resultErr, ctx.Err()) {
+				report.Truncated = true
+			}
+		}
+	}
+	return report, err
+}
+
+// analyzeMu guards the caches of the util and logic packages, global to the process, see the package doc.
+var analyzeMu sync.Mutex
+
+// GetFuncTask returns the FuncTask of task with opts.
+func GetFuncTask(opts Options, task Task) model.FuncTask {
+	return model.FuncTask{
+		Source:           task.Source,
+		RecvTypes:        task.RecvTypes,
+		FuncName:         task.FuncName,
+		VarNames:         task.VarNames,
+		FuncCalls:        task.FuncCalls,
+		FuncCallerKeys:   task.FuncCallerKeys,
+		ExtraImports:     task.ExtraImports,
+		SliceTarget:      task.SliceTarget,
+		ExactMatch:       opts.ExactMatch,
+		SubsequenceMatch: opts.SubsequenceMatch,
+		Dataflow:         opts.Dataflow,
+		BackwardDataflow: opts.BackwardDataflow,
+		TypeCheck:        opts.TypeCheck,
+		ShowReturn:       opts.ShowReturn,
+		ControlDeps:      opts.ControlDeps,
+		ShowBreak:        opts.ShowBreak,
+		ShowContinue:     opts.ShowContinue,
+		ShowAll:          opts.ShowAll,
+		ShowElided:       opts.ShowElided,
+		EnableCall:       opts.EnableCall,
+		OnlyRelevantFunc: opts.OnlyRelevantFunc,
+		FindCallers:      opts.FindCallers,
+		CallerDepth:      opts.CallerDepth,
+		CallerRoots:      opts.CallerRoots,
+	}
+}
+
+// GetTask returns the Task of funcTask, without the options.
+func GetTask(funcTask model.FuncTask) Task {
+	return Task{
+		Source:         funcTask.Source,
+		RecvTypes:      funcTask.RecvTypes,
+		FuncName:       funcTask.FuncName,
+		VarNames:       funcTask.VarNames,
+		FuncCalls:      funcTask.FuncCalls,
+		FuncCallerKeys: funcTask.FuncCallerKeys,
+		ExtraImports:   funcTask.ExtraImports,
+		SliceTarget:    funcTask.SliceTarget,
+	}
+}
+
+// GetFuncReport returns the FuncReport of the JSON output of a function.
+func GetFuncReport(funcResultOutput *model.FuncResultOutput) *FuncReport {
+	funcReport := &FuncReport{
+		Key:              funcResultOutput.Key,
+		Source:           funcResultOutput.Source,
+		RecvTypes:        funcResultOutput.RecvTypes,
+		FuncName:         funcResultOutput.FuncName,
+		Comments:         funcResultOutput.Comments,
+		VarNames:         funcResultOutput.VarNames,
+		DataflowVarNames: funcResultOutput.DataflowVarNames,
+		GlobalAccess:     funcResultOutput.GlobalAccess,
+		IsFromInput:      funcResultOutput.IsFromInput,
+		Code:             funcResultOutput.Code,
+		Errors:           funcResultOutput.Errors,
+	}
+	for _, sourceRange := range funcResultOutput.KeptRanges {
+		funcReport.KeptRanges = append(funcReport.KeptRanges, Range(sourceRange))
+	}
+	for _, sourceRange := range funcResultOutput.RemovedRanges {
+		funcReport.RemovedRanges = append(funcReport.RemovedRanges, Range(sourceRange))
+	}
+	for _, match := range funcResultOutput.Matches {
+		funcReport.Matches = append(funcReport.Matches, Match(*match))
+	}
+	for _, callEdge := range funcResultOutput.Callees {
+		funcReport.Callees = append(funcReport.Callees, CallEdge(callEdge))
+	}
+	for _, callEdge := range funcResultOutput.Callers {
+		funcReport.Callers = append(funcReport.Callers, CallEdge(callEdge))
+	}
+	for _, positionMapping := range funcResultOutput.PositionMap {
+		funcReport.PositionMap = append(funcReport.PositionMap, PositionMapping(positionMapping))
+	}
+	return funcReport
+}
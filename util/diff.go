@@ -0,0 +1,125 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiffContextLines is the number of unchanged lines around each hunk of UnifiedDiff.
+const DiffContextLines = 3
+
+// UnifiedDiff returns the changes from oldText to newText in the unified diff format,
+// or "" when they are the same.
+func UnifiedDiff(oldName string, newName string, oldText string, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	oldLines := SplitLines(oldText)
+	newLines := SplitLines(newText)
+
+	// lcs[i][j] is the length of the longest common subsequence of oldLines[i:] and newLines[j:]
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// ops are ' ', '-' or '+' followed by the line
+	var ops []string
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			ops = append(ops, " "+oldLines[i])
+			i++
+			j++
+		case i < len(oldLines) && (j == len(newLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, "-"+oldLines[i])
+			i++
+		default:
+			ops = append(ops, "+"+newLines[j])
+			j++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	oldLine, newLine := 1, 1
+	for start := 0; start < len(ops); {
+		if ops[start][0] == ' ' {
+			oldLine++
+			newLine++
+			start++
+			continue
+		}
+		// a hunk goes on while the changes are at most 2*DiffContextLines lines apart
+		hunkStart := max(start-DiffContextLines, 0)
+		end := start
+		for unchanged := 0; end < len(ops) && unchanged <= 2*DiffContextLines; end++ {
+			if ops[end][0] == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		hunkEnd := end
+		for hunkEnd > start && ops[hunkEnd-1][0] == ' ' {
+			hunkEnd--
+		}
+		hunkEnd = min(hunkEnd+DiffContextLines, len(ops))
+
+		hunkOldStart, hunkNewStart := oldLine-(start-hunkStart), newLine-(start-hunkStart)
+		var oldCount, newCount int
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op[0] != '+' {
+				oldCount++
+			}
+			if op[0] != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", GetHunkRange(hunkOldStart, oldCount), GetHunkRange(hunkNewStart, newCount))
+		for _, op := range ops[hunkStart:hunkEnd] {
+			sb.WriteString(op)
+			sb.WriteString("\n")
+		}
+		for _, op := range ops[start:hunkEnd] {
+			if op[0] != '+' {
+				oldLine++
+			}
+			if op[0] != '-' {
+				newLine++
+			}
+		}
+		start = hunkEnd
+	}
+	return sb.String()
+}
+
+// GetHunkRange returns the range of a hunk header, e.g. "3,4", or "2,0" for no line after line 2.
+func GetHunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// SplitLines splits text into lines, without the line breaks.
+func SplitLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -2,14 +2,20 @@ package util
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/juicymango/yeah_woo_go/model"
 )
 
-var MetricsMap map[string]*model.Metrics
+var (
+	metricsMu  sync.Mutex
+	MetricsMap map[string]*model.Metrics
+)
 
 func GetMetrics(key string) *model.Metrics {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
 	if MetricsMap == nil {
 		MetricsMap = make(map[string]*model.Metrics)
 	}
@@ -28,6 +34,8 @@ func GetMetricsDeferFunc(metrics *model.Metrics) func() {
 		if metrics == nil {
 			return
 		}
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
 		metrics.Count++
 		metrics.TotalTime += time.Since(startTime)
 	}
@@ -41,6 +49,8 @@ func GetMetricsOutput(metrics *model.Metrics) {
 }
 
 func LogMetricsResult() {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
 	for key, metrics := range MetricsMap {
 		GetMetricsOutput(metrics)
 		log.Printf("LogMetricsResult key:%s, result:%s", key, JsonString(metrics))
@@ -0,0 +1,579 @@
+package util
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/juicymango/yeah_woo_go/model"
+)
+
+var (
+	// moduleCacheMu guards the maps below, the files are read without it
+	moduleCacheMu sync.Mutex
+	goModMap      map[string]*model.GoMod
+	goWorkMap     map[string]*model.GoWork
+	importDirMap  map[string]string
+)
+
+// ResetModuleCache forgets the parsed go.mod and go.work files and the resolved imports,
+// so that a long-running server sees edits to them.
+func ResetModuleCache() {
+	moduleCacheMu.Lock()
+	defer moduleCacheMu.Unlock()
+	goModMap = nil
+	goWorkMap = nil
+	importDirMap = nil
+}
+
+// GetImportDir returns the directory of the package with the given import path,
+// as seen from a go file located in fromDir.
+// In module mode it looks in the main module, the go.work workspace modules, vendor/,
+// the replace directives and the module cache. Without go.mod it falls back to GOPATH/src.
+// Its errors wrap ErrImportUnresolved.
+func GetImportDir(fromDir string, importPath string) (string, error) {
+	fromDirAbs, err := filepath.Abs(fromDir)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", model.ErrImportUnresolved, importPath, err)
+	}
+	goModPath := FindFileUpwards(fromDirAbs, "go.mod")
+	if goModPath == "" {
+		dir, err := GetAbsoluteImportPath(importPath)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %v", model.ErrImportUnresolved, importPath, err)
+		}
+		return dir, nil
+	}
+
+	cacheKey := goModPath + "|" + importPath
+	moduleCacheMu.Lock()
+	dir, ok := importDirMap[cacheKey]
+	moduleCacheMu.Unlock()
+	if ok {
+		return dir, nil
+	}
+
+	dir, err = GetModuleImportDir(goModPath, importPath)
+	if err != nil {
+		log.Printf("GetImportDir Fail, fromDir:%s, importPath:%s, err:%+v", fromDir, importPath, err)
+		return "", fmt.Errorf("%w: %s: %v", model.ErrImportUnresolved, importPath, err)
+	}
+	moduleCacheMu.Lock()
+	if importDirMap == nil {
+		importDirMap = make(map[string]string)
+	}
+	importDirMap[cacheKey] = dir
+	moduleCacheMu.Unlock()
+	return dir, nil
+}
+
+// GetDirImportPath returns the import path of the package in dir, based on the nearest go.mod
+// or on GOPATH/src, and falls back to the slash separated absolute dir.
+func GetDirImportPath(dir string) string {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.ToSlash(dir)
+	}
+	if goModPath := FindFileUpwards(absDir, "go.mod"); goModPath != "" {
+		goMod, err := GetGoMod(goModPath)
+		if err == nil && goMod.ModulePath != "" {
+			rel, err := filepath.Rel(goMod.Dir, absDir)
+			if err == nil {
+				return path.Join(goMod.ModulePath, filepath.ToSlash(rel))
+			}
+		}
+	}
+	if gopath := os.Getenv("GOPATH"); gopath != "" {
+		for _, gopathEntry := range filepath.SplitList(gopath) {
+			rel, err := filepath.Rel(filepath.Join(gopathEntry, "src"), absDir)
+			if err == nil && !strings.HasPrefix(rel, "..") {
+				return filepath.ToSlash(rel)
+			}
+		}
+	}
+	return filepath.ToSlash(absDir)
+}
+
+// GetModuleImportDir resolves importPath for the module whose go.mod is at goModPath.
+func GetModuleImportDir(goModPath string, importPath string) (string, error) {
+	mainMod, err := GetGoMod(goModPath)
+	if err != nil {
+		return "", err
+	}
+	goWork := GetGoWork(mainMod.Dir)
+
+	// main module and workspace modules
+	mods := []*model.GoMod{mainMod}
+	if goWork != nil {
+		mods = mods[:0]
+		for _, use := range goWork.Uses {
+			useMod, err := GetGoMod(filepath.Join(use, "go.mod"))
+			if err != nil {
+				log.Printf("GetModuleImportDir GetGoModFail, use:%s, err:%+v", use, err)
+				continue
+			}
+			mods = append(mods, useMod)
+		}
+	}
+	for _, mod := range mods {
+		if rel, ok := TrimModulePath(importPath, mod.ModulePath); ok {
+			dir := filepath.Join(mod.Dir, rel)
+			if IsDir(dir) {
+				return dir, nil
+			}
+		}
+	}
+
+	// vendor, only used outside of workspaces like the go command does
+	if goWork == nil && mainMod.HasVendor {
+		dir := filepath.Join(mainMod.Dir, "vendor", importPath)
+		if IsDir(dir) {
+			return dir, nil
+		}
+	}
+
+	if IsStdImportPath(importPath) {
+		return "", fmt.Errorf("import path %s is in the standard library", importPath)
+	}
+
+	// required modules
+	modPath, version := "", ""
+	for _, mod := range mods {
+		for reqPath, reqVersion := range mod.Requires {
+			if _, ok := TrimModulePath(importPath, reqPath); ok && len(reqPath) > len(modPath) {
+				modPath, version = reqPath, reqVersion
+			}
+		}
+	}
+	if modPath == "" {
+		return GetModCacheImportDirAnyVersion(importPath)
+	}
+	rel, _ := TrimModulePath(importPath, modPath)
+
+	// replace directives, the workspace ones take precedence
+	replaceMods := mods
+	if goWork != nil {
+		replaceMods = append([]*model.GoMod{{Dir: goWork.Dir, Replaces: goWork.Replaces}}, mods...)
+	}
+	for _, mod := range replaceMods {
+		replace, ok := mod.Replaces[modPath+"@"+version]
+		if !ok {
+			replace, ok = mod.Replaces[modPath]
+		}
+		if !ok {
+			continue
+		}
+		if IsLocalReplacePath(replace.Path) {
+			replaceDir := replace.Path
+			if !filepath.IsAbs(replaceDir) {
+				replaceDir = filepath.Join(mod.Dir, replaceDir)
+			}
+			return filepath.Join(replaceDir, rel), nil
+		}
+		modPath, version = replace.Path, replace.Version
+		break
+	}
+
+	dir, err := GetModCacheDir(modPath, version)
+	if err != nil {
+		return "", err
+	}
+	dir = filepath.Join(dir, rel)
+	if !IsDir(dir) {
+		return "", fmt.Errorf("module %s@%s not found in module cache, dir:%s", modPath, version, dir)
+	}
+	return dir, nil
+}
+
+// GetModCacheImportDirAnyVersion looks for importPath in the module cache when no
+// go.mod requires it, trying the longest module path first and the highest version.
+func GetModCacheImportDirAnyVersion(importPath string) (string, error) {
+	modCache := GetModCache()
+	segments := strings.Split(importPath, "/")
+	for idx := len(segments); idx > 0; idx-- {
+		modPath := strings.Join(segments[:idx], "/")
+		escapedModPath, err := EscapeModulePath(modPath)
+		if err != nil {
+			return "", err
+		}
+		matches, err := filepath.Glob(filepath.Join(modCache, escapedModPath+"@*"))
+		if err != nil || len(matches) == 0 {
+			continue
+		}
+		// the highest version, not the last name: v1.10.0 is after v1.9.0
+		slices.SortStableFunc(matches, func(a, b string) int {
+			return CompareModVersions(a[strings.LastIndex(a, "@")+1:], b[strings.LastIndex(b, "@")+1:])
+		})
+		dir := filepath.Join(matches[len(matches)-1], filepath.Join(segments[idx:]...))
+		if IsDir(dir) {
+			return dir, nil
+		}
+	}
+	return GetAbsoluteImportPath(importPath)
+}
+
+// CompareModVersions compares two module versions the way semver.Compare of golang.org/x/mod does:
+// v1.10.0 is after v1.9.0, a pre-release or a pseudo-version is before its release, build metadata
+// such as +incompatible is ignored, and an invalid version is before every valid one.
+func CompareModVersions(a string, b string) int {
+	aVersion, aOk := ParseModVersion(a)
+	bVersion, bOk := ParseModVersion(b)
+	switch {
+	case !aOk && !bOk:
+		return strings.Compare(a, b)
+	case !aOk:
+		return -1
+	case !bOk:
+		return 1
+	}
+	for idx := range aVersion.Numbers {
+		if result := CompareNumericIdent(aVersion.Numbers[idx], bVersion.Numbers[idx]); result != 0 {
+			return result
+		}
+	}
+	return ComparePrerelease(aVersion.Prerelease, bVersion.Prerelease)
+}
+
+// ModVersion is a parsed module version, "v1.2" has the Numbers 1, 2 and 0.
+type ModVersion struct {
+	Numbers    [3]string
+	Prerelease string
+}
+
+// ParseModVersion parses a version like v1.2.3-pre+build, returning false when it is not one.
+func ParseModVersion(version string) (ModVersion, bool) {
+	modVersion := ModVersion{Numbers: [3]string{"0", "0", "0"}}
+	version, ok := strings.CutPrefix(version, "v")
+	if !ok {
+		return modVersion, false
+	}
+	version, _, _ = strings.Cut(version, "+")
+	version, modVersion.Prerelease, _ = strings.Cut(version, "-")
+	numbers := strings.Split(version, ".")
+	if len(numbers) > 3 || (modVersion.Prerelease != "" && len(numbers) != 3) {
+		return modVersion, false
+	}
+	for idx, number := range numbers {
+		if !IsNumericIdent(number) {
+			return modVersion, false
+		}
+		modVersion.Numbers[idx] = number
+	}
+	return modVersion, true
+}
+
+// IsNumericIdent reports whether s is a number without leading zeros.
+func IsNumericIdent(s string) bool {
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareNumericIdent compares two numbers without leading zeros, of any length.
+func CompareNumericIdent(a string, b string) int {
+	if len(a) != len(b) {
+		return cmp.Compare(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// ComparePrerelease compares the pre-release parts of two versions, a release having none is after them.
+func ComparePrerelease(a string, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+	aIdents := strings.Split(a, ".")
+	bIdents := strings.Split(b, ".")
+	for idx := 0; idx < len(aIdents) && idx < len(bIdents); idx++ {
+		aIdent, bIdent := aIdents[idx], bIdents[idx]
+		aNumeric, bNumeric := IsNumericIdent(aIdent), IsNumericIdent(bIdent)
+		var result int
+		switch {
+		case aNumeric && bNumeric:
+			result = CompareNumericIdent(aIdent, bIdent)
+		case aNumeric:
+			result = -1
+		case bNumeric:
+			result = 1
+		default:
+			result = strings.Compare(aIdent, bIdent)
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return cmp.Compare(len(aIdents), len(bIdents))
+}
+
+// GetModCacheDir returns the module cache directory of modPath@version.
+func GetModCacheDir(modPath string, version string) (string, error) {
+	escapedModPath, err := EscapeModulePath(modPath)
+	if err != nil {
+		return "", err
+	}
+	escapedVersion, err := EscapeModulePath(version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(GetModCache(), escapedModPath+"@"+escapedVersion), nil
+}
+
+// GetModCache returns $GOMODCACHE, defaulting to the first GOPATH entry plus pkg/mod.
+func GetModCache() string {
+	if modCache := os.Getenv("GOMODCACHE"); modCache != "" {
+		return modCache
+	}
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			log.Printf("GetModCache UserHomeDirErr %+v", err)
+			return ""
+		}
+		gopath = filepath.Join(home, "go")
+	}
+	return filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod")
+}
+
+// EscapeModulePath encodes upper case letters as "!" followed by the lower case letter,
+// which is how the module cache stores module paths and versions.
+func EscapeModulePath(path string) (string, error) {
+	var builder strings.Builder
+	for _, r := range path {
+		if r == '!' {
+			return "", fmt.Errorf("invalid module path %s", path)
+		}
+		if unicode.IsUpper(r) {
+			builder.WriteByte('!')
+			builder.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String(), nil
+}
+
+// TrimModulePath returns the path of importPath relative to modPath if importPath is in the module.
+func TrimModulePath(importPath string, modPath string) (string, bool) {
+	if modPath == "" {
+		return "", false
+	}
+	if importPath == modPath {
+		return "", true
+	}
+	if strings.HasPrefix(importPath, modPath+"/") {
+		return strings.TrimPrefix(importPath, modPath+"/"), true
+	}
+	return "", false
+}
+
+// IsStdImportPath reports whether the first path element has no dot, like "fmt" or "encoding/json".
+func IsStdImportPath(importPath string) bool {
+	first, _, _ := strings.Cut(importPath, "/")
+	return !strings.Contains(first, ".")
+}
+
+func IsLocalReplacePath(path string) bool {
+	return filepath.IsAbs(path) || strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") || path == "." || path == ".."
+}
+
+func IsDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// FindFileUpwards looks for name in dir and its parents, and returns its path or "".
+func FindFileUpwards(dir string, name string) string {
+	for {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// GetGoMod parses the go.mod file at goModPath, caching the result.
+func GetGoMod(goModPath string) (*model.GoMod, error) {
+	moduleCacheMu.Lock()
+	goMod := goModMap[goModPath]
+	moduleCacheMu.Unlock()
+	if goMod != nil {
+		return goMod, nil
+	}
+	fileBytes, err := os.ReadFile(goModPath)
+	if err != nil {
+		return nil, err
+	}
+	goMod = &model.GoMod{
+		Dir:      filepath.Dir(goModPath),
+		Requires: make(map[string]string),
+		Replaces: make(map[string]model.GoModReplace),
+	}
+	for _, directive := range ParseModDirectives(string(fileBytes)) {
+		switch directive[0] {
+		case "module":
+			if len(directive) >= 2 {
+				goMod.ModulePath = directive[1]
+			}
+		case "require":
+			if len(directive) >= 3 {
+				goMod.Requires[directive[1]] = directive[2]
+			}
+		case "replace":
+			key, replace, ok := ParseReplaceDirective(directive)
+			if ok {
+				goMod.Replaces[key] = replace
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(goMod.Dir, "vendor", "modules.txt")); err == nil {
+		goMod.HasVendor = true
+	}
+	moduleCacheMu.Lock()
+	if goModMap == nil {
+		goModMap = make(map[string]*model.GoMod)
+	}
+	goModMap[goModPath] = goMod
+	moduleCacheMu.Unlock()
+	log.Printf("GetGoMod goModPath:%s, goMod:%s", goModPath, JsonString(goMod))
+	return goMod, nil
+}
+
+// GetGoWork returns the go.work workspace containing dir, respecting GOWORK, or nil.
+func GetGoWork(dir string) *model.GoWork {
+	goWorkPath := os.Getenv("GOWORK")
+	if goWorkPath == "off" {
+		return nil
+	}
+	if goWorkPath == "" {
+		goWorkPath = FindFileUpwards(dir, "go.work")
+	}
+	if goWorkPath == "" {
+		return nil
+	}
+	moduleCacheMu.Lock()
+	goWork, ok := goWorkMap[goWorkPath]
+	moduleCacheMu.Unlock()
+	if ok {
+		return goWork
+	}
+	fileBytes, err := os.ReadFile(goWorkPath)
+	if err != nil {
+		log.Printf("GetGoWork ReadFileErr, goWorkPath:%s, err:%+v", goWorkPath, err)
+		SetGoWorkCache(goWorkPath, nil)
+		return nil
+	}
+	goWork = &model.GoWork{
+		Dir:      filepath.Dir(goWorkPath),
+		Replaces: make(map[string]model.GoModReplace),
+	}
+	for _, directive := range ParseModDirectives(string(fileBytes)) {
+		switch directive[0] {
+		case "use":
+			if len(directive) >= 2 {
+				use := directive[1]
+				if !filepath.IsAbs(use) {
+					use = filepath.Join(goWork.Dir, use)
+				}
+				goWork.Uses = append(goWork.Uses, use)
+			}
+		case "replace":
+			key, replace, ok := ParseReplaceDirective(directive)
+			if ok {
+				goWork.Replaces[key] = replace
+			}
+		}
+	}
+	SetGoWorkCache(goWorkPath, goWork)
+	log.Printf("GetGoWork goWorkPath:%s, goWork:%s", goWorkPath, JsonString(goWork))
+	return goWork
+}
+
+// SetGoWorkCache caches goWork, nil for no workspace, as the workspace of goWorkPath.
+func SetGoWorkCache(goWorkPath string, goWork *model.GoWork) {
+	moduleCacheMu.Lock()
+	defer moduleCacheMu.Unlock()
+	if goWorkMap == nil {
+		goWorkMap = make(map[string]*model.GoWork)
+	}
+	goWorkMap[goWorkPath] = goWork
+}
+
+// ParseReplaceDirective parses ["replace", old, [version], "=>", new, [version]].
+func ParseReplaceDirective(directive []string) (string, model.GoModReplace, bool) {
+	arrowIdx := -1
+	for idx, word := range directive {
+		if word == "=>" {
+			arrowIdx = idx
+			break
+		}
+	}
+	if arrowIdx < 2 || arrowIdx+1 >= len(directive) {
+		return "", model.GoModReplace{}, false
+	}
+	key := directive[1]
+	if arrowIdx == 3 {
+		key = directive[1] + "@" + directive[2]
+	}
+	replace := model.GoModReplace{Path: directive[arrowIdx+1]}
+	if arrowIdx+2 < len(directive) {
+		replace.Version = directive[arrowIdx+2]
+	}
+	return key, replace, true
+}
+
+// ParseModDirectives splits a go.mod or go.work file into directives, expanding blocks like
+// "require ( ... )" so that every directive starts with its verb.
+func ParseModDirectives(content string) [][]string {
+	var directives [][]string
+	blockVerb := ""
+	for _, line := range strings.Split(content, "\n") {
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = line[:idx]
+		}
+		words := strings.Fields(line)
+		for idx, word := range words {
+			words[idx] = RemoveQuotesIfPresent(word)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		if blockVerb != "" {
+			if words[0] == ")" {
+				blockVerb = ""
+				continue
+			}
+			directives = append(directives, append([]string{blockVerb}, words...))
+			continue
+		}
+		if len(words) == 2 && words[1] == "(" {
+			blockVerb = words[0]
+			continue
+		}
+		directives = append(directives, words)
+	}
+	return directives
+}
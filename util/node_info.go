@@ -133,26 +133,30 @@ func CloneNode(n ast.Node) ast.Node {
 	return newNode
 }
 
-func NodeInfoUpdateNode(nodeInfo *model.NodeInfo) {
-	nodeInfo.Node = CloneNode(nodeInfo.Node)
+// GetUpdatedNode returns a copy of the node of nodeInfo having only the fields left in nodeInfo, to print it.
+// nodeInfo keeps the original nodes, and origNodes, unless nil, maps each copy to its original node.
+func GetUpdatedNode(nodeInfo *model.NodeInfo, origNodes map[ast.Node]ast.Node) ast.Node {
+	node := CloneNode(nodeInfo.Node)
+	if origNodes != nil {
+		origNodes[node] = nodeInfo.Node
+	}
 	for name, field := range nodeInfo.NodeFields {
-		NodeInfoUpdateNode(field)
-		err := SetValueToFieldByName(nodeInfo.Node, name, field.Node)
+		err := SetValueToFieldByName(node, name, GetUpdatedNode(field, origNodes))
 		if err != nil {
-			log.Printf("NodeInfoUpdateNode SetValueToFieldByNameFail NodeType %s, FieldName %s, Err %v", nodeInfo.Type, name, err)
+			log.Printf("GetUpdatedNode SetValueToFieldByNameFail NodeType %s, FieldName %s, Err %v", nodeInfo.Type, name, err)
 		}
 	}
 	for name, fields := range nodeInfo.NodeListFields {
 		nodes := make([]ast.Node, 0, len(fields))
 		for _, field := range fields {
-			NodeInfoUpdateNode(field)
-			nodes = append(nodes, field.Node)
+			nodes = append(nodes, GetUpdatedNode(field, origNodes))
 		}
-		err := SetValueToFieldByName(nodeInfo.Node, name, nodes)
+		err := SetValueToFieldByName(node, name, nodes)
 		if err != nil {
-			log.Printf("NodeInfoUpdateNode SetValueToFieldByNameFail NodeType %s, FieldName %s, Err %v", nodeInfo.Type, name, err)
+			log.Printf("GetUpdatedNode SetValueToFieldByNameFail NodeType %s, FieldName %s, Err %v", nodeInfo.Type, name, err)
 		}
 	}
+	return node
 }
 
 func CloneNodeInfo(nodeInfo *model.NodeInfo) *model.NodeInfo {
@@ -22,6 +22,7 @@ import (
 var (
 	versionRegexp *regexp.Regexp = regexp.MustCompile(`^v\d+(\.\d+)?`)
 	nameRegexp    *regexp.Regexp = regexp.MustCompile(`[a-zA-Z0-9_]+$`)
+	identRegexp   *regexp.Regexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 )
 
 // GetFunc searches for a function declaration with the given name in the specified file's AST.
@@ -40,6 +41,17 @@ func GetFunc(f *ast.File, funcName string) *ast.FuncDecl {
 	return nil
 }
 
+// StripTypeArgs returns the generic function or type of an instantiation like F[int] or T[K, V].
+func StripTypeArgs(expr ast.Expr) ast.Expr {
+	switch x := expr.(type) {
+	case *ast.IndexExpr:
+		return x.X
+	case *ast.IndexListExpr:
+		return x.X
+	}
+	return expr
+}
+
 func PrintFunc(fset *token.FileSet, funcDecl *ast.FuncDecl) {
 	// Print the FuncDecl
 	err := printer.Fprint(os.Stdout, fset, funcDecl)
@@ -81,6 +93,16 @@ func StringSliceToKey(slice []string) string {
 	return strings.Join(sliceCopy, ",")
 }
 
+// SortedKeys returns the keys of a map in sorted order, for a deterministic iteration.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func MergeAndDeduplicate(slice1, slice2 []string) []string {
 	// Create a map to keep track of unique elements
 	uniqueElements := make(map[string]bool)
@@ -107,10 +129,11 @@ func MergeAndDeduplicate(slice1, slice2 []string) []string {
 	return mergedSlice
 }
 
+// GetFuncTaskKey returns the key of the function of funcTask, a task for *List[T] and one for *List[E] share it.
 func GetFuncTaskKey(funcTask model.FuncTask) model.FuncTaskKey {
 	return model.FuncTaskKey{
 		Source:    funcTask.Source,
-		RecvTypes: funcTask.RecvTypes,
+		RecvTypes: StripRecvTypeParams(funcTask.RecvTypes),
 		FuncName:  funcTask.FuncName,
 	}
 }
@@ -198,6 +221,45 @@ func SetSubTask(funcTask *model.FuncTask) {
 	funcTask.FuncCallerKeys = nil
 	funcTask.ExtraImports = nil
 	funcTask.VarNames = nil
+	funcTask.DataflowVarNames = nil
+	funcTask.FindCallers = false
+	funcTask.CallerDepth = 0
+	funcTask.SliceTarget = ""
+	funcTask.GlobalAccess = nil
+}
+
+// NormalizeRecvTypes returns a receiver as the keys of FuncMap spell it, e.g. "*Svc" for "(s *Svc)" or " *Svc ".
+func NormalizeRecvTypes(recvTypes string) string {
+	recvTypes = strings.TrimSpace(recvTypes)
+	if strings.HasPrefix(recvTypes, "(") && strings.HasSuffix(recvTypes, ")") {
+		recvTypes = strings.TrimSpace(recvTypes[1 : len(recvTypes)-1])
+	}
+	// the name of the receiver, not a space in the type arguments
+	if name, recvType, ok := strings.Cut(recvTypes, " "); ok && identRegexp.MatchString(name) {
+		recvTypes = strings.TrimSpace(recvType)
+	}
+	return StripRecvTypeParams(recvTypes)
+}
+
+// StripRecvTypeParams returns the receivers of recvTypes without their type parameters,
+// e.g. "*List" for "*List[T]", so that a method of a generic type is found whatever its type parameters are named.
+func StripRecvTypeParams(recvTypes string) string {
+	if !strings.Contains(recvTypes, "[") {
+		return recvTypes
+	}
+	var builder strings.Builder
+	depth := 0
+	for _, r := range recvTypes {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		case depth == 0:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
 }
 
 // ParseFuncCall parses a string of the form "r|a.F" and returns r, a, and F.
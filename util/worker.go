@@ -0,0 +1,23 @@
+package util
+
+import "sync"
+
+// RunWorkers calls run with 0 to count-1, on at most parallel goroutines, and waits for them.
+func RunWorkers(parallel int, count int, run func(idx int)) {
+	idxChan := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < parallel && worker < count; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxChan {
+				run(idx)
+			}
+		}()
+	}
+	for idx := 0; idx < count; idx++ {
+		idxChan <- idx
+	}
+	close(idxChan)
+	wg.Wait()
+}